@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sort"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"nghost/internal/config"
+	"nghost/internal/nkn"
+)
+
+const (
+	benchProbes      = 50
+	benchPackets     = 2000
+	benchPacketSize  = 1200
+	benchWarmup      = 30 * time.Second
+	benchProbeWait   = 5 * time.Second
+	benchTransferMax = 60 * time.Second
+
+	benchKindProbe = 'p'
+	benchKindData  = 'd'
+)
+
+type benchResult struct {
+	transport  string
+	rttP50     time.Duration
+	rttP99     time.Duration
+	throughput float64 // bits per second
+	loss       float64
+}
+
+// benchSink stands in for the VPN engine of a benchmark client. It echoes
+// probes back to the sender and counts data packets.
+type benchSink struct {
+	client   *nkn.Client
+	echoes   chan []byte
+	received atomic.Int64
+	bytes    atomic.Int64
+}
+
+func (s *benchSink) InjectPacketFrom(src string, packet []byte) error {
+	if len(packet) == 0 {
+		return nil
+	}
+	switch packet[0] {
+	case benchKindProbe:
+		if s.echoes != nil {
+			select {
+			case s.echoes <- packet:
+			default:
+			}
+			return nil
+		}
+		return s.client.SendPacket(src, packet)
+	case benchKindData:
+		s.received.Add(1)
+		s.bytes.Add(int64(len(packet)))
+	}
+	return nil
+}
+
+// benchTransport compares message and session transport by running two
+// NKN clients with ephemeral identities in this process and sending probe
+// and bulk traffic between them over the real NKN network.
+func benchTransport(configPath string) error {
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	var results []benchResult
+	for _, transport := range []string{nkn.TransportMessage, nkn.TransportSession} {
+		fmt.Printf("⏱️  Benchmarking %s transport...\n", transport)
+		result, err := benchRun(cfg.NKN, transport)
+		if err != nil {
+			return fmt.Errorf("%s transport: %w", transport, err)
+		}
+		results = append(results, result)
+	}
+
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TRANSPORT\tRTT P50\tRTT P99\tTHROUGHPUT\tLOSS")
+	fmt.Fprintln(w, "---------\t-------\t-------\t----------\t----")
+	for _, r := range results {
+		fmt.Fprintf(w, "%s\t%v\t%v\t%.2f Mbit/s\t%.1f%%\n",
+			r.transport,
+			r.rttP50.Round(time.Millisecond),
+			r.rttP99.Round(time.Millisecond),
+			r.throughput/1e6,
+			r.loss*100)
+	}
+	w.Flush()
+	fmt.Printf("\n%d probes, %d packets of %d bytes per transport\n", benchProbes, benchPackets, benchPacketSize)
+	return nil
+}
+
+func benchRun(base config.NKNConfig, transport string) (benchResult, error) {
+	result := benchResult{transport: transport}
+
+	// Fresh identities and no peer store, so the benchmark never touches the
+	// node's own state
+	base.IdentityFile = ""
+	base.PeersFile = ""
+	base.Transport = transport
+
+	sender, err := nkn.NewClient(base)
+	if err != nil {
+		return result, err
+	}
+	defer sender.Close()
+	receiver, err := nkn.NewClient(base)
+	if err != nil {
+		return result, err
+	}
+	defer receiver.Close()
+
+	senderSink := &benchSink{client: sender, echoes: make(chan []byte, benchProbes)}
+	receiverSink := &benchSink{client: receiver}
+	sender.SetVPNEngine(senderSink)
+	receiver.SetVPNEngine(receiverSink)
+	dest := receiver.GetAddress()
+
+	// Wait until probes make it across, and for sessions to be set up
+	deadline := time.Now().Add(benchWarmup)
+	for {
+		if time.Now().After(deadline) {
+			return result, fmt.Errorf("no connection to the receiver after %v", benchWarmup)
+		}
+		_, err := benchProbe(sender, senderSink, dest, 0, time.Second)
+		if err == nil && (transport != nkn.TransportSession || sender.SessionActive(dest)) {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	rtts := make([]time.Duration, 0, benchProbes)
+	for seq := uint64(1); seq <= benchProbes; seq++ {
+		rtt, err := benchProbe(sender, senderSink, dest, seq, benchProbeWait)
+		if err == nil {
+			rtts = append(rtts, rtt)
+		}
+	}
+	if len(rtts) == 0 {
+		return result, fmt.Errorf("all probes lost")
+	}
+	sort.Slice(rtts, func(i, j int) bool { return rtts[i] < rtts[j] })
+	result.rttP50 = rtts[len(rtts)/2]
+	result.rttP99 = rtts[(len(rtts)*99)/100]
+
+	packet := make([]byte, benchPacketSize)
+	packet[0] = benchKindData
+	start := time.Now()
+	for i := 0; i < benchPackets; i++ {
+		if err := sender.SendPacket(dest, packet); err != nil {
+			return result, fmt.Errorf("failed to send packet: %w", err)
+		}
+	}
+
+	// Packets still in flight are counted until the receiver goes quiet
+	last, lastCount := time.Now(), int64(0)
+	for receiverSink.received.Load() < benchPackets && time.Since(start) < benchTransferMax {
+		time.Sleep(50 * time.Millisecond)
+		if n := receiverSink.received.Load(); n != lastCount {
+			last, lastCount = time.Now(), n
+		} else if time.Since(last) > benchProbeWait {
+			break
+		}
+	}
+	if lastCount == 0 {
+		return result, fmt.Errorf("no packets received")
+	}
+
+	result.throughput = float64(receiverSink.bytes.Load()*8) / last.Sub(start).Seconds()
+	result.loss = 1 - float64(receiverSink.received.Load())/benchPackets
+	return result, nil
+}
+
+// benchProbe sends a probe to dest and waits for its echo.
+func benchProbe(sender *nkn.Client, sink *benchSink, dest string, seq uint64, timeout time.Duration) (time.Duration, error) {
+	probe := make([]byte, 9)
+	probe[0] = benchKindProbe
+	binary.BigEndian.PutUint64(probe[1:], seq)
+
+	start := time.Now()
+	if err := sender.SendPacket(dest, probe); err != nil {
+		return 0, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case echo := <-sink.echoes:
+			if len(echo) == len(probe) && binary.BigEndian.Uint64(echo[1:]) == seq {
+				return time.Since(start), nil
+			}
+			// Late echo of an earlier probe
+		case <-timer.C:
+			return 0, fmt.Errorf("probe %d timed out", seq)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"nghost/internal/config"
+	"nghost/internal/control"
+	"nghost/internal/nkn"
+	"nghost/internal/vpn"
+)
+
+// startControlServer exposes the running engine to CLI commands.
+func startControlServer(path string, engine *vpn.Engine, nknClient *nkn.Client) (*control.Server, error) {
+	srv := control.NewServer(path)
+
+	srv.Handle(control.MethodStatus, func(json.RawMessage) (interface{}, error) {
+		return engine.Status(), nil
+	})
+	srv.Handle(control.MethodPeers, func(json.RawMessage) (interface{}, error) {
+		return nknClient.GetPeers(), nil
+	})
+	srv.Handle(control.MethodRoutes, func(json.RawMessage) (interface{}, error) {
+		return engine.Routes(), nil
+	})
+	srv.Handle(control.MethodExitNodes, func(json.RawMessage) (interface{}, error) {
+		return nknClient.FindExitNodes(), nil
+	})
+	srv.Handle(control.MethodAddPeer, func(params json.RawMessage) (interface{}, error) {
+		var p control.AddPeerParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, fmt.Errorf("invalid params: %w", err)
+		}
+		if p.Address == "" {
+			return nil, errors.New("missing peer address")
+		}
+		if err := nknClient.ValidateAddress(p.Address); err != nil {
+			return nil, err
+		}
+		nknClient.AddPeer(p.Address)
+		return nil, nil
+	})
+
+	if err := srv.Start(); err != nil {
+		return nil, err
+	}
+	return srv, nil
+}
+
+// callDaemon queries the running daemon. The returned bool is false when no
+// daemon is listening, so callers can fall back to the on-disk state.
+func callDaemon(cfg *config.Config, method string, params, result interface{}) (bool, error) {
+	err := control.Call(cfg.Control.SocketPath, method, params, result)
+	if errors.Is(err, control.ErrUnavailable) {
+		return false, nil
+	}
+	return true, err
+}
+
+func listRoutes(configPath string) error {
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	var routes []vpn.Route
+	ok, err := callDaemon(cfg, control.MethodRoutes, nil, &routes)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("NGhost daemon is not running")
+	}
+
+	if len(routes) == 0 {
+		fmt.Println("No routes installed.")
+		return nil
+	}
+
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Prefix != routes[j].Prefix {
+			return routes[i].Prefix.String() < routes[j].Prefix.String()
+		}
+		return routes[i].Metric < routes[j].Metric
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DESTINATION\tVIA\tPEER\tMETRIC")
+	fmt.Fprintln(w, "-----------\t---\t----\t------")
+	for _, route := range routes {
+		peer := route.Peer
+		if route.Kind != vpn.RoutePeer {
+			peer = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\n", route.Prefix, route.Kind, peer, route.Metric)
+	}
+	return w.Flush()
+}
+
+func showEngineStatus(configPath string) {
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return
+	}
+
+	var status vpn.Status
+	ok, err := callDaemon(cfg, control.MethodStatus, nil, &status)
+	if err != nil {
+		fmt.Printf("❌ Failed to query daemon: %v\n\n", err)
+		return
+	}
+	if !ok {
+		fmt.Printf("⏹️  NGhost daemon is not running\n\n")
+		return
+	}
+
+	fmt.Println("🚀 NGhost Engine Status:")
+	fmt.Printf("  NKN address: %s\n", status.NKNAddress)
+	fmt.Printf("  Interface:   %s (%s)\n", status.Interface, status.CIDR)
+	fmt.Printf("  VPN IP:      %s\n", status.VPNIP)
+	if status.VPNIP6 != "" {
+		fmt.Printf("  VPN IPv6:    %s\n", status.VPNIP6)
+	}
+	fmt.Printf("  Exit node:   %v\n", status.ExitNode)
+	fmt.Printf("  Peers:       %d\n", status.Peers)
+	fmt.Printf("  Routes:      %d\n", status.Routes)
+	fmt.Printf("  Dropped:     %d spoofed, %d malformed\n", status.Dropped.Spoofed, status.Dropped.Malformed)
+	fmt.Printf("  Exit nodes:  %d available\n", len(status.ExitNodes))
+	for _, addr := range status.ExitNodes {
+		marker := ""
+		if addr == status.ActiveExit {
+			marker = " (active)"
+		}
+		fmt.Printf("    🚪 %s%s\n", addr, marker)
+	}
+	fmt.Println()
+}
@@ -2,7 +2,11 @@ module nghost
 
 go 1.21
 
-require github.com/nknorg/nkn-sdk-go v1.4.7
+require (
+	github.com/nknorg/ncp-go v1.0.6
+	github.com/nknorg/nkn-sdk-go v1.4.7
+	google.golang.org/protobuf v1.33.0
+)
 
 require (
 	github.com/golang/protobuf v1.5.2 // indirect
@@ -11,7 +15,6 @@ require (
 	github.com/hashicorp/go-multierror v1.0.0 // indirect
 	github.com/imdario/mergo v0.3.16 // indirect
 	github.com/itchyny/base58-go v0.2.1 // indirect
-	github.com/nknorg/ncp-go v1.0.6 // indirect
 	github.com/nknorg/nkn/v2 v2.2.1 // indirect
 	github.com/nknorg/nkngomobile v0.0.0-20220615081414-671ad1afdfa9 // indirect
 	github.com/patrickmn/go-cache v2.1.0+incompatible // indirect
@@ -19,5 +22,4 @@ require (
 	golang.org/x/crypto v0.21.0 // indirect
 	golang.org/x/mobile v0.0.0-20230301163155-e0f57694e12c // indirect
 	golang.org/x/sys v0.18.0 // indirect
-	google.golang.org/protobuf v1.33.0 // indirect
 )
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"nghost/internal/config"
+	"nghost/internal/identity"
+)
+
+func identityCmd(args []string) error {
+	fs := flag.NewFlagSet("identity", flag.ExitOnError)
+	configPath := fs.String("config", "config.json", "Path to configuration file")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: nghost identity [-config path] <show|export|import|rotate> [file]")
+		fmt.Fprintln(fs.Output(), "  show           Show the node's NKN address")
+		fmt.Fprintln(fs.Output(), "  export [file]  Write the secret identity to file (default: stdout)")
+		fmt.Fprintln(fs.Output(), "  import [file]  Replace the identity from file (default: stdin), keeping a backup")
+		fmt.Fprintln(fs.Output(), "  rotate         Generate a new identity, keeping a backup of the old one")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return fmt.Errorf("missing identity command")
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	store := identity.NewStore(cfg.NKN.IdentityFile)
+
+	switch fs.Arg(0) {
+	case "show":
+		return showIdentity(store)
+	case "export":
+		return exportIdentity(store, fs.Arg(1))
+	case "import":
+		return importIdentity(store, fs.Arg(1))
+	case "rotate":
+		return rotateIdentity(store)
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown identity command: %s", fs.Arg(0))
+	}
+}
+
+func showIdentity(store *identity.Store) error {
+	id, created, err := store.LoadOrCreate()
+	if err != nil {
+		return err
+	}
+	account, err := id.Account()
+	if err != nil {
+		return err
+	}
+	address, _ := id.Address()
+
+	if created {
+		fmt.Printf("🔑 Generated new node identity\n")
+	}
+	fmt.Printf("Identity file:  %s\n", store.Path())
+	fmt.Printf("NKN address:    %s\n", address)
+	fmt.Printf("Wallet address: %s\n", account.WalletAddress())
+	fmt.Printf("Created:        %s\n", id.CreatedAt.Format("2006-01-02 15:04:05 MST"))
+	return nil
+}
+
+func exportIdentity(store *identity.Store, outputPath string) error {
+	id, err := store.Load()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("no identity at %s (run 'nghost identity show' to create one)", store.Path())
+		}
+		return err
+	}
+
+	data, err := json.MarshalIndent(id, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if outputPath == "" {
+		fmt.Println(string(data))
+		return nil
+	}
+	if err := os.WriteFile(outputPath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	fmt.Printf("Exported identity to %s\n", outputPath)
+	fmt.Printf("⚠️  This file contains your secret key - keep it safe\n")
+	return nil
+}
+
+func importIdentity(store *identity.Store, inputPath string) error {
+	var data []byte
+	var err error
+	if inputPath == "" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(inputPath)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read identity: %w", err)
+	}
+
+	id, err := identity.Parse(data)
+	if err != nil {
+		return err
+	}
+	backup, err := store.Replace(id)
+	if err != nil {
+		return fmt.Errorf("failed to save identity: %w", err)
+	}
+
+	address, _ := id.Address()
+	fmt.Printf("Imported identity: %s\n", address)
+	if backup != "" {
+		fmt.Printf("Previous identity saved to %s\n", backup)
+	}
+	return nil
+}
+
+func rotateIdentity(store *identity.Store) error {
+	id, backup, err := store.Rotate()
+	if err != nil {
+		return err
+	}
+
+	address, _ := id.Address()
+	fmt.Printf("🔄 New NKN address: %s\n", address)
+	if backup != "" {
+		fmt.Printf("Previous identity saved to %s\n", backup)
+	}
+	fmt.Printf("⚠️  Peers must be updated with the new address\n")
+	return nil
+}
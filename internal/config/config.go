@@ -1,13 +1,20 @@
 package config
 
 import (
+	"crypto/rand"
 	"encoding/json"
+	"fmt"
+	"net/netip"
 	"os"
+	"path/filepath"
 )
 
 type Config struct {
-	NKN NKNConfig `json:"nkn"`
-	VPN VPNConfig `json:"vpn"`
+	NKN     NKNConfig     `json:"nkn"`
+	VPN     VPNConfig     `json:"vpn"`
+	Control ControlConfig `json:"control"`
+	Metrics MetricsConfig `json:"metrics"`
+	Log     LogConfig     `json:"log"`
 }
 
 type NKNConfig struct {
@@ -17,18 +24,115 @@ type NKNConfig struct {
 		RPCTimeout        int      `json:"rpcTimeout"`
 		RPCConcurrency    int      `json:"rpcConcurrency"`
 	} `json:"clientConfig"`
+	IdentityFile string         `json:"identityFile,omitempty"`
+	PeersFile    string         `json:"peersFile,omitempty"`
+	Liveness     LivenessConfig `json:"liveness"`
+	// Transport selects how IP packets are carried: "message" (default)
+	// sends each packet as an NKN message, "session" streams them over one
+	// NKN session per peer and "udp" bypasses NKN with sealed UDP datagrams.
+	Transport string    `json:"transport,omitempty"`
+	UDP       UDPConfig `json:"udp"`
+}
+
+// UDPConfig configures the UDP transport. Listen is the local host:port;
+// Address is the host:port peers reach this node at and defaults to Listen.
+// Key authenticates datagrams; Load fills it in from VPN.NetworkKey.
+type UDPConfig struct {
+	Listen  string `json:"listen,omitempty"`
+	Address string `json:"address,omitempty"`
+	Key     string `json:"-"`
+}
+
+// LivenessConfig holds the peer liveness timeouts in seconds. Zero values use
+// the defaults.
+type LivenessConfig struct {
+	PingInterval int `json:"pingInterval,omitempty"`
+	StaleAfter   int `json:"staleAfter,omitempty"`
+	OfflineAfter int `json:"offlineAfter,omitempty"`
+	RemoveAfter  int `json:"removeAfter,omitempty"`
+}
+
+type ControlConfig struct {
+	SocketPath string `json:"socketPath,omitempty"`
+}
+
+// LogConfig controls logging. Level is "debug", "info" (default), "warn" or
+// "error" and Format is "text" (default) or "json". Subsystems overrides the
+// level of single subsystems (main, nkn, vpn, tun, control, metrics), e.g.
+// {"tun": "debug"}.
+type LogConfig struct {
+	Level      string            `json:"level,omitempty"`
+	Format     string            `json:"format,omitempty"`
+	Subsystems map[string]string `json:"subsystems,omitempty"`
+}
+
+// MetricsConfig enables the Prometheus endpoint. Listen is the host:port
+// serving /metrics, e.g. "127.0.0.1:9477"; empty disables it.
+type MetricsConfig struct {
+	Listen string `json:"listen,omitempty"`
 }
 
 type VPNConfig struct {
-	InterfaceName string   `json:"interfaceName"`
-	CIDR          string   `json:"cidr"`
-	MTU           int      `json:"mtu"`
-	DNS           []string `json:"dns"`
-	ExitNodes     []string `json:"exitNodes"`
+	InterfaceName string `json:"interfaceName"`
+	CIDR          string `json:"cidr"`
+	// CIDR6 is the IPv6 ULA prefix peers get addresses from. New configs
+	// get a random one. Empty disables IPv6 inside the VPN.
+	CIDR6     string   `json:"cidr6,omitempty"`
+	MTU       int      `json:"mtu"`
+	DNS       []string `json:"dns"`
+	ExitNodes []string `json:"exitNodes"`
+	// ExitNodePolicy picks the exit node for new flows: "latency" (default)
+	// uses the lowest RTT, "pinned" prefers ExitNodes in order.
+	ExitNodePolicy string `json:"exitNodePolicy,omitempty"`
+	// AllowedPeers lists the NKN public keys (or client addresses) allowed
+	// to join. Empty allows any peer.
+	AllowedPeers []string `json:"allowedPeers,omitempty"`
+	// NetworkName enables peer discovery through an NKN pub/sub topic
+	// derived from the name and NetworkKey.
+	NetworkName string `json:"networkName,omitempty"`
+	// NetworkKey is a shared secret every member proves knowledge of in
+	// its announcements.
+	NetworkKey string `json:"networkKey,omitempty"`
+	// AdvertiseRoutes lists local subnets this node routes for the VPN.
+	AdvertiseRoutes []string `json:"advertiseRoutes,omitempty"`
+	// AcceptRoutes installs the subnets other peers advertise. Off by
+	// default, since a peer's routes take precedence over local ones.
+	AcceptRoutes bool `json:"acceptRoutes,omitempty"`
+	// AcceptedRoutes limits AcceptRoutes to subnets inside these prefixes.
+	// Empty accepts any subnet.
+	AcceptedRoutes []string `json:"acceptedRoutes,omitempty"`
+	// StaticIPs pins NKN addresses to fixed VPN IPs.
+	StaticIPs map[string]string `json:"staticIPs,omitempty"`
+	// LeaseCoordinator is the NKN address of the node handing out address
+	// leases. Without one, addresses are derived from the NKN address.
+	LeaseCoordinator string `json:"leaseCoordinator,omitempty"`
+	// KillSwitch blocks all traffic leaving the host outside the tunnel
+	// except the NKN transport, also while no exit node is reachable.
+	KillSwitch bool `json:"killSwitch,omitempty"`
+	// FullTunnel routes all internet traffic of a client through exit
+	// nodes instead of only the VPN network.
+	FullTunnel bool `json:"fullTunnel,omitempty"`
+	// SplitTunnel chooses the internet destinations that go through exit
+	// nodes.
+	SplitTunnel SplitTunnelConfig `json:"splitTunnel"`
+}
+
+// SplitTunnelConfig lists CIDRs, IP addresses and domain names. With Include
+// set only those destinations use the tunnel; Exclude keeps destinations out
+// of it, e.g. in full-tunnel mode. Domains are resolved again every
+// RefreshInterval seconds (default 300).
+type SplitTunnelConfig struct {
+	Include         []string `json:"include,omitempty"`
+	Exclude         []string `json:"exclude,omitempty"`
+	RefreshInterval int      `json:"refreshInterval,omitempty"`
 }
 
 func Load(path string) (*Config, error) {
 	if _, err := os.Stat(path); os.IsNotExist(err) {
+		cidr6, err := newULAPrefix()
+		if err != nil {
+			return nil, err
+		}
 		cfg := &Config{
 			NKN: NKNConfig{
 				SeedRPCServerAddr: []string{
@@ -40,12 +144,17 @@ func Load(path string) (*Config, error) {
 			VPN: VPNConfig{
 				InterfaceName: "nghost0",
 				CIDR:          "10.100.0.0/16",
+				CIDR6:         cidr6,
 				MTU:           1420,
 				DNS:           []string{"1.1.1.1", "8.8.8.8"},
 				ExitNodes:     []string{},
 			},
 		}
-		return cfg, Save(cfg, path)
+		if err := Save(cfg, path); err != nil {
+			return cfg, err
+		}
+		cfg.resolvePaths(path)
+		return cfg, nil
 	}
 
 	data, err := os.ReadFile(path)
@@ -58,9 +167,41 @@ func Load(path string) (*Config, error) {
 		return nil, err
 	}
 
+	cfg.resolvePaths(path)
+	cfg.NKN.UDP.Key = cfg.VPN.NetworkKey
 	return &cfg, nil
 }
 
+// newULAPrefix returns the first /64 of a unique local /48 with a random
+// global ID (RFC 4193), so separate networks do not share a prefix.
+func newULAPrefix() (string, error) {
+	var addr [16]byte
+	addr[0] = 0xfd
+	if _, err := rand.Read(addr[1:6]); err != nil {
+		return "", fmt.Errorf("failed to generate IPv6 prefix: %w", err)
+	}
+	return netip.PrefixFrom(netip.AddrFrom16(addr), 64).String(), nil
+}
+
+// resolvePaths makes file paths in the config relative to the directory of
+// the config file, so state lives next to config.json by default.
+func (c *Config) resolvePaths(configPath string) {
+	dir := filepath.Dir(configPath)
+	c.NKN.IdentityFile = resolvePath(dir, c.NKN.IdentityFile, "identity.json")
+	c.NKN.PeersFile = resolvePath(dir, c.NKN.PeersFile, "peers.json")
+	c.Control.SocketPath = resolvePath(dir, c.Control.SocketPath, "nghost.sock")
+}
+
+func resolvePath(dir, path, defaultName string) string {
+	if path == "" {
+		path = defaultName
+	}
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
 func Save(cfg *Config, path string) error {
 	data, err := json.MarshalIndent(cfg, "", "  ")
 	if err != nil {
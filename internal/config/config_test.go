@@ -0,0 +1,41 @@
+package config
+
+import (
+	"net/netip"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewConfigGetsRandomULAPrefix(t *testing.T) {
+	dir := t.TempDir()
+	a, err := Load(filepath.Join(dir, "a.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Load(filepath.Join(dir, "b.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, cidr := range []string{a.VPN.CIDR6, b.VPN.CIDR6} {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if prefix.Bits() != 64 || prefix.Addr().As16()[0] != 0xfd {
+			t.Fatalf("got %s, want a /64 in fd00::/8", prefix)
+		}
+	}
+	if a.VPN.CIDR6 == b.VPN.CIDR6 {
+		t.Fatalf("both configs got %s", a.VPN.CIDR6)
+	}
+
+	// The prefix is saved with the config
+	again, err := Load(filepath.Join(dir, "a.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.VPN.CIDR6 != a.VPN.CIDR6 {
+		t.Fatalf("reloaded %s, want %s", again.VPN.CIDR6, a.VPN.CIDR6)
+	}
+}
@@ -0,0 +1,54 @@
+package control
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+)
+
+// ErrUnavailable is returned by Call when no daemon is listening.
+var ErrUnavailable = errors.New("daemon not running")
+
+// Call sends a request to the daemon listening on the control socket at path
+// and decodes its result into result, which may be nil. Only a missing socket
+// or one nobody listens on means the daemon is not running; other errors,
+// e.g. no permission to use the socket, are returned as they are.
+func Call(path, method string, params, result interface{}) error {
+	conn, err := net.DialTimeout("unix", path, 2*time.Second)
+	if errors.Is(err, syscall.ENOENT) || errors.Is(err, syscall.ECONNREFUSED) {
+		return fmt.Errorf("%w (%s): %v", ErrUnavailable, path, err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to daemon: %w", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	req := Request{Method: method}
+	if params != nil {
+		data, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		req.Params = data
+	}
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.Error != "" {
+		return &RemoteError{Method: method, Message: resp.Error}
+	}
+	if result != nil && len(resp.Result) > 0 {
+		return json.Unmarshal(resp.Result, result)
+	}
+	return nil
+}
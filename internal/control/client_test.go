@@ -0,0 +1,41 @@
+package control
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCallUnavailable(t *testing.T) {
+	dir := t.TempDir()
+
+	// No socket at all
+	if err := Call(filepath.Join(dir, "missing.sock"), MethodStatus, nil, nil); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("missing socket: got %v, want ErrUnavailable", err)
+	}
+
+	// A socket left behind by a daemon that is gone
+	stale := filepath.Join(dir, "stale.sock")
+	l, err := net.Listen("unix", stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+	if err := Call(stale, MethodStatus, nil, nil); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("stale socket: got %v, want ErrUnavailable", err)
+	}
+}
+
+func TestCallReturnsOtherDialErrors(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	err := Call(filepath.Join(file, "nghost.sock"), MethodStatus, nil, nil)
+	if err == nil || errors.Is(err, ErrUnavailable) {
+		t.Fatalf("got %v, want an error other than ErrUnavailable", err)
+	}
+}
@@ -0,0 +1,41 @@
+package control
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Request is a single call on the control socket. Each connection carries
+// one newline-delimited JSON request followed by one response.
+type Request struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+type Response struct {
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+const (
+	MethodStatus    = "status"
+	MethodPeers     = "peers"
+	MethodRoutes    = "routes"
+	MethodExitNodes = "exit_nodes"
+	MethodAddPeer   = "add_peer"
+)
+
+type AddPeerParams struct {
+	Address string `json:"address"`
+}
+
+// RemoteError is returned by Call when the daemon handled the request but
+// reported a failure.
+type RemoteError struct {
+	Method  string
+	Message string
+}
+
+func (e *RemoteError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Method, e.Message)
+}
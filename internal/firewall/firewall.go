@@ -0,0 +1,145 @@
+// Package firewall installs the forwarding and NAT rules NGhost needs in a
+// table (nftables) or chains (iptables) it owns, so they never mix with the
+// rules of other software. The whole ruleset is replaced on every change,
+// which makes applying it idempotent, and is removed in one step on shutdown.
+package firewall
+
+import (
+	"fmt"
+	"net/netip"
+	"os/exec"
+	"runtime"
+
+	"nghost/internal/netlink"
+)
+
+// Table is the name of the nftables table NGhost owns; the iptables chains
+// use it as prefix.
+const Table = "nghost"
+
+// Ports of DHCP requests, which the kill switch lets through
+const (
+	dhcpClientPort  = 68
+	dhcpServerPort  = 67
+	dhcp6ClientPort = 546
+	dhcp6ServerPort = 547
+)
+
+// Masquerade rewrites the source address of matching packets to the address
+// of the interface they leave through. Unset fields match any packet.
+type Masquerade struct {
+	Source       netip.Prefix
+	Destination  netip.Prefix
+	OutInterface string
+}
+
+// Forward accepts matching forwarded packets. Unset fields match any packet.
+// Established limits the rule to packets of connections that were already
+// accepted in the other direction, i.e. replies.
+type Forward struct {
+	InInterface  string
+	OutInterface string
+	Source       netip.Prefix
+	Destination  netip.Prefix
+	Established  bool
+}
+
+// KillSwitch rejects traffic the host sends outside the tunnel. Packets
+// leave only through the loopback interface, the interfaces listed here or
+// from sockets carrying Mark, apart from DHCP and IPv6 neighbor discovery,
+// which keep the physical link up.
+type KillSwitch struct {
+	Interfaces []string
+}
+
+// Ruleset is the complete set of rules NGhost wants installed.
+type Ruleset struct {
+	Masquerade []Masquerade
+	Forward    []Forward
+	KillSwitch *KillSwitch
+}
+
+// Merge returns a ruleset with the rules of both. The kill switch of other
+// replaces that of r if set.
+func (r Ruleset) Merge(other Ruleset) Ruleset {
+	merged := Ruleset{
+		Masquerade: append(append([]Masquerade(nil), r.Masquerade...), other.Masquerade...),
+		Forward:    append(append([]Forward(nil), r.Forward...), other.Forward...),
+		KillSwitch: r.KillSwitch,
+	}
+	if other.KillSwitch != nil {
+		merged.KillSwitch = other.KillSwitch
+	}
+	return merged
+}
+
+// Firewall manages the NGhost-owned table or chains.
+type Firewall interface {
+	// Name returns the backend name.
+	Name() string
+	// Apply atomically replaces the installed rules with rules.
+	Apply(rules Ruleset) error
+	// Remove deletes the NGhost-owned table or chains. Removing rules that
+	// are not installed is not an error.
+	Remove() error
+}
+
+// New returns the nftables backend if the nft command is available and the
+// iptables backend otherwise. Accepting a packet in the NGhost table does not
+// stop the forward chains of other tables from dropping it, so when the
+// iptables filter table drops forwarded packets by default, as Docker and
+// ufw set it up, the iptables backend is used to add rules there instead.
+func New() (Firewall, error) {
+	if runtime.GOOS != "linux" {
+		return nil, fmt.Errorf("firewall rules are only managed on Linux")
+	}
+	if path, err := exec.LookPath("nft"); err == nil {
+		n := &nftables{nft: path}
+		if drops, err := n.forwardDrops(); err == nil && len(drops) > 0 && iptablesOnly(drops) {
+			if fw, ok := newIPTables("iptables", "ip6tables"); ok && fw.nftBased() {
+				return fw, nil
+			}
+		}
+		return n, nil
+	}
+	if fw, ok := newIPTables("iptables", "ip6tables"); ok {
+		return fw, nil
+	}
+	if fw, ok := newIPTables("iptables-legacy", "ip6tables-legacy"); ok {
+		return fw, nil
+	}
+	return nil, fmt.Errorf("neither nft nor iptables found in PATH")
+}
+
+// EgressInterface returns the interface of the default route for the address
+// family of addr (IPv4 if addr is unset).
+func EgressInterface(addr netip.Addr) (string, error) {
+	route, err := netlink.DefaultGateway(addr)
+	if err != nil {
+		return "", fmt.Errorf("failed to find default route: %w", err)
+	}
+	if route.Link == "" {
+		return "", fmt.Errorf("default route %s has no interface", route)
+	}
+	return route.Link, nil
+}
+
+// ruleFamily returns 4 or 6 if the rule only matches that address family and
+// 0 if it matches both.
+func ruleFamily(prefixes ...netip.Prefix) (int, error) {
+	family := 0
+	for _, p := range prefixes {
+		if !p.IsValid() {
+			continue
+		}
+		f := 4
+		if p.Addr().Is6() {
+			f = 6
+		}
+		if family != 0 && family != f {
+			return 0, fmt.Errorf("rule mixes IPv4 and IPv6 prefixes")
+		}
+		family = f
+	}
+	return family, nil
+}
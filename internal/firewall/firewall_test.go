@@ -0,0 +1,184 @@
+package firewall
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+var testRules = Ruleset{
+	Masquerade: []Masquerade{
+		{Source: netip.MustParsePrefix("10.100.0.0/16"), OutInterface: "eth0"},
+		{Source: netip.MustParsePrefix("fd6e:6768:6f73::/64"), OutInterface: "eth0"},
+	},
+	Forward: []Forward{
+		{InInterface: "nghost0", OutInterface: "eth0"},
+		{InInterface: "eth0", OutInterface: "nghost0", Established: true},
+		{InInterface: "nghost0", Destination: netip.MustParsePrefix("192.168.1.7/24")},
+	},
+}
+
+func TestNftScript(t *testing.T) {
+	script, err := nftScript(testRules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `table inet nghost {}
+delete table inet nghost
+table inet nghost {
+	chain forward {
+		type filter hook forward priority filter; policy accept;
+		iifname "nghost0" oifname "eth0" accept
+		iifname "eth0" oifname "nghost0" ct state established,related accept
+		iifname "nghost0" ip daddr 192.168.1.0/24 accept
+	}
+	chain postrouting {
+		type nat hook postrouting priority srcnat; policy accept;
+		oifname "eth0" ip saddr 10.100.0.0/16 masquerade
+		oifname "eth0" ip6 saddr fd6e:6768:6f73::/64 masquerade
+	}
+}
+`
+	if script != want {
+		t.Fatalf("got\n%s\nwant\n%s", script, want)
+	}
+}
+
+func TestNftScriptKillSwitch(t *testing.T) {
+	script, err := nftScript(Ruleset{KillSwitch: &KillSwitch{Interfaces: []string{"nghost0"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rule := range []string{
+		"\tchain output {\n\t\ttype filter hook output priority filter; policy accept;\n\t\toifname \"lo\" accept\n",
+		"\t\toifname \"nghost0\" accept\n",
+		"\t\tmeta mark 0x6e67 accept\n",
+		"\t\tmeta nfproto ipv4 udp sport 68 udp dport 67 accept\n",
+		"\t\tmeta nfproto ipv6 udp sport 546 udp dport 547 accept\n",
+		"\t\treject with icmpx type admin-prohibited\n\t}\n",
+	} {
+		if !strings.Contains(script, rule) {
+			t.Errorf("missing %q in\n%s", rule, script)
+		}
+	}
+}
+
+func TestIPTablesScript(t *testing.T) {
+	script, err := iptablesScript(4, testRules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `*filter
+:NGHOST-FORWARD - [0:0]
+:NGHOST-OUTPUT - [0:0]
+-A NGHOST-FORWARD -i nghost0 -o eth0 -j ACCEPT
+-A NGHOST-FORWARD -i eth0 -o nghost0 -m conntrack --ctstate ESTABLISHED,RELATED -j ACCEPT
+-A NGHOST-FORWARD -i nghost0 -d 192.168.1.0/24 -j ACCEPT
+COMMIT
+*nat
+:NGHOST-POSTROUTING - [0:0]
+-A NGHOST-POSTROUTING -o eth0 -s 10.100.0.0/16 -j MASQUERADE
+COMMIT
+`
+	if script != want {
+		t.Fatalf("got\n%s\nwant\n%s", script, want)
+	}
+
+	// IPv6 gets the rules without prefixes and the IPv6 ones
+	script, err = iptablesScript(6, testRules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(script, "192.168.1.0/24") || strings.Contains(script, "10.100.0.0/16") {
+		t.Errorf("IPv4 rules in IPv6 script:\n%s", script)
+	}
+	for _, rule := range []string{
+		"-A NGHOST-FORWARD -i nghost0 -o eth0 -j ACCEPT\n",
+		"-A NGHOST-FORWARD -i eth0 -o nghost0 -m conntrack --ctstate ESTABLISHED,RELATED -j ACCEPT\n",
+		"-A NGHOST-POSTROUTING -o eth0 -s fd6e:6768:6f73::/64 -j MASQUERADE\n",
+	} {
+		if !strings.Contains(script, rule) {
+			t.Errorf("missing %q in\n%s", rule, script)
+		}
+	}
+}
+
+func TestIPTablesScriptKillSwitch(t *testing.T) {
+	ks := Ruleset{KillSwitch: &KillSwitch{Interfaces: []string{"nghost0"}}}
+	script4, err := iptablesScript(4, ks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	script6, err := iptablesScript(6, ks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		script string
+		rules  []string
+	}{
+		{script4, []string{
+			"-A NGHOST-OUTPUT -o lo -j RETURN\n-A NGHOST-OUTPUT -o nghost0 -j RETURN\n-A NGHOST-OUTPUT -m mark --mark 0x6e67 -j RETURN\n",
+			"-A NGHOST-OUTPUT -p udp --sport 68 --dport 67 -j RETURN\n",
+			"-A NGHOST-OUTPUT -j REJECT --reject-with icmp-admin-prohibited\nCOMMIT\n",
+		}},
+		{script6, []string{
+			"-A NGHOST-OUTPUT -p udp --sport 546 --dport 547 -j RETURN\n",
+			"-A NGHOST-OUTPUT -p ipv6-icmp --icmpv6-type neighbour-solicitation -j RETURN\n",
+			"-A NGHOST-OUTPUT -j REJECT --reject-with icmp6-adm-prohibited\nCOMMIT\n",
+		}},
+	} {
+		for _, rule := range tt.rules {
+			if !strings.Contains(tt.script, rule) {
+				t.Errorf("missing %q in\n%s", rule, tt.script)
+			}
+		}
+	}
+}
+
+func TestMixedFamiliesRejected(t *testing.T) {
+	rules := Ruleset{Forward: []Forward{{
+		Source:      netip.MustParsePrefix("10.0.0.0/8"),
+		Destination: netip.MustParsePrefix("fd00::/8"),
+	}}}
+	if _, err := nftScript(rules); err == nil {
+		t.Error("nftables accepted a rule mixing IPv4 and IPv6")
+	}
+	if _, err := iptablesScript(4, rules); err == nil {
+		t.Error("iptables accepted a rule mixing IPv4 and IPv6")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	a := Ruleset{Forward: []Forward{{InInterface: "a"}}, KillSwitch: &KillSwitch{}}
+	b := Ruleset{Forward: []Forward{{InInterface: "b"}}, Masquerade: []Masquerade{{OutInterface: "eth0"}}}
+	merged := a.Merge(b)
+	if len(merged.Forward) != 2 || len(merged.Masquerade) != 1 || merged.KillSwitch != a.KillSwitch {
+		t.Fatalf("merged %+v", merged)
+	}
+	if len(a.Forward) != 1 {
+		t.Fatal("merge modified its receiver")
+	}
+}
+
+func TestParseForwardDrops(t *testing.T) {
+	// nft -j list chains with Docker (iptables-nft), firewalld and NGhost
+	output := `{"nftables": [{"metainfo": {"version": "1.0.6", "json_schema_version": 1}},
+{"chain": {"family": "ip", "table": "filter", "name": "FORWARD", "handle": 1, "type": "filter", "hook": "forward", "prio": 0, "policy": "drop"}},
+{"chain": {"family": "ip", "table": "filter", "name": "DOCKER", "handle": 5}},
+{"chain": {"family": "inet", "table": "firewalld", "name": "filter_FORWARD", "handle": 2, "type": "filter", "hook": "forward", "prio": 10, "policy": "accept"}},
+{"chain": {"family": "inet", "table": "nghost", "name": "forward", "handle": 1, "type": "filter", "hook": "forward", "prio": 0, "policy": "drop"}}]}`
+	drops, err := parseForwardDrops([]byte(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drops) != 1 || drops[0] != "ip filter FORWARD" {
+		t.Fatalf("got %v, want [ip filter FORWARD]", drops)
+	}
+	if !iptablesOnly(drops) {
+		t.Error("ip filter FORWARD not handled by iptables")
+	}
+	if iptablesOnly(append(drops, "inet custom forward")) {
+		t.Error("custom nftables table handled by iptables")
+	}
+}
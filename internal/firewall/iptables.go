@@ -0,0 +1,251 @@
+package firewall
+
+import (
+	"fmt"
+	"net/netip"
+	"os/exec"
+	"strings"
+)
+
+const (
+	forwardChain = "NGHOST-FORWARD"
+	outputChain  = "NGHOST-OUTPUT"
+	natChain     = "NGHOST-POSTROUTING"
+)
+
+// hooks are the built-in chains that jump to the NGhost-owned chains.
+var hooks = []struct{ table, chain, target string }{
+	{"filter", "FORWARD", forwardChain},
+	{"filter", "OUTPUT", outputChain},
+	{"nat", "POSTROUTING", natChain},
+}
+
+// iptables keeps the rules in the chains NGHOST-FORWARD, NGHOST-OUTPUT and
+// NGHOST-POSTROUTING, which are filled in one iptables-restore run each and
+// reached through a single jump from FORWARD, OUTPUT and POSTROUTING.
+type iptables struct {
+	name string
+	v4   string
+	v6   string // empty if ip6tables is not installed
+}
+
+func newIPTables(v4, v6 string) (*iptables, bool) {
+	path, err := exec.LookPath(v4)
+	if err != nil {
+		return nil, false
+	}
+	fw := &iptables{name: v4, v4: path}
+	if path, err := exec.LookPath(v6); err == nil {
+		fw.v6 = path
+	}
+	return fw, true
+}
+
+func (t *iptables) Name() string {
+	return t.name
+}
+
+// nftBased reports whether iptables is the nf_tables variant, which edits
+// the same ip filter table nft lists.
+func (t *iptables) nftBased() bool {
+	output, err := exec.Command(t.v4, "--version").Output()
+	return err == nil && strings.Contains(string(output), "nf_tables")
+}
+
+func (t *iptables) Apply(rules Ruleset) error {
+	for _, family := range []int{4, 6} {
+		binary := t.binary(family)
+		if binary == "" {
+			if hasPrefixes(rules, family) {
+				return fmt.Errorf("IPv%d rules need ip6tables, which is not installed", family)
+			}
+			// IPv6 traffic would bypass the kill switch
+			if rules.KillSwitch != nil {
+				return fmt.Errorf("the kill switch needs ip6tables, which is not installed")
+			}
+			continue
+		}
+
+		script, err := iptablesScript(family, rules)
+		if err != nil {
+			return err
+		}
+		if err := restore(binary, script); err != nil {
+			return err
+		}
+
+		for _, hook := range hooks {
+			jump := []string{"-t", hook.table, hook.chain, "-j", hook.target}
+			if run(binary, withCommand("-C", jump)...) == nil {
+				continue
+			}
+			if err := run(binary, withCommand("-I", jump)...); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// iptablesScript returns the iptables-restore input that fills the chains
+// with the rules for family. With --noflush, declaring an existing user chain
+// flushes it, so the chains end up with exactly these rules.
+func iptablesScript(family int, rules Ruleset) (string, error) {
+	var forward, nat []string
+	for _, f := range rules.Forward {
+		match, ok, err := iptablesMatch(family, f.InInterface, f.OutInterface, f.Source, f.Destination)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			continue
+		}
+		if f.Established {
+			match += " -m conntrack --ctstate ESTABLISHED,RELATED"
+		}
+		forward = append(forward, fmt.Sprintf("-A %s %s -j ACCEPT", forwardChain, match))
+	}
+	for _, m := range rules.Masquerade {
+		match, ok, err := iptablesMatch(family, "", m.OutInterface, m.Source, m.Destination)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			nat = append(nat, fmt.Sprintf("-A %s %s -j MASQUERADE", natChain, match))
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "*filter\n:%s - [0:0]\n:%s - [0:0]\n", forwardChain, outputChain)
+	for _, rule := range forward {
+		b.WriteString(rule + "\n")
+	}
+	if rules.KillSwitch != nil {
+		for _, rule := range killSwitchRules(family, rules.KillSwitch) {
+			b.WriteString(rule + "\n")
+		}
+	}
+	fmt.Fprintf(&b, "COMMIT\n*nat\n:%s - [0:0]\n", natChain)
+	for _, rule := range nat {
+		b.WriteString(rule + "\n")
+	}
+	b.WriteString("COMMIT\n")
+	return b.String(), nil
+}
+
+func (t *iptables) Remove() error {
+	var errs []string
+	for _, family := range []int{4, 6} {
+		binary := t.binary(family)
+		if binary == "" {
+			continue
+		}
+		for _, hook := range hooks {
+			jump := []string{"-t", hook.table, hook.chain, "-j", hook.target}
+			for run(binary, withCommand("-C", jump)...) == nil {
+				if err := run(binary, withCommand("-D", jump)...); err != nil {
+					errs = append(errs, err.Error())
+					break
+				}
+			}
+		}
+		script := fmt.Sprintf("*filter\n:%s - [0:0]\n:%s - [0:0]\n-X %s\n-X %s\nCOMMIT\n*nat\n:%s - [0:0]\n-X %s\nCOMMIT\n",
+			forwardChain, outputChain, forwardChain, outputChain, natChain, natChain)
+		if err := restore(binary, script); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (t *iptables) binary(family int) string {
+	if family == 6 {
+		return t.v6
+	}
+	return t.v4
+}
+
+// iptablesMatch returns the match options of a rule for family, and false if
+// the rule only applies to the other family.
+func iptablesMatch(family int, in, out string, src, dst netip.Prefix) (string, bool, error) {
+	f, err := ruleFamily(src, dst)
+	if err != nil {
+		return "", false, err
+	}
+	if f != 0 && f != family {
+		return "", false, nil
+	}
+
+	var parts []string
+	if in != "" {
+		parts = append(parts, "-i", in)
+	}
+	if out != "" {
+		parts = append(parts, "-o", out)
+	}
+	if src.IsValid() {
+		parts = append(parts, "-s", src.Masked().String())
+	}
+	if dst.IsValid() {
+		parts = append(parts, "-d", dst.Masked().String())
+	}
+	return strings.Join(parts, " "), true, nil
+}
+
+// killSwitchRules returns the rules of NGHOST-OUTPUT for family. Allowed
+// packets return to OUTPUT, so the rules of other software still apply.
+func killSwitchRules(family int, ks *KillSwitch) []string {
+	rules := []string{"-A " + outputChain + " -o lo -j RETURN"}
+	for _, name := range ks.Interfaces {
+		rules = append(rules, fmt.Sprintf("-A %s -o %s -j RETURN", outputChain, name))
+	}
+	rules = append(rules, fmt.Sprintf("-A %s -m mark --mark %#x -j RETURN", outputChain, Mark))
+	if family == 6 {
+		rules = append(rules, fmt.Sprintf("-A %s -p udp --sport %d --dport %d -j RETURN", outputChain, dhcp6ClientPort, dhcp6ServerPort))
+		for _, typ := range []string{"router-solicitation", "neighbour-solicitation", "neighbour-advertisement"} {
+			rules = append(rules, fmt.Sprintf("-A %s -p ipv6-icmp --icmpv6-type %s -j RETURN", outputChain, typ))
+		}
+		return append(rules, "-A "+outputChain+" -j REJECT --reject-with icmp6-adm-prohibited")
+	}
+	rules = append(rules, fmt.Sprintf("-A %s -p udp --sport %d --dport %d -j RETURN", outputChain, dhcpClientPort, dhcpServerPort))
+	return append(rules, "-A "+outputChain+" -j REJECT --reject-with icmp-admin-prohibited")
+}
+
+// hasPrefixes reports whether any rule only applies to family.
+func hasPrefixes(rules Ruleset, family int) bool {
+	for _, f := range rules.Forward {
+		if fam, _ := ruleFamily(f.Source, f.Destination); fam == family {
+			return true
+		}
+	}
+	for _, m := range rules.Masquerade {
+		if fam, _ := ruleFamily(m.Source, m.Destination); fam == family {
+			return true
+		}
+	}
+	return false
+}
+
+// withCommand inserts command before the chain name, after the table option.
+func withCommand(command string, rule []string) []string {
+	return append([]string{rule[0], rule[1], command}, rule[2:]...)
+}
+
+func run(binary string, args ...string) error {
+	if output, err := exec.Command(binary, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("%s %v: %w (%s)", binary, args, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func restore(binary, script string) error {
+	cmd := exec.Command(binary+"-restore", "--noflush")
+	cmd.Stdin = strings.NewReader(script)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s-restore: %w (%s)", binary, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
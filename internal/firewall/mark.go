@@ -0,0 +1,31 @@
+package firewall
+
+import (
+	"context"
+	"net"
+)
+
+// Mark is the firewall mark (SO_MARK) carried by the sockets of the NGhost
+// transports. The kill switch lets packets with it leave the host outside
+// the tunnel.
+const Mark = 0x6e67
+
+// Dialer returns a dialer whose connections carry Mark, as do the DNS
+// lookups for them.
+func Dialer() *net.Dialer {
+	return &net.Dialer{
+		Control: markSocket,
+		Resolver: &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				d := net.Dialer{Control: markSocket}
+				return d.DialContext(ctx, network, address)
+			},
+		},
+	}
+}
+
+// ListenConfig returns a listen config whose sockets carry Mark.
+func ListenConfig() *net.ListenConfig {
+	return &net.ListenConfig{Control: markSocket}
+}
@@ -0,0 +1,21 @@
+package firewall
+
+import (
+	"errors"
+	"syscall"
+)
+
+func markSocket(network, address string, c syscall.RawConn) error {
+	var err error
+	if cerr := c.Control(func(fd uintptr) {
+		err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_MARK, Mark)
+	}); cerr != nil {
+		return cerr
+	}
+	// Marking needs CAP_NET_ADMIN, as does installing the kill switch, so
+	// without it there are no rules the mark would have to pass
+	if errors.Is(err, syscall.EPERM) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package firewall
+
+import "syscall"
+
+func markSocket(network, address string, c syscall.RawConn) error {
+	return nil
+}
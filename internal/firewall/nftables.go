@@ -0,0 +1,176 @@
+package firewall
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// nftables keeps all rules in the inet table "nghost". Every change is one
+// nft transaction that deletes and recreates the table, so the rules are
+// never half applied and never duplicated.
+type nftables struct {
+	nft string
+}
+
+func (n *nftables) Name() string {
+	return "nftables"
+}
+
+func (n *nftables) Apply(rules Ruleset) error {
+	script, err := nftScript(rules)
+	if err != nil {
+		return err
+	}
+	if len(rules.Forward) > 0 {
+		// Checked on every change, the policies of other software may have
+		// changed since we started. Without JSON support in nft the check is
+		// skipped.
+		if drops, err := n.forwardDrops(); err == nil && len(drops) > 0 {
+			return fmt.Errorf("forwarded VPN traffic would be dropped by the policy of chain %s; "+
+				"allow forwarding for the VPN interface there", strings.Join(drops, ", "))
+		}
+	}
+	return n.run(script)
+}
+
+// forwardDrops returns the forward chains of other tables whose policy
+// drops packets, as "family table chain".
+func (n *nftables) forwardDrops() ([]string, error) {
+	output, err := exec.Command(n.nft, "-j", "list", "chains").Output()
+	if err != nil {
+		return nil, fmt.Errorf("nft: %w", err)
+	}
+	return parseForwardDrops(output)
+}
+
+func parseForwardDrops(data []byte) ([]string, error) {
+	var list struct {
+		Nftables []struct {
+			Chain *struct {
+				Family string `json:"family"`
+				Table  string `json:"table"`
+				Name   string `json:"name"`
+				Hook   string `json:"hook"`
+				Policy string `json:"policy"`
+			} `json:"chain"`
+		} `json:"nftables"`
+	}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("invalid nft output: %w", err)
+	}
+
+	var drops []string
+	for _, item := range list.Nftables {
+		c := item.Chain
+		if c == nil || c.Hook != "forward" || c.Policy != "drop" {
+			continue
+		}
+		if c.Family == "inet" && c.Table == Table {
+			continue
+		}
+		drops = append(drops, c.Family+" "+c.Table+" "+c.Name)
+	}
+	return drops, nil
+}
+
+// iptablesOnly reports whether all chains are in the filter tables that
+// iptables manages, where the iptables backend can accept packets.
+func iptablesOnly(chains []string) bool {
+	for _, chain := range chains {
+		if !strings.HasPrefix(chain, "ip filter ") && !strings.HasPrefix(chain, "ip6 filter ") {
+			return false
+		}
+	}
+	return true
+}
+
+// nftScript returns the nft transaction that replaces the table with rules.
+func nftScript(rules Ruleset) (string, error) {
+	var b strings.Builder
+	// Declaring the table first makes the delete succeed when it does not
+	// exist yet
+	fmt.Fprintf(&b, "table inet %s {}\ndelete table inet %s\n", Table, Table)
+	fmt.Fprintf(&b, "table inet %s {\n", Table)
+
+	b.WriteString("\tchain forward {\n\t\ttype filter hook forward priority filter; policy accept;\n")
+	for _, f := range rules.Forward {
+		match, err := nftMatch(f.InInterface, f.OutInterface, f.Source, f.Destination)
+		if err != nil {
+			return "", err
+		}
+		if f.Established {
+			match += " ct state established,related"
+		}
+		fmt.Fprintf(&b, "\t\t%s accept\n", match)
+	}
+	b.WriteString("\t}\n")
+
+	b.WriteString("\tchain postrouting {\n\t\ttype nat hook postrouting priority srcnat; policy accept;\n")
+	for _, m := range rules.Masquerade {
+		match, err := nftMatch("", m.OutInterface, m.Source, m.Destination)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "\t\t%s masquerade\n", match)
+	}
+	b.WriteString("\t}\n")
+
+	if ks := rules.KillSwitch; ks != nil {
+		b.WriteString("\tchain output {\n\t\ttype filter hook output priority filter; policy accept;\n")
+		b.WriteString("\t\toifname \"lo\" accept\n")
+		for _, name := range ks.Interfaces {
+			fmt.Fprintf(&b, "\t\toifname %s accept\n", strconv.Quote(name))
+		}
+		fmt.Fprintf(&b, "\t\tmeta mark %#x accept\n", Mark)
+		fmt.Fprintf(&b, "\t\tmeta nfproto ipv4 udp sport %d udp dport %d accept\n", dhcpClientPort, dhcpServerPort)
+		fmt.Fprintf(&b, "\t\tmeta nfproto ipv6 udp sport %d udp dport %d accept\n", dhcp6ClientPort, dhcp6ServerPort)
+		b.WriteString("\t\ticmpv6 type { nd-router-solicit, nd-neighbor-solicit, nd-neighbor-advert } accept\n")
+		b.WriteString("\t\treject with icmpx type admin-prohibited\n")
+		b.WriteString("\t}\n")
+	}
+	b.WriteString("}\n")
+	return b.String(), nil
+}
+
+func (n *nftables) Remove() error {
+	return n.run(fmt.Sprintf("table inet %s {}\ndelete table inet %s\n", Table, Table))
+}
+
+func (n *nftables) run(script string) error {
+	cmd := exec.Command(n.nft, "-f", "-")
+	cmd.Stdin = strings.NewReader(script)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("nft: %w (%s)", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func nftMatch(in, out string, src, dst netip.Prefix) (string, error) {
+	family, err := ruleFamily(src, dst)
+	if err != nil {
+		return "", err
+	}
+	proto := "ip"
+	if family == 6 {
+		proto = "ip6"
+	}
+
+	var parts []string
+	if in != "" {
+		parts = append(parts, "iifname "+strconv.Quote(in))
+	}
+	if out != "" {
+		parts = append(parts, "oifname "+strconv.Quote(out))
+	}
+	if src.IsValid() {
+		parts = append(parts, proto+" saddr "+src.Masked().String())
+	}
+	if dst.IsValid() {
+		parts = append(parts, proto+" daddr "+dst.Masked().String())
+	}
+	return strings.Join(parts, " "), nil
+}
@@ -0,0 +1,211 @@
+package identity
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nknorg/nkn-sdk-go"
+)
+
+// Identity is the persisted secret seed of a node's NKN account.
+type Identity struct {
+	Seed      string    `json:"seed"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Store keeps a node identity in a single file readable only by its owner.
+type Store struct {
+	path string
+}
+
+func NewStore(path string) *Store {
+	return &Store{path: path}
+}
+
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Load reads the stored identity. It returns an error satisfying
+// os.IsNotExist if no identity has been generated yet.
+func (s *Store) Load() (*Identity, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// LoadOrCreate returns the stored identity, generating and saving a new one
+// on first use.
+func (s *Store) LoadOrCreate() (*Identity, bool, error) {
+	id, err := s.Load()
+	if err == nil {
+		return id, false, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, false, err
+	}
+
+	id, err = Generate()
+	if err != nil {
+		return nil, false, err
+	}
+	if err := s.Save(id); err != nil {
+		return nil, false, err
+	}
+	return id, true, nil
+}
+
+func (s *Store) Save(id *Identity) error {
+	if _, err := id.Account(); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(id, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(s.path, data)
+}
+
+// writeFile atomically replaces path with data, readable only by its owner.
+func writeFile(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a crash never leaves a truncated
+	// key. CreateTemp picks a fresh name with mode 0600, so concurrent
+	// writers and files planted by others are never reused.
+	f, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// Replace stores id in place of the current identity. The previous identity
+// file is kept next to the store with a timestamp suffix so it can be
+// restored with import; its path is returned, or "" if there was none.
+func (s *Store) Replace(id *Identity) (string, error) {
+	var backup string
+	old, err := os.ReadFile(s.path)
+	switch {
+	case err == nil:
+		backup = s.backupPath()
+		if err := writeFile(backup, old); err != nil {
+			return "", fmt.Errorf("failed to back up identity: %w", err)
+		}
+	case !os.IsNotExist(err):
+		return "", err
+	}
+
+	if err := s.Save(id); err != nil {
+		return backup, err
+	}
+	return backup, nil
+}
+
+// backupPath returns an unused backup file name for the store.
+func (s *Store) backupPath() string {
+	base := fmt.Sprintf("%s.%s", s.path, time.Now().Format("20060102-150405"))
+	path := base + ".bak"
+	for i := 2; ; i++ {
+		if _, err := os.Lstat(path); os.IsNotExist(err) {
+			return path
+		}
+		path = fmt.Sprintf("%s-%d.bak", base, i)
+	}
+}
+
+// Rotate replaces the stored identity with a freshly generated one, keeping
+// a backup of the previous one like Replace.
+func (s *Store) Rotate() (*Identity, string, error) {
+	id, err := Generate()
+	if err != nil {
+		return nil, "", err
+	}
+	backup, err := s.Replace(id)
+	if err != nil {
+		return nil, "", err
+	}
+	return id, backup, nil
+}
+
+func Generate() (*Identity, error) {
+	account, err := nkn.NewAccount(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create NKN account: %w", err)
+	}
+	return &Identity{
+		Seed:      hex.EncodeToString(account.Seed()),
+		CreatedAt: time.Now().UTC(),
+	}, nil
+}
+
+// Parse accepts either an exported identity file or a bare hex seed.
+func Parse(data []byte) (*Identity, error) {
+	text := strings.TrimSpace(string(data))
+	if text == "" {
+		return nil, errors.New("empty identity")
+	}
+
+	var id Identity
+	if strings.HasPrefix(text, "{") {
+		if err := json.Unmarshal([]byte(text), &id); err != nil {
+			return nil, fmt.Errorf("invalid identity file: %w", err)
+		}
+	} else {
+		id.Seed = text
+	}
+
+	if _, err := id.Account(); err != nil {
+		return nil, err
+	}
+	return &id, nil
+}
+
+func (id *Identity) Account() (*nkn.Account, error) {
+	seed, err := hex.DecodeString(id.Seed)
+	if err != nil {
+		return nil, fmt.Errorf("invalid identity seed: %w", err)
+	}
+	if len(seed) != 32 {
+		return nil, fmt.Errorf("invalid identity seed length: %d", len(seed))
+	}
+	return nkn.NewAccount(seed)
+}
+
+// Address returns the NKN client address of the identity, which is the hex
+// encoded public key when no identifier is used.
+func (id *Identity) Address() (string, error) {
+	account, err := id.Account()
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(account.PubKey()), nil
+}
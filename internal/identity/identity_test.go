@@ -0,0 +1,78 @@
+package identity
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReplaceKeepsBackup(t *testing.T) {
+	dir := t.TempDir()
+	store := NewStore(filepath.Join(dir, "identity.json"))
+
+	if backup, err := store.Replace(mustGenerate(t)); err != nil || backup != "" {
+		t.Fatalf("first identity: backup %q, err %v", backup, err)
+	}
+	old, err := os.ReadFile(store.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Two replacements within a second must not share a backup
+	var backups []string
+	for i := 0; i < 2; i++ {
+		backup, err := store.Replace(mustGenerate(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		backups = append(backups, backup)
+	}
+	if backups[0] == backups[1] {
+		t.Fatalf("both backups written to %s", backups[0])
+	}
+	data, err := os.ReadFile(backups[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(old) {
+		t.Fatal("backup differs from the replaced identity")
+	}
+	if info, err := os.Stat(backups[0]); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("backup mode %v, err %v", info.Mode(), err)
+	}
+
+	// No temporary files are left behind
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("%d files in the store directory, want the identity and two backups", len(entries))
+	}
+}
+
+func TestReplaceBacksUpUnreadableIdentity(t *testing.T) {
+	store := NewStore(filepath.Join(t.TempDir(), "identity.json"))
+	if err := os.WriteFile(store.Path(), []byte("corrupt"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	backup, err := store.Replace(mustGenerate(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(backup); err != nil || string(data) != "corrupt" {
+		t.Fatalf("backup %q, err %v", data, err)
+	}
+	if _, err := store.Load(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mustGenerate(t *testing.T) *Identity {
+	t.Helper()
+	id, err := Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
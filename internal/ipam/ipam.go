@@ -0,0 +1,275 @@
+package ipam
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	ErrConflict   = errors.New("address already leased to another peer")
+	ErrOutOfRange = errors.New("address outside of the VPN network")
+	ErrExhausted  = errors.New("no free addresses left in the VPN network")
+)
+
+// DefaultLeaseTTL is how long a claimed address stays reserved for its owner
+// without being renewed by an announcement or lease request.
+const DefaultLeaseTTL = 10 * time.Minute
+
+type lease struct {
+	owner   string
+	ip      string
+	expires time.Time
+	static  bool
+}
+
+// Pool hands out host addresses of a CIDR to NKN addresses. Allocation is
+// deterministic: an owner always starts probing at the same offset derived
+// from a hash of its address, so nodes agree on addresses without
+// coordination in the common case and only collide on the rare hash clash.
+type Pool struct {
+	network *net.IPNet
+	base    *big.Int
+	size    *big.Int // number of usable host addresses
+	ttl     time.Duration
+	now     func() time.Time
+
+	mu      sync.Mutex
+	byIP    map[string]*lease
+	byOwner map[string]*lease
+	pinned  map[string]bool
+}
+
+// NewPool creates a pool over cidr. static maps NKN addresses to fixed IPs
+// that are never handed to anyone else.
+func NewPool(cidr string, static map[string]string) (*Pool, error) {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CIDR: %w", err)
+	}
+
+	ones, bits := network.Mask.Size()
+	hostBits := bits - ones
+	if hostBits < 2 {
+		return nil, fmt.Errorf("network %s is too small", cidr)
+	}
+
+	// Skip the network and broadcast addresses
+	size := new(big.Int).Lsh(big.NewInt(1), uint(hostBits))
+	size.Sub(size, big.NewInt(2))
+
+	p := &Pool{
+		network: network,
+		base:    new(big.Int).SetBytes(network.IP),
+		size:    size,
+		ttl:     DefaultLeaseTTL,
+		now:     time.Now,
+		byIP:    make(map[string]*lease),
+		byOwner: make(map[string]*lease),
+		pinned:  make(map[string]bool),
+	}
+
+	for owner, addr := range static {
+		ip := net.ParseIP(addr)
+		if ip == nil || !network.Contains(ip) {
+			return nil, fmt.Errorf("static address %s for %s: %w", addr, owner, ErrOutOfRange)
+		}
+		key := normalize(ip)
+		if existing, ok := p.byIP[key]; ok {
+			return nil, fmt.Errorf("static address %s assigned to both %s and %s", addr, existing.owner, owner)
+		}
+		l := &lease{owner: owner, ip: key, static: true}
+		p.byIP[key] = l
+		p.byOwner[owner] = l
+	}
+
+	return p, nil
+}
+
+func (p *Pool) Network() *net.IPNet {
+	return p.network
+}
+
+// Pin keeps the leases of owner from expiring. It is meant for the node's own
+// address, which no announcement renews; unlike a static address it can still
+// move after a conflict.
+func (p *Pool) Pin(owner string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pinned[owner] = true
+}
+
+// Allocate returns the address leased to owner, assigning one if needed.
+func (p *Pool) Allocate(owner string) (net.IP, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	if l := p.lookupOwner(owner, now); l != nil {
+		p.renew(l, now)
+		return net.ParseIP(l.ip), nil
+	}
+
+	start := p.offset(owner)
+	probe := new(big.Int)
+	one := big.NewInt(1)
+	// Linear probing over the whole range; capped so huge IPv6 ranges never
+	// spin forever when nearly full
+	limit := p.size
+	if limit.Cmp(big.NewInt(1<<16)) > 0 {
+		limit = big.NewInt(1 << 16)
+	}
+	for i := new(big.Int); i.Cmp(limit) < 0; i.Add(i, one) {
+		probe.Add(start, i)
+		probe.Mod(probe, p.size)
+		ip := p.hostIP(probe)
+		key := normalize(ip)
+		if l, taken := p.byIP[key]; taken && !p.expired(l, now) {
+			continue
+		}
+		p.assign(owner, key, now)
+		return ip, nil
+	}
+	return nil, ErrExhausted
+}
+
+// Claim records that owner uses ip, e.g. from a peer announcement or a lease
+// request. It fails with ErrConflict if another owner holds the address.
+func (p *Pool) Claim(owner string, ip net.IP) error {
+	if ip == nil || !p.network.Contains(ip) {
+		return ErrOutOfRange
+	}
+	if ip.Equal(p.network.IP) || ip.Equal(p.hostIP(p.size)) {
+		// Network and broadcast addresses
+		return ErrOutOfRange
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	key := normalize(ip)
+	if l, taken := p.byIP[key]; taken && l.owner != owner && !p.expired(l, now) {
+		return fmt.Errorf("%w: %s is leased to %s", ErrConflict, key, l.owner)
+	}
+	if l := p.byOwner[owner]; l != nil && l.static && l.ip != key {
+		return fmt.Errorf("%w: %s has static address %s", ErrConflict, owner, l.ip)
+	}
+
+	p.assign(owner, key, now)
+	return nil
+}
+
+// Release frees the address leased to owner unless it is static.
+func (p *Pool) Release(owner string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if l := p.byOwner[owner]; l != nil && !l.static {
+		delete(p.byOwner, owner)
+		if p.byIP[l.ip] == l {
+			delete(p.byIP, l.ip)
+		}
+	}
+}
+
+// Owner returns who holds ip, or "" if the address is free.
+func (p *Pool) Owner(ip net.IP) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if l, ok := p.byIP[normalize(ip)]; ok && !p.expired(l, p.now()) {
+		return l.owner
+	}
+	return ""
+}
+
+// Address returns the address currently leased to owner, if any.
+func (p *Pool) Address(owner string) net.IP {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if l := p.lookupOwner(owner, p.now()); l != nil {
+		return net.ParseIP(l.ip)
+	}
+	return nil
+}
+
+// Static returns the static address configured for owner, if any.
+func (p *Pool) Static(owner string) net.IP {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if l := p.byOwner[owner]; l != nil && l.static {
+		return net.ParseIP(l.ip)
+	}
+	return nil
+}
+
+func (p *Pool) lookupOwner(owner string, now time.Time) *lease {
+	l := p.byOwner[owner]
+	if l == nil || p.expired(l, now) {
+		return nil
+	}
+	return l
+}
+
+func (p *Pool) assign(owner, key string, now time.Time) {
+	if old := p.byOwner[owner]; old != nil && old.ip != key {
+		if old.static {
+			return
+		}
+		if p.byIP[old.ip] == old {
+			delete(p.byIP, old.ip)
+		}
+	}
+	if l := p.byIP[key]; l != nil && l.owner != owner {
+		delete(p.byOwner, l.owner)
+	}
+
+	l := &lease{owner: owner, ip: key}
+	p.renew(l, now)
+	p.byIP[key] = l
+	p.byOwner[owner] = l
+}
+
+func (p *Pool) renew(l *lease, now time.Time) {
+	if !l.static {
+		l.expires = now.Add(p.ttl)
+	}
+}
+
+func (p *Pool) expired(l *lease, now time.Time) bool {
+	return !l.static && !p.pinned[l.owner] && now.After(l.expires)
+}
+
+// offset hashes owner onto a host index in [0, size).
+func (p *Pool) offset(owner string) *big.Int {
+	sum := sha256.Sum256([]byte(owner))
+	h := new(big.Int).SetUint64(binary.BigEndian.Uint64(sum[:8]))
+	return h.Mod(h, p.size)
+}
+
+// hostIP returns the address of host index i, counting from the first
+// address after the network address.
+func (p *Pool) hostIP(i *big.Int) net.IP {
+	n := new(big.Int).Add(p.base, i)
+	n.Add(n, big.NewInt(1))
+
+	ip := make(net.IP, len(p.network.IP))
+	b := n.Bytes()
+	copy(ip[len(ip)-len(b):], b)
+	return ip
+}
+
+func normalize(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		return v4.String()
+	}
+	return ip.String()
+}
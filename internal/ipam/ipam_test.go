@@ -0,0 +1,205 @@
+package ipam
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestAllocateIsStable(t *testing.T) {
+	p, err := NewPool("10.100.0.0/16", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := p.Allocate("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := p.Allocate("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !first.Equal(again) {
+		t.Fatalf("second allocation %s differs from %s", again, first)
+	}
+	if !p.Network().Contains(first) {
+		t.Fatalf("%s outside of %s", first, p.Network())
+	}
+
+	// Another pool over the same network derives the same address
+	other, _ := NewPool("10.100.0.0/16", nil)
+	if ip, _ := other.Allocate("alice"); !ip.Equal(first) {
+		t.Fatalf("other pool allocated %s, want %s", ip, first)
+	}
+}
+
+func TestAllocateSkipsNetworkAndBroadcast(t *testing.T) {
+	p, err := NewPool("10.0.0.0/30", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for _, owner := range []string{"a", "b"} {
+		ip, err := p.Allocate(owner)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := ip.String(); s != "10.0.0.1" && s != "10.0.0.2" {
+			t.Fatalf("%s got %s", owner, s)
+		}
+		seen[ip.String()] = true
+	}
+	if len(seen) != 2 {
+		t.Fatalf("owners share an address: %v", seen)
+	}
+	if _, err := p.Allocate("c"); !errors.Is(err, ErrExhausted) {
+		t.Fatalf("got %v, want ErrExhausted", err)
+	}
+}
+
+func TestNewPoolRejectsTinyNetworks(t *testing.T) {
+	for _, cidr := range []string{"10.0.0.1/32", "10.0.0.0/31", "fd00::/128", "bogus"} {
+		if _, err := NewPool(cidr, nil); err == nil {
+			t.Errorf("NewPool(%q) succeeded", cidr)
+		}
+	}
+}
+
+func TestClaim(t *testing.T) {
+	p, _ := NewPool("10.100.0.0/24", nil)
+	ip := net.ParseIP("10.100.0.20")
+	if err := p.Claim("alice", ip); err != nil {
+		t.Fatal(err)
+	}
+	if owner := p.Owner(ip); owner != "alice" {
+		t.Fatalf("owner %q, want alice", owner)
+	}
+	if err := p.Claim("bob", ip); !errors.Is(err, ErrConflict) {
+		t.Fatalf("got %v, want ErrConflict", err)
+	}
+
+	// Moving to another address frees the old one
+	moved := net.ParseIP("10.100.0.21")
+	if err := p.Claim("alice", moved); err != nil {
+		t.Fatal(err)
+	}
+	if owner := p.Owner(ip); owner != "" {
+		t.Fatalf("old address still owned by %q", owner)
+	}
+	if got := p.Address("alice"); !got.Equal(moved) {
+		t.Fatalf("address %s, want %s", got, moved)
+	}
+
+	for _, addr := range []string{"10.100.0.0", "10.100.0.255", "10.101.0.1"} {
+		if err := p.Claim("carol", net.ParseIP(addr)); !errors.Is(err, ErrOutOfRange) {
+			t.Errorf("claim %s: got %v, want ErrOutOfRange", addr, err)
+		}
+	}
+}
+
+func TestStaticAddresses(t *testing.T) {
+	p, err := NewPool("10.100.0.0/24", map[string]string{"alice": "10.100.0.5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	static := net.ParseIP("10.100.0.5")
+	if ip, _ := p.Allocate("alice"); !ip.Equal(static) {
+		t.Fatalf("allocated %s, want static %s", ip, static)
+	}
+	if err := p.Claim("bob", static); !errors.Is(err, ErrConflict) {
+		t.Fatalf("got %v, want ErrConflict", err)
+	}
+	if err := p.Claim("alice", net.ParseIP("10.100.0.6")); !errors.Is(err, ErrConflict) {
+		t.Fatalf("moving a static owner: got %v, want ErrConflict", err)
+	}
+	p.Release("alice")
+	if got := p.Static("alice"); !got.Equal(static) {
+		t.Fatalf("static address %s after release", got)
+	}
+
+	if _, err := NewPool("10.100.0.0/24", map[string]string{"alice": "10.200.0.5"}); !errors.Is(err, ErrOutOfRange) {
+		t.Fatalf("got %v, want ErrOutOfRange", err)
+	}
+	if _, err := NewPool("10.100.0.0/24", map[string]string{"a": "10.100.0.5", "b": "10.100.0.5"}); err == nil {
+		t.Fatal("duplicate static address accepted")
+	}
+}
+
+func TestExpiredLeasesAreReused(t *testing.T) {
+	p, _ := NewPool("10.100.0.0/24", nil)
+	p.ttl = time.Millisecond
+	ip := net.ParseIP("10.100.0.20")
+	if err := p.Claim("alice", ip); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if owner := p.Owner(ip); owner != "" {
+		t.Fatalf("expired lease still owned by %q", owner)
+	}
+	if err := p.Claim("bob", ip); err != nil {
+		t.Fatalf("claim of expired lease: %v", err)
+	}
+	if got := p.Address("alice"); got != nil {
+		t.Fatalf("alice still has %s", got)
+	}
+}
+
+func TestRelease(t *testing.T) {
+	p, _ := NewPool("10.100.0.0/24", nil)
+	ip, _ := p.Allocate("alice")
+	p.Release("alice")
+	if owner := p.Owner(ip); owner != "" {
+		t.Fatalf("released address owned by %q", owner)
+	}
+	if err := p.Claim("bob", ip); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIPv6Pool(t *testing.T) {
+	p, err := NewPool("fd6e:6768:6f73::/64", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip, err := p.Allocate("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip.To4() != nil || !p.Network().Contains(ip) {
+		t.Fatalf("allocated %s", ip)
+	}
+}
+
+func TestPinnedLeasesDoNotExpire(t *testing.T) {
+	p, _ := NewPool("10.100.0.0/24", nil)
+	now := time.Now()
+	p.now = func() time.Time { return now }
+
+	p.Pin("self")
+	self, err := p.Allocate("self")
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer := net.ParseIP("10.100.0.20")
+	if err := p.Claim("peer", peer); err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(2 * DefaultLeaseTTL)
+	if err := p.Claim("other", self); !errors.Is(err, ErrConflict) {
+		t.Fatalf("claim of our address after the TTL: got %v, want ErrConflict", err)
+	}
+	if got := p.Address("self"); !got.Equal(self) {
+		t.Fatalf("own address %s, want %s", got, self)
+	}
+	if err := p.Claim("other", peer); err != nil {
+		t.Fatalf("expired peer lease not reused: %v", err)
+	}
+
+	// A pinned owner can still move, e.g. after losing a conflict
+	p.Release("self")
+	if err := p.Claim("other", self); err != nil {
+		t.Fatalf("released address not reusable: %v", err)
+	}
+}
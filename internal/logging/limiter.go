@@ -0,0 +1,59 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Limiter lets through at most one message per key and interval. It guards
+// errors on the packet path, which would otherwise be logged for every
+// packet while a peer or the TUN device misbehaves.
+type Limiter struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	keys map[string]*limitState
+}
+
+type limitState struct {
+	last       time.Time
+	suppressed int
+}
+
+func NewLimiter(interval time.Duration) *Limiter {
+	return &Limiter{interval: interval, keys: make(map[string]*limitState)}
+}
+
+// Log logs msg unless a message with the same key passed less than interval
+// ago. The next message that passes reports how many were suppressed. Keys
+// should come from a small set, e.g. the message itself.
+func (l *Limiter) Log(logger *slog.Logger, level slog.Level, key, msg string, args ...any) {
+	ctx := context.Background()
+	if !logger.Enabled(ctx, level) {
+		return
+	}
+
+	now := time.Now()
+	l.mu.Lock()
+	state, ok := l.keys[key]
+	if !ok {
+		state = &limitState{}
+		l.keys[key] = state
+	}
+	if ok && now.Sub(state.last) < l.interval {
+		state.suppressed++
+		l.mu.Unlock()
+		return
+	}
+	suppressed := state.suppressed
+	state.last = now
+	state.suppressed = 0
+	l.mu.Unlock()
+
+	if suppressed > 0 {
+		args = append(args, "suppressed", suppressed)
+	}
+	logger.Log(ctx, level, msg, args...)
+}
@@ -0,0 +1,135 @@
+// Package logging provides the leveled, structured loggers of the NGhost
+// subsystems. Loggers can be created before Configure runs; they pick up the
+// configured format and levels when they write.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"nghost/internal/config"
+)
+
+// Subsystem names accepted in config.LogConfig.Subsystems.
+const (
+	Main    = "main"
+	NKN     = "nkn"
+	VPN     = "vpn"
+	TUN     = "tun"
+	Control = "control"
+	Metrics = "metrics"
+)
+
+type settings struct {
+	handler slog.Handler
+	level   slog.Level
+	levels  map[string]slog.Level
+}
+
+func (s *settings) levelFor(subsystem string) slog.Level {
+	if level, ok := s.levels[subsystem]; ok {
+		return level
+	}
+	return s.level
+}
+
+var current atomic.Pointer[settings]
+
+func init() {
+	current.Store(&settings{
+		handler: slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}),
+		level:   slog.LevelInfo,
+	})
+}
+
+// Configure sets the output format and levels of all loggers. Logs are
+// written to w, or stderr if w is nil.
+func Configure(cfg config.LogConfig, w io.Writer) error {
+	if w == nil {
+		w = os.Stderr
+	}
+
+	level, err := ParseLevel(cfg.Level)
+	if err != nil {
+		return err
+	}
+	levels := make(map[string]slog.Level, len(cfg.Subsystems))
+	for subsystem, name := range cfg.Subsystems {
+		if levels[subsystem], err = ParseLevel(name); err != nil {
+			return fmt.Errorf("subsystem %s: %w", subsystem, err)
+		}
+	}
+
+	// Filtering happens per subsystem, so the handler itself accepts all
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+	var handler slog.Handler
+	switch cfg.Format {
+	case "", "text":
+		handler = slog.NewTextHandler(w, opts)
+	case "json":
+		handler = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("unknown log format %q", cfg.Format)
+	}
+
+	current.Store(&settings{handler: handler, level: level, levels: levels})
+	slog.SetDefault(Logger(Main))
+	return nil
+}
+
+// ParseLevel parses debug, info, warn or error. Empty means info.
+func ParseLevel(name string) (slog.Level, error) {
+	if name == "" {
+		return slog.LevelInfo, nil
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.ToUpper(name))); err != nil {
+		return 0, fmt.Errorf("unknown log level %q", name)
+	}
+	return level, nil
+}
+
+// Logger returns the logger of subsystem. Its records carry a subsystem
+// attribute and are filtered by the subsystem's level.
+func Logger(subsystem string) *slog.Logger {
+	return slog.New(&handler{subsystem: subsystem})
+}
+
+// handler forwards records to the configured handler. Attributes and groups
+// added with With are replayed on it, so reconfiguring takes effect for
+// loggers that already exist.
+type handler struct {
+	subsystem string
+	ops       []func(slog.Handler) slog.Handler
+}
+
+func (h *handler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= current.Load().levelFor(h.subsystem)
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	next := current.Load().handler.WithAttrs([]slog.Attr{slog.String("subsystem", h.subsystem)})
+	for _, op := range h.ops {
+		next = op(next)
+	}
+	return next.Handle(ctx, r)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(next slog.Handler) slog.Handler { return next.WithAttrs(attrs) })
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	return h.with(func(next slog.Handler) slog.Handler { return next.WithGroup(name) })
+}
+
+func (h *handler) with(op func(slog.Handler) slog.Handler) *handler {
+	ops := make([]func(slog.Handler) slog.Handler, len(h.ops), len(h.ops)+1)
+	copy(ops, h.ops)
+	return &handler{subsystem: h.subsystem, ops: append(ops, op)}
+}
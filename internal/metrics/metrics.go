@@ -0,0 +1,372 @@
+// Package metrics implements the counters, gauges and histograms NGhost
+// exposes in the Prometheus text format. Metrics are declared as package
+// variables next to the code that updates them and register themselves with
+// the default registry.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// RTTBuckets are histogram buckets in seconds for round trip times over NKN,
+// which range from milliseconds to seconds.
+var RTTBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// SizeBuckets are histogram buckets in bytes for IP packet sizes.
+var SizeBuckets = []float64{64, 128, 256, 512, 1024, 1280, 1500, 9000, 65535}
+
+// metric is a named family of samples.
+type metric interface {
+	name() string
+	write(w io.Writer)
+}
+
+// Registry holds the metrics exposed by one endpoint.
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]metric
+}
+
+// Default is the registry the New* functions register with.
+var Default = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]metric)}
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.metrics[m.name()]; exists {
+		panic("metrics: duplicate metric " + m.name())
+	}
+	r.metrics[m.name()] = m
+}
+
+// Write writes all metrics in the Prometheus text format, sorted by name.
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	metrics := make([]metric, 0, len(r.metrics))
+	for _, m := range r.metrics {
+		metrics = append(metrics, m)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].name() < metrics[j].name() })
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+type desc struct {
+	fqName string
+	help   string
+	typ    string
+	labels []string
+}
+
+func (d *desc) name() string {
+	return d.fqName
+}
+
+func (d *desc) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.fqName, helpEscaper.Replace(d.help), d.fqName, d.typ)
+}
+
+// The text format escapes backslashes and newlines in help texts, and
+// additionally double quotes in label values.
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+// Counter is a monotonically increasing count.
+type Counter struct {
+	desc
+	value atomic.Uint64
+}
+
+func NewCounter(name, help string) *Counter {
+	c := &Counter{desc: desc{fqName: name, help: help, typ: "counter"}}
+	Default.register(c)
+	return c
+}
+
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+func (c *Counter) Add(n uint64) {
+	c.value.Add(n)
+}
+
+func (c *Counter) Value() uint64 {
+	return c.value.Load()
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.header(w)
+	fmt.Fprintf(w, "%s %d\n", c.fqName, c.value.Load())
+}
+
+// Gauge is a value that can go up and down.
+type Gauge struct {
+	desc
+	bits atomic.Uint64
+	fn   func() float64
+}
+
+func NewGauge(name, help string) *Gauge {
+	g := &Gauge{desc: desc{fqName: name, help: help, typ: "gauge"}}
+	Default.register(g)
+	return g
+}
+
+// NewGaugeFunc returns a gauge whose value is computed by fn when scraped.
+func NewGaugeFunc(name, help string, fn func() float64) *Gauge {
+	g := &Gauge{desc: desc{fqName: name, help: help, typ: "gauge"}, fn: fn}
+	Default.register(g)
+	return g
+}
+
+func (g *Gauge) Set(v float64) {
+	g.bits.Store(math.Float64bits(v))
+}
+
+func (g *Gauge) Value() float64 {
+	if g.fn != nil {
+		return g.fn()
+	}
+	return math.Float64frombits(g.bits.Load())
+}
+
+func (g *Gauge) write(w io.Writer) {
+	g.header(w)
+	fmt.Fprintf(w, "%s %s\n", g.fqName, formatFloat(g.Value()))
+}
+
+// Histogram counts observations in cumulative buckets.
+type Histogram struct {
+	desc
+	buckets []float64
+
+	mu     sync.Mutex
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func NewHistogram(name, help string, buckets []float64) *Histogram {
+	h := newHistogram(desc{fqName: name, help: help, typ: "histogram"}, buckets)
+	Default.register(h)
+	return h
+}
+
+func newHistogram(d desc, buckets []float64) *Histogram {
+	return &Histogram{desc: d, buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, upper := range h.buckets {
+		if v <= upper {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.header(w)
+	h.writeSamples(w, "")
+}
+
+func (h *Histogram) writeSamples(w io.Writer, labels string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+	for i, upper := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{%s%sle=\"%s\"} %d\n", h.fqName, labels, sep, formatFloat(upper), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{%s%sle=\"+Inf\"} %d\n", h.fqName, labels, sep, h.count)
+	fmt.Fprintf(w, "%s_sum%s %s\n", h.fqName, braces(labels), formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count%s %d\n", h.fqName, braces(labels), h.count)
+}
+
+// vec holds one child metric per combination of label values. Looking up a
+// child formats its labels, so callers on hot paths keep the children they
+// use instead of calling With for every update.
+type vec[T any] struct {
+	desc
+	newChild func() T
+
+	mu       sync.RWMutex
+	children map[string]T
+}
+
+func (v *vec[T]) with(values []string) T {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.fqName, len(v.labels), len(values)))
+	}
+	key := v.labelString(values)
+
+	v.mu.RLock()
+	child, ok := v.children[key]
+	v.mu.RUnlock()
+	if ok {
+		return child
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if child, ok := v.children[key]; ok {
+		return child
+	}
+	child = v.newChild()
+	v.children[key] = child
+	return child
+}
+
+// Delete removes the series with the given label values, e.g. when the peer
+// it describes is removed.
+func (v *vec[T]) Delete(values ...string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	delete(v.children, v.labelString(values))
+}
+
+func (v *vec[T]) labelString(values []string) string {
+	pairs := make([]string, len(values))
+	for i, value := range values {
+		pairs[i] = v.labels[i] + `="` + labelEscaper.Replace(value) + `"`
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (v *vec[T]) sorted() ([]string, map[string]T) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	keys := make([]string, 0, len(v.children))
+	children := make(map[string]T, len(v.children))
+	for key, child := range v.children {
+		keys = append(keys, key)
+		children[key] = child
+	}
+	sort.Strings(keys)
+	return keys, children
+}
+
+// CounterVec is a counter partitioned by labels.
+type CounterVec struct {
+	vec[*Counter]
+}
+
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	v := &CounterVec{vec[*Counter]{
+		desc:     desc{fqName: name, help: help, typ: "counter", labels: labels},
+		newChild: func() *Counter { return &Counter{} },
+		children: make(map[string]*Counter),
+	}}
+	Default.register(v)
+	return v
+}
+
+// With returns the counter for the label values, creating it if needed.
+func (v *CounterVec) With(values ...string) *Counter {
+	return v.with(values)
+}
+
+func (v *CounterVec) write(w io.Writer) {
+	v.header(w)
+	keys, children := v.sorted()
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s{%s} %d\n", v.fqName, key, children[key].value.Load())
+	}
+}
+
+// GaugeVec is a gauge partitioned by labels.
+type GaugeVec struct {
+	vec[*Gauge]
+}
+
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	v := &GaugeVec{vec[*Gauge]{
+		desc:     desc{fqName: name, help: help, typ: "gauge", labels: labels},
+		newChild: func() *Gauge { return &Gauge{} },
+		children: make(map[string]*Gauge),
+	}}
+	Default.register(v)
+	return v
+}
+
+// With returns the gauge for the label values, creating it if needed.
+func (v *GaugeVec) With(values ...string) *Gauge {
+	return v.with(values)
+}
+
+func (v *GaugeVec) write(w io.Writer) {
+	v.header(w)
+	keys, children := v.sorted()
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s{%s} %s\n", v.fqName, key, formatFloat(children[key].Value()))
+	}
+}
+
+// HistogramVec is a histogram partitioned by labels.
+type HistogramVec struct {
+	vec[*Histogram]
+}
+
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	d := desc{fqName: name, help: help, typ: "histogram", labels: labels}
+	v := &HistogramVec{vec[*Histogram]{
+		desc:     d,
+		newChild: func() *Histogram { return newHistogram(d, buckets) },
+		children: make(map[string]*Histogram),
+	}}
+	Default.register(v)
+	return v
+}
+
+// With returns the histogram for the label values, creating it if needed.
+func (v *HistogramVec) With(values ...string) *Histogram {
+	return v.with(values)
+}
+
+func (v *HistogramVec) write(w io.Writer) {
+	v.header(w)
+	keys, children := v.sorted()
+	for _, key := range keys {
+		children[key].writeSamples(w, key)
+	}
+}
+
+func braces(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
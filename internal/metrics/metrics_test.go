@@ -0,0 +1,102 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+func write(m metric) string {
+	var buf bytes.Buffer
+	m.write(&buf)
+	return buf.String()
+}
+
+func TestCounterExposition(t *testing.T) {
+	c := &Counter{desc: desc{fqName: "test_total", help: "Line one\nC:\\path", typ: "counter"}}
+	c.Add(3)
+
+	want := "# HELP test_total Line one\\nC:\\\\path\n" +
+		"# TYPE test_total counter\n" +
+		"test_total 3\n"
+	if got := write(c); got != want {
+		t.Fatalf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestGaugeExposition(t *testing.T) {
+	g := &Gauge{desc: desc{fqName: "test_gauge", help: "A gauge.", typ: "gauge"}}
+	g.Set(0.25)
+
+	want := "# HELP test_gauge A gauge.\n" +
+		"# TYPE test_gauge gauge\n" +
+		"test_gauge 0.25\n"
+	if got := write(g); got != want {
+		t.Fatalf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestCounterVecEscapesLabels(t *testing.T) {
+	v := &CounterVec{vec[*Counter]{
+		desc:     desc{fqName: "test_total", help: "By peer.", typ: "counter", labels: []string{"peer", "direction"}},
+		newChild: func() *Counter { return &Counter{} },
+		children: make(map[string]*Counter),
+	}}
+	// Prometheus escapes only backslash, double quote and newline; Go
+	// quoting would turn the tab and the non-ASCII rune into escapes too
+	v.With("a\"b\\c\nd\te", "tx").Inc()
+	v.With("ü", "rx").Add(2)
+
+	want := "# HELP test_total By peer.\n" +
+		"# TYPE test_total counter\n" +
+		"test_total{peer=\"a\\\"b\\\\c\\nd\te\",direction=\"tx\"} 1\n" +
+		"test_total{peer=\"ü\",direction=\"rx\"} 2\n"
+	if got := write(v); got != want {
+		t.Fatalf("got\n%s\nwant\n%s", got, want)
+	}
+
+	v.Delete("ü", "rx")
+	want = "# HELP test_total By peer.\n" +
+		"# TYPE test_total counter\n" +
+		"test_total{peer=\"a\\\"b\\\\c\\nd\te\",direction=\"tx\"} 1\n"
+	if got := write(v); got != want {
+		t.Fatalf("after delete got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestHistogramVecExposition(t *testing.T) {
+	d := desc{fqName: "test_seconds", help: "Latency.", typ: "histogram", labels: []string{"peer"}}
+	v := &HistogramVec{vec[*Histogram]{
+		desc:     d,
+		newChild: func() *Histogram { return newHistogram(d, []float64{0.1, 1}) },
+		children: make(map[string]*Histogram),
+	}}
+	h := v.With("a")
+	h.Observe(0.05)
+	h.Observe(0.5)
+	h.Observe(2)
+
+	want := "# HELP test_seconds Latency.\n" +
+		"# TYPE test_seconds histogram\n" +
+		"test_seconds_bucket{peer=\"a\",le=\"0.1\"} 1\n" +
+		"test_seconds_bucket{peer=\"a\",le=\"1\"} 2\n" +
+		"test_seconds_bucket{peer=\"a\",le=\"+Inf\"} 3\n" +
+		"test_seconds_sum{peer=\"a\"} 2.55\n" +
+		"test_seconds_count{peer=\"a\"} 3\n"
+	if got := write(v); got != want {
+		t.Fatalf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestRegistrySortsByName(t *testing.T) {
+	r := NewRegistry()
+	r.register(&Counter{desc: desc{fqName: "b_total", help: "B.", typ: "counter"}})
+	r.register(&Counter{desc: desc{fqName: "a_total", help: "A.", typ: "counter"}})
+
+	var buf bytes.Buffer
+	r.Write(&buf)
+	want := "# HELP a_total A.\n# TYPE a_total counter\na_total 0\n" +
+		"# HELP b_total B.\n# TYPE b_total counter\nb_total 0\n"
+	if buf.String() != want {
+		t.Fatalf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+}
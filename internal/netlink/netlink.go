@@ -0,0 +1,64 @@
+// Package netlink configures interfaces, addresses and routes through
+// rtnetlink on Linux, so the daemon neither shells out to `ip` nor parses its
+// output. On other platforms every operation fails with ErrUnsupported.
+package netlink
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"syscall"
+)
+
+var (
+	ErrExists      = errors.New("already exists")
+	ErrNotFound    = errors.New("not found")
+	ErrUnsupported = errors.New("netlink is only available on Linux")
+)
+
+// Error is a failed netlink operation. It matches ErrExists and ErrNotFound
+// with errors.Is depending on the errno the kernel returned.
+type Error struct {
+	Op    string
+	Errno syscall.Errno
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("netlink %s: %v", e.Op, e.Errno)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Errno
+}
+
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrExists:
+		return e.Errno == syscall.EEXIST
+	case ErrNotFound:
+		return e.Errno == syscall.ESRCH || e.Errno == syscall.ENOENT ||
+			e.Errno == syscall.ENODEV || e.Errno == syscall.EADDRNOTAVAIL
+	}
+	return false
+}
+
+// Route is a route in the main routing table. Dst is the destination prefix
+// (0.0.0.0/0 or ::/0 for a default route), Gateway is unset for routes
+// directly on Link, and Metric 0 leaves the kernel default.
+type Route struct {
+	Dst     netip.Prefix
+	Gateway netip.Addr
+	Link    string
+	Metric  int
+}
+
+func (r Route) String() string {
+	s := r.Dst.String()
+	if r.Gateway.IsValid() {
+		s += " via " + r.Gateway.String()
+	}
+	if r.Link != "" {
+		s += " dev " + r.Link
+	}
+	return s
+}
@@ -0,0 +1,350 @@
+package netlink
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+var seq atomic.Uint32
+
+// AddAddress assigns prefix to the interface. The prefix length decides the
+// on-link route the kernel creates for it. Fails with ErrExists if the
+// address is already assigned.
+func AddAddress(ifname string, prefix netip.Prefix) error {
+	return address(syscall.RTM_NEWADDR, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL, ifname, prefix)
+}
+
+// DeleteAddress removes prefix from the interface.
+func DeleteAddress(ifname string, prefix netip.Prefix) error {
+	return address(syscall.RTM_DELADDR, 0, ifname, prefix)
+}
+
+func address(op uint16, flags uint16, ifname string, prefix netip.Prefix) error {
+	index, err := linkIndex(ifname)
+	if err != nil {
+		return err
+	}
+
+	msg := make([]byte, syscall.SizeofIfAddrmsg)
+	msg[0] = family(prefix.Addr())
+	msg[1] = uint8(prefix.Bits())
+	binary.NativeEndian.PutUint32(msg[4:], uint32(index))
+
+	addr := prefix.Addr().AsSlice()
+	msg = appendAttr(msg, syscall.IFA_LOCAL, addr)
+	msg = appendAttr(msg, syscall.IFA_ADDRESS, addr)
+
+	return request("address "+prefix.String()+" dev "+ifname, op, flags, msg)
+}
+
+// SetLinkUp brings the interface up.
+func SetLinkUp(ifname string) error {
+	index, err := linkIndex(ifname)
+	if err != nil {
+		return err
+	}
+	msg := linkMessage(index)
+	binary.NativeEndian.PutUint32(msg[8:], syscall.IFF_UP)
+	binary.NativeEndian.PutUint32(msg[12:], syscall.IFF_UP)
+	return request("link up "+ifname, syscall.RTM_NEWLINK, 0, msg)
+}
+
+// SetMTU sets the MTU of the interface.
+func SetMTU(ifname string, mtu int) error {
+	index, err := linkIndex(ifname)
+	if err != nil {
+		return err
+	}
+	value := make([]byte, 4)
+	binary.NativeEndian.PutUint32(value, uint32(mtu))
+	msg := appendAttr(linkMessage(index), syscall.IFLA_MTU, value)
+	return request(fmt.Sprintf("mtu %d dev %s", mtu, ifname), syscall.RTM_NEWLINK, 0, msg)
+}
+
+func linkMessage(index int) []byte {
+	msg := make([]byte, syscall.SizeofIfInfomsg)
+	msg[0] = syscall.AF_UNSPEC
+	binary.NativeEndian.PutUint32(msg[4:], uint32(index))
+	return msg
+}
+
+// AddRoute adds r to the main table. Fails with ErrExists if a route to the
+// same destination and metric is already present.
+func AddRoute(r Route) error {
+	return route(syscall.RTM_NEWROUTE, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL, r)
+}
+
+// ReplaceRoute adds r to the main table, replacing any route to the same
+// destination and metric.
+func ReplaceRoute(r Route) error {
+	return route(syscall.RTM_NEWROUTE, syscall.NLM_F_CREATE|syscall.NLM_F_REPLACE, r)
+}
+
+// DeleteRoute removes r from the main table.
+func DeleteRoute(r Route) error {
+	return route(syscall.RTM_DELROUTE, 0, r)
+}
+
+func route(op uint16, flags uint16, r Route) error {
+	msg, err := routeMessage(op, r)
+	if err != nil {
+		return err
+	}
+	return request("route "+r.String(), op, flags, msg)
+}
+
+// routeMessage returns the rtmsg and attributes of a route request.
+func routeMessage(op uint16, r Route) ([]byte, error) {
+	if !r.Dst.IsValid() {
+		return nil, fmt.Errorf("route %s: invalid destination", r)
+	}
+
+	msg := make([]byte, syscall.SizeofRtMsg)
+	msg[0] = family(r.Dst.Addr())
+	msg[1] = uint8(r.Dst.Bits())
+	msg[4] = syscall.RT_TABLE_MAIN
+	if op == syscall.RTM_NEWROUTE {
+		msg[5] = syscall.RTPROT_BOOT
+		msg[6] = syscall.RT_SCOPE_UNIVERSE
+		if !r.Gateway.IsValid() {
+			msg[6] = syscall.RT_SCOPE_LINK
+		}
+		msg[7] = syscall.RTN_UNICAST
+	} else {
+		msg[6] = syscall.RT_SCOPE_NOWHERE
+	}
+
+	if r.Dst.Bits() > 0 {
+		msg = appendAttr(msg, syscall.RTA_DST, r.Dst.Masked().Addr().AsSlice())
+	}
+	if r.Gateway.IsValid() {
+		msg = appendAttr(msg, syscall.RTA_GATEWAY, r.Gateway.AsSlice())
+	}
+	if r.Link != "" {
+		index, err := linkIndex(r.Link)
+		if err != nil {
+			return nil, err
+		}
+		value := make([]byte, 4)
+		binary.NativeEndian.PutUint32(value, uint32(index))
+		msg = appendAttr(msg, syscall.RTA_OIF, value)
+	}
+	if r.Metric > 0 {
+		value := make([]byte, 4)
+		binary.NativeEndian.PutUint32(value, uint32(r.Metric))
+		msg = appendAttr(msg, syscall.RTA_PRIORITY, value)
+	}
+	return msg, nil
+}
+
+// DefaultGateway returns the default route of the main table with the lowest
+// metric for the address family of addr (IPv4 if addr is unset).
+func DefaultGateway(addr netip.Addr) (Route, error) {
+	fam := syscall.AF_INET
+	if addr.Is6() && !addr.Is4In6() {
+		fam = syscall.AF_INET6
+	}
+
+	data, err := syscall.NetlinkRIB(syscall.RTM_GETROUTE, fam)
+	if err != nil {
+		return Route{}, fmt.Errorf("netlink dump routes: %w", err)
+	}
+	msgs, err := syscall.ParseNetlinkMessage(data)
+	if err != nil {
+		return Route{}, fmt.Errorf("netlink dump routes: %w", err)
+	}
+
+	var best Route
+	found := false
+	for i := range msgs {
+		m := &msgs[i]
+		if m.Header.Type != syscall.RTM_NEWROUTE || len(m.Data) < syscall.SizeofRtMsg {
+			continue
+		}
+		rt := (*syscall.RtMsg)(unsafe.Pointer(&m.Data[0]))
+		if rt.Dst_len != 0 || rt.Type != syscall.RTN_UNICAST {
+			continue
+		}
+		r, table, err := parseRoute(m)
+		if err != nil {
+			continue
+		}
+		if table != syscall.RT_TABLE_MAIN || !r.Gateway.IsValid() {
+			continue
+		}
+		if !found || r.Metric < best.Metric {
+			r.Dst = netip.PrefixFrom(netip.IPv4Unspecified(), 0)
+			if fam == syscall.AF_INET6 {
+				r.Dst = netip.PrefixFrom(netip.IPv6Unspecified(), 0)
+			}
+			best, found = r, true
+		}
+	}
+	if !found {
+		return Route{}, &Error{Op: "default gateway", Errno: syscall.ENOENT}
+	}
+	return best, nil
+}
+
+// LookupRoute returns the route the kernel picks for packets to dst, with
+// Dst set to the host prefix of dst. Local and loopback addresses are on the
+// loopback interface.
+func LookupRoute(dst netip.Addr) (Route, error) {
+	dst = dst.Unmap()
+	msg := make([]byte, syscall.SizeofRtMsg)
+	msg[0] = family(dst)
+	msg[1] = uint8(dst.BitLen())
+	msg = appendAttr(msg, syscall.RTA_DST, dst.AsSlice())
+
+	op := "get route " + dst.String()
+	reply, err := exchange(op, syscall.RTM_GETROUTE, 0, msg)
+	if err != nil {
+		return Route{}, err
+	}
+	if reply == nil || reply.Header.Type != syscall.RTM_NEWROUTE {
+		return Route{}, fmt.Errorf("netlink %s: no route in reply", op)
+	}
+	r, _, err := parseRoute(reply)
+	if err != nil {
+		return Route{}, fmt.Errorf("netlink %s: %w", op, err)
+	}
+	r.Dst = netip.PrefixFrom(dst, dst.BitLen())
+	return r, nil
+}
+
+// parseRoute returns the gateway, interface and metric of a route message
+// and the table it is in.
+func parseRoute(m *syscall.NetlinkMessage) (Route, uint32, error) {
+	if len(m.Data) < syscall.SizeofRtMsg {
+		return Route{}, 0, fmt.Errorf("short route message")
+	}
+	rt := (*syscall.RtMsg)(unsafe.Pointer(&m.Data[0]))
+	attrs, err := syscall.ParseNetlinkRouteAttr(m)
+	if err != nil {
+		return Route{}, 0, err
+	}
+
+	table := uint32(rt.Table)
+	r := Route{}
+	for _, a := range attrs {
+		switch a.Attr.Type {
+		case syscall.RTA_TABLE:
+			if len(a.Value) >= 4 {
+				table = binary.NativeEndian.Uint32(a.Value)
+			}
+		case syscall.RTA_GATEWAY:
+			r.Gateway, _ = netip.AddrFromSlice(a.Value)
+		case syscall.RTA_OIF:
+			if len(a.Value) >= 4 {
+				if iface, err := net.InterfaceByIndex(int(binary.NativeEndian.Uint32(a.Value))); err == nil {
+					r.Link = iface.Name
+				}
+			}
+		case syscall.RTA_PRIORITY:
+			if len(a.Value) >= 4 {
+				r.Metric = int(binary.NativeEndian.Uint32(a.Value))
+			}
+		}
+	}
+	return r, table, nil
+}
+
+func linkIndex(ifname string) (int, error) {
+	iface, err := net.InterfaceByName(ifname)
+	if err != nil {
+		return 0, &Error{Op: "link " + ifname, Errno: syscall.ENODEV}
+	}
+	return iface.Index, nil
+}
+
+func family(addr netip.Addr) uint8 {
+	if addr.Is4() {
+		return syscall.AF_INET
+	}
+	return syscall.AF_INET6
+}
+
+// appendAttr appends a route attribute padded to the 4-byte alignment.
+func appendAttr(b []byte, typ uint16, value []byte) []byte {
+	length := syscall.SizeofRtAttr + len(value)
+	header := make([]byte, syscall.SizeofRtAttr)
+	binary.NativeEndian.PutUint16(header[0:], uint16(length))
+	binary.NativeEndian.PutUint16(header[2:], typ)
+	b = append(b, header...)
+	b = append(b, value...)
+	return append(b, make([]byte, align(length)-length)...)
+}
+
+func align(n int) int {
+	return (n + syscall.NLMSG_ALIGNTO - 1) &^ (syscall.NLMSG_ALIGNTO - 1)
+}
+
+// request sends one message and waits for the kernel to acknowledge it.
+func request(op string, typ uint16, flags uint16, payload []byte) error {
+	_, err := exchange(op, typ, flags, payload)
+	return err
+}
+
+// exchange sends one message and returns the first reply, or nil if the
+// kernel only acknowledged it.
+func exchange(op string, typ uint16, flags uint16, payload []byte) (*syscall.NetlinkMessage, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return nil, fmt.Errorf("netlink socket: %w", err)
+	}
+	defer syscall.Close(fd)
+
+	kernel := &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return nil, fmt.Errorf("netlink bind: %w", err)
+	}
+
+	id := seq.Add(1)
+	msg := make([]byte, syscall.SizeofNlMsghdr, syscall.SizeofNlMsghdr+len(payload))
+	binary.NativeEndian.PutUint32(msg[0:], uint32(syscall.SizeofNlMsghdr+len(payload)))
+	binary.NativeEndian.PutUint16(msg[4:], typ)
+	binary.NativeEndian.PutUint16(msg[6:], flags|syscall.NLM_F_REQUEST|syscall.NLM_F_ACK)
+	binary.NativeEndian.PutUint32(msg[8:], id)
+	msg = append(msg, payload...)
+
+	if err := syscall.Sendto(fd, msg, 0, kernel); err != nil {
+		return nil, fmt.Errorf("netlink send: %w", err)
+	}
+
+	buf := make([]byte, 8192)
+	for {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return nil, fmt.Errorf("netlink receive: %w", err)
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return nil, fmt.Errorf("netlink receive: %w", err)
+		}
+		for i := range msgs {
+			m := &msgs[i]
+			if m.Header.Seq != id {
+				continue
+			}
+			switch m.Header.Type {
+			case syscall.NLMSG_ERROR:
+				if len(m.Data) < 4 {
+					return nil, fmt.Errorf("netlink %s: short error message", op)
+				}
+				if errno := -int32(binary.NativeEndian.Uint32(m.Data)); errno != 0 {
+					return nil, &Error{Op: op, Errno: syscall.Errno(errno)}
+				}
+				return nil, nil
+			case syscall.NLMSG_DONE:
+				return nil, nil
+			default:
+				return m, nil
+			}
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package netlink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net/netip"
+	"syscall"
+	"testing"
+)
+
+// parse wraps an encoded request payload in a netlink message, the way the
+// kernel echoes routes back in dumps.
+func parse(t *testing.T, typ uint16, payload []byte) *syscall.NetlinkMessage {
+	t.Helper()
+	msg := make([]byte, syscall.SizeofNlMsghdr)
+	binary.NativeEndian.PutUint32(msg[0:], uint32(syscall.SizeofNlMsghdr+len(payload)))
+	binary.NativeEndian.PutUint16(msg[4:], typ)
+	msgs, err := syscall.ParseNetlinkMessage(append(msg, payload...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 {
+		t.Fatalf("got %d messages", len(msgs))
+	}
+	return &msgs[0]
+}
+
+func TestAppendAttrAlignment(t *testing.T) {
+	b := appendAttr(nil, syscall.RTA_GATEWAY, []byte{1, 2, 3, 4, 5})
+	if len(b) != 12 {
+		t.Fatalf("attribute is %d bytes, want 12 (4 header, 5 value, 3 padding)", len(b))
+	}
+	if n := binary.NativeEndian.Uint16(b[0:]); n != 9 {
+		t.Fatalf("length field %d, want 9 (unpadded)", n)
+	}
+	if typ := binary.NativeEndian.Uint16(b[2:]); typ != syscall.RTA_GATEWAY {
+		t.Fatalf("type %d", typ)
+	}
+	if !bytes.Equal(b[9:], []byte{0, 0, 0}) {
+		t.Fatalf("padding %v", b[9:])
+	}
+}
+
+func TestRouteMessage(t *testing.T) {
+	r := Route{
+		Dst:     netip.MustParsePrefix("192.168.7.1/24"),
+		Gateway: netip.MustParseAddr("10.0.0.1"),
+		Link:    "lo",
+		Metric:  250,
+	}
+	payload, err := routeMessage(syscall.RTM_NEWROUTE, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rt := payload[:syscall.SizeofRtMsg]
+	if rt[0] != syscall.AF_INET || rt[1] != 24 || rt[4] != syscall.RT_TABLE_MAIN {
+		t.Fatalf("rtmsg family %d, dst len %d, table %d", rt[0], rt[1], rt[4])
+	}
+	if rt[5] != syscall.RTPROT_BOOT || rt[6] != syscall.RT_SCOPE_UNIVERSE || rt[7] != syscall.RTN_UNICAST {
+		t.Fatalf("rtmsg protocol %d, scope %d, type %d", rt[5], rt[6], rt[7])
+	}
+
+	m := parse(t, syscall.RTM_NEWROUTE, payload)
+	attrs, err := syscall.ParseNetlinkRouteAttr(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := map[uint16][]byte{}
+	for _, a := range attrs {
+		dst[a.Attr.Type] = a.Value
+	}
+	if !bytes.Equal(dst[syscall.RTA_DST], []byte{192, 168, 7, 0}) {
+		t.Fatalf("RTA_DST %v, want the masked prefix", dst[syscall.RTA_DST])
+	}
+
+	got, table, err := parseRoute(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if table != syscall.RT_TABLE_MAIN {
+		t.Fatalf("table %d", table)
+	}
+	if got.Gateway != r.Gateway || got.Link != r.Link || got.Metric != r.Metric {
+		t.Fatalf("parsed %+v, want %+v", got, r)
+	}
+}
+
+func TestRouteMessageOnLink(t *testing.T) {
+	payload, err := routeMessage(syscall.RTM_NEWROUTE, Route{Dst: netip.MustParsePrefix("fd00::/8"), Link: "lo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload[0] != syscall.AF_INET6 || payload[1] != 8 || payload[6] != syscall.RT_SCOPE_LINK {
+		t.Fatalf("rtmsg family %d, dst len %d, scope %d", payload[0], payload[1], payload[6])
+	}
+	got, _, err := parseRoute(parse(t, syscall.RTM_NEWROUTE, payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Gateway.IsValid() || got.Metric != 0 {
+		t.Fatalf("parsed %+v", got)
+	}
+}
+
+func TestRouteMessageDefaultAndDelete(t *testing.T) {
+	payload, err := routeMessage(syscall.RTM_DELROUTE, Route{Dst: netip.MustParsePrefix("0.0.0.0/0")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A default route has no RTA_DST and deletes match any scope
+	if len(payload) != syscall.SizeofRtMsg {
+		t.Fatalf("payload is %d bytes, want only the rtmsg", len(payload))
+	}
+	if payload[6] != syscall.RT_SCOPE_NOWHERE {
+		t.Fatalf("scope %d", payload[6])
+	}
+}
+
+func TestRouteMessageErrors(t *testing.T) {
+	if _, err := routeMessage(syscall.RTM_NEWROUTE, Route{}); err == nil {
+		t.Error("route without destination accepted")
+	}
+	_, err := routeMessage(syscall.RTM_NEWROUTE, Route{Dst: netip.MustParsePrefix("10.0.0.0/8"), Link: "nghost-missing0"})
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing link: got %v, want ErrNotFound", err)
+	}
+}
+
+func TestErrorIs(t *testing.T) {
+	if !errors.Is(&Error{Op: "route", Errno: syscall.EEXIST}, ErrExists) {
+		t.Error("EEXIST does not match ErrExists")
+	}
+	for _, errno := range []syscall.Errno{syscall.ESRCH, syscall.ENOENT, syscall.ENODEV, syscall.EADDRNOTAVAIL} {
+		if !errors.Is(&Error{Op: "route", Errno: errno}, ErrNotFound) {
+			t.Errorf("%v does not match ErrNotFound", errno)
+		}
+	}
+	if errors.Is(&Error{Op: "route", Errno: syscall.EPERM}, ErrNotFound) {
+		t.Error("EPERM matches ErrNotFound")
+	}
+}
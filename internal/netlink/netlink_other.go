@@ -0,0 +1,15 @@
+//go:build !linux
+
+package netlink
+
+import "net/netip"
+
+func AddAddress(ifname string, prefix netip.Prefix) error    { return ErrUnsupported }
+func DeleteAddress(ifname string, prefix netip.Prefix) error { return ErrUnsupported }
+func SetLinkUp(ifname string) error                          { return ErrUnsupported }
+func SetMTU(ifname string, mtu int) error                    { return ErrUnsupported }
+func AddRoute(r Route) error                                 { return ErrUnsupported }
+func ReplaceRoute(r Route) error                             { return ErrUnsupported }
+func DeleteRoute(r Route) error                              { return ErrUnsupported }
+func DefaultGateway(addr netip.Addr) (Route, error)          { return Route{}, ErrUnsupported }
+func LookupRoute(dst netip.Addr) (Route, error)              { return Route{}, ErrUnsupported }
@@ -0,0 +1,97 @@
+package nkn
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nknorg/nkn-sdk-go"
+)
+
+// proofMaxAge bounds how old an announcement proof may be. Proofs are bound to
+// the recipient address, so the window only has to tolerate clock skew.
+const proofMaxAge = 10 * time.Minute
+
+var (
+	ErrPeerNotAllowed = errors.New("peer public key not in allowlist")
+	ErrInvalidProof   = errors.New("invalid network key proof")
+)
+
+// Authorizer decides which NKN addresses are members of our VPN. Members must
+// use a public key from the allowlist and/or prove knowledge of the shared
+// network key in their announcements. With neither configured every peer is
+// accepted.
+type Authorizer struct {
+	allowed    map[string]bool
+	networkKey []byte
+}
+
+func NewAuthorizer(allowedPeers []string, networkKey string) *Authorizer {
+	a := &Authorizer{allowed: make(map[string]bool)}
+	for _, key := range allowedPeers {
+		a.allowed[normalizePubKey(key)] = true
+	}
+	if networkKey != "" {
+		a.networkKey = []byte(networkKey)
+	}
+	return a
+}
+
+func (a *Authorizer) Enabled() bool {
+	return a != nil && (len(a.allowed) > 0 || len(a.networkKey) > 0)
+}
+
+// Proof returns the proof that from knows the network key, bound to the
+// recipient and a timestamp. It is empty when no network key is configured.
+func (a *Authorizer) Proof(from, to string, timestamp int64) string {
+	if a == nil || len(a.networkKey) == 0 {
+		return ""
+	}
+	mac := hmac.New(sha256.New, a.networkKey)
+	fmt.Fprintf(mac, "nghost-announce|%s|%s|%d", from, to, timestamp)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Authorize checks an announcement from src addressed to us.
+func (a *Authorizer) Authorize(src, self string, timestamp int64, proof string) error {
+	if !a.Enabled() {
+		return nil
+	}
+
+	if len(a.allowed) > 0 {
+		pubKey, err := nkn.ClientAddrToPubKey(src)
+		if err != nil {
+			return fmt.Errorf("invalid peer address: %w", err)
+		}
+		if !a.allowed[hex.EncodeToString(pubKey)] {
+			return ErrPeerNotAllowed
+		}
+	}
+
+	if len(a.networkKey) > 0 {
+		age := time.Since(time.Unix(timestamp, 0))
+		if age > proofMaxAge || age < -proofMaxAge {
+			return fmt.Errorf("%w: timestamp out of range", ErrInvalidProof)
+		}
+		expected := a.Proof(src, self, timestamp)
+		if !hmac.Equal([]byte(expected), []byte(strings.ToLower(proof))) {
+			return ErrInvalidProof
+		}
+	}
+
+	return nil
+}
+
+// normalizePubKey accepts a bare hex public key or a full NKN client address
+// ("identifier.pubkey") and returns the lowercase public key.
+func normalizePubKey(key string) string {
+	key = strings.ToLower(strings.TrimSpace(key))
+	if i := strings.LastIndex(key, "."); i >= 0 {
+		key = key[i+1:]
+	}
+	return key
+}
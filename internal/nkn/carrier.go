@@ -0,0 +1,188 @@
+package nkn
+
+import (
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/nknorg/nkn-sdk-go"
+	"nghost/internal/config"
+	"nghost/internal/firewall"
+	"nghost/internal/protocol"
+	"nghost/internal/transport"
+)
+
+// nknCarrier is the transport.Carrier over the NKN network. In session mode
+// data frames are streamed over NKN sessions; control frames always go out as
+// messages so they reach peers before a session exists.
+type nknCarrier struct {
+	client      *nkn.Client
+	multiClient *nkn.MultiClient
+	sessions    *sessionTransport
+	done        chan struct{}
+	closeOnce   sync.Once
+	endpoints   *transport.Endpoints
+	log         *slog.Logger
+
+	mu     sync.RWMutex
+	recv   chan transport.Message
+	closed bool
+}
+
+func newNKNCarrier(cfg config.NKNConfig, log *slog.Logger) (*nknCarrier, error) {
+	account, err := loadAccount(cfg.IdentityFile, log)
+	if err != nil {
+		return nil, err
+	}
+
+	// Connections to seeds and nodes carry the firewall mark, so the kill
+	// switch lets them through, and their addresses are reported as
+	// endpoints before they are dialed
+	endpoints := &transport.Endpoints{}
+	dial := endpoints.DialContext(firewall.Dialer())
+	clientConfig := &nkn.ClientConfig{
+		HttpDialContext: dial,
+		WsDialContext:   dial,
+	}
+
+	client, err := nkn.NewClient(account, "", clientConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create NKN client: %w", err)
+	}
+
+	multiClient, err := nkn.NewMultiClient(account, "", 4, false, clientConfig)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to create NKN multi-client: %w", err)
+	}
+
+	n := &nknCarrier{
+		client:      client,
+		multiClient: multiClient,
+		endpoints:   endpoints,
+		recv:        make(chan transport.Message, 1024),
+		done:        make(chan struct{}),
+		log:         log,
+	}
+
+	if cfg.Transport == TransportSession {
+		if n.sessions, err = newSessionTransport(multiClient, n.deliver, log); err != nil {
+			n.Close()
+			return nil, err
+		}
+	}
+
+	go n.readMessages()
+	return n, nil
+}
+
+func (n *nknCarrier) OnEndpoint(fn func(netip.Addr)) {
+	n.endpoints.OnEndpoint(fn)
+}
+
+func (n *nknCarrier) Address() string {
+	return n.multiClient.Address()
+}
+
+func (n *nknCarrier) Send(dest string, data []byte) error {
+	select {
+	case <-n.done:
+		return transport.ErrClosed
+	default:
+	}
+
+	if n.sessions != nil && len(data) > 0 && protocol.FrameType(data[0]) == protocol.FrameData {
+		if n.sessions.send(dest, data) {
+			return nil
+		}
+	}
+	_, err := n.multiClient.Send(nkn.NewStringArray(dest), data, nil)
+	return err
+}
+
+func (n *nknCarrier) Receive() <-chan transport.Message {
+	return n.recv
+}
+
+func (n *nknCarrier) readMessages() {
+	// Wait for connection with timeout
+	select {
+	case <-n.multiClient.OnConnect.C:
+		n.log.Info("connected to NKN", "address", n.multiClient.Address())
+		nknConnected.Set(1)
+	case <-time.After(10 * time.Second):
+		n.log.Warn("NKN connection timed out, continuing anyway")
+	case <-n.done:
+		return
+	}
+
+	n.updateConnectionMetrics()
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-n.done:
+			return
+		case <-ticker.C:
+			n.updateConnectionMetrics()
+		case msg, ok := <-n.multiClient.OnMessage.C:
+			if !ok {
+				n.log.Warn("NKN message channel closed")
+				return
+			}
+			n.deliver(msg.Src, msg.Data)
+		}
+	}
+}
+
+// updateConnectionMetrics reports how many sub-clients still hold a node
+// connection; the client counts as connected while at least one does.
+func (n *nknCarrier) updateConnectionMetrics() {
+	connected := 0
+	for _, client := range n.multiClient.GetClients() {
+		if !client.IsClosed() && client.GetNode() != nil {
+			connected++
+		}
+	}
+	nknNodesConnected.Set(float64(connected))
+	if connected > 0 {
+		nknConnected.Set(1)
+	} else {
+		nknConnected.Set(0)
+	}
+}
+
+func (n *nknCarrier) deliver(src string, data []byte) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if n.closed {
+		return
+	}
+	select {
+	case n.recv <- transport.Message{Src: src, Data: data}:
+	case <-n.done:
+	}
+}
+
+func (n *nknCarrier) Close() error {
+	n.closeOnce.Do(func() {
+		close(n.done)
+		if n.sessions != nil {
+			n.sessions.close()
+		}
+		n.multiClient.Close()
+		n.client.Close()
+		nknConnected.Set(0)
+		nknNodesConnected.Set(0)
+
+		// Pending deliveries returned on done, so recv can be closed
+		n.mu.Lock()
+		n.closed = true
+		close(n.recv)
+		n.mu.Unlock()
+	})
+	return nil
+}
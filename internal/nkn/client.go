@@ -2,225 +2,552 @@ package nkn
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/nknorg/nkn-sdk-go"
 	"nghost/internal/config"
+	"nghost/internal/identity"
+	"nghost/internal/logging"
+	"nghost/internal/protocol"
+	"nghost/internal/transport"
 )
 
 type Client struct {
-	config      *config.NKNConfig
-	client      *nkn.Client
-	multiClient *nkn.MultiClient
-	peers       map[string]*Peer
-	peersMutex  sync.RWMutex
-	ctx         context.Context
-	cancel      context.CancelFunc
-	vpnEngine   VPNEngine
+	config     *config.NKNConfig
+	carrier    transport.Carrier
+	nkn        *nknCarrier // nil when running over another carrier
+	peers      map[string]*Peer
+	peersMutex sync.RWMutex
+	store      *PeerStore
+	auth       *Authorizer
+	authorized map[string]bool
+	legacy     sync.Map // addresses of peers speaking the legacy protocol
+	liveness   livenessTimeouts
+	started    time.Time
+	ctx        context.Context
+	cancel     context.CancelFunc
+	wg         sync.WaitGroup
+	vpnEngine  VPNEngine
+	log        *slog.Logger
+	logLimit   *logging.Limiter // for errors that repeat per packet
+
+	// announcement is the latest announcement of this node, sent to peers
+	// found through discovery
+	announcement      *protocol.Announcement
+	announcementMutex sync.Mutex
+
+	eventsMutex   sync.Mutex
+	eventHandlers []func(PeerEvent)
+
+	// Peer store writes are queued and written in batches by writePeers.
+	// storeFile describes the store as we last read or wrote it.
+	pending      map[string]*Peer // nil to delete
+	pendingMutex sync.Mutex
+	persist      chan struct{}
+	flushMutex   sync.Mutex
+	storeFile    os.FileInfo
 }
 
 type VPNEngine interface {
-	InjectPacket(packet []byte) error
+	InjectPacketFrom(src string, packet []byte) error
 }
 
 type Peer struct {
-	Address    string    `json:"address"`
-	Online     bool      `json:"online"`
-	LastSeen   time.Time `json:"lastSeen"`
-	IPAddress  string    `json:"ipAddress"`
-	ExitNode   bool      `json:"exitNode"`
-	Latency    int64     `json:"latency"`
+	Address     string    `json:"address"`
+	Online      bool      `json:"online"`
+	State       PeerState `json:"state,omitempty"`
+	LastSeen    time.Time `json:"lastSeen"`
+	IPAddress   string    `json:"ipAddress"`
+	IPv6Address string    `json:"ipv6Address,omitempty"`
+	Routes      []string  `json:"routes,omitempty"`
+	ExitNode    bool      `json:"exitNode"`
+	Latency     int64     `json:"latency"` // round trip time in milliseconds
+
+	// Filled in by the protocol handshake
+	Protocol     uint32   `json:"protocol,omitempty"`
+	Role         string   `json:"role,omitempty"`
+	Capabilities []string `json:"capabilities,omitempty"`
+	Features     []string `json:"features,omitempty"`
+
+	// Discovered peers are kept in memory only until they send a valid
+	// announcement. added is when this client learned about the peer.
+	discovered bool
+	added      time.Time
+
+	// The outstanding ping, measured against our own clock so the peer
+	// cannot fake its round trip time
+	pingNonce int64
+	pingSent  time.Time
 }
 
-type ControlMessage struct {
-	Type    string      `json:"type"`
-	Payload interface{} `json:"payload"`
+// NewClient creates a client on the carrier selected by cfg.Transport.
+func NewClient(cfg config.NKNConfig) (*Client, error) {
+	switch cfg.Transport {
+	case "", TransportMessage, TransportSession:
+		carrier, err := newNKNCarrier(cfg, logging.Logger(logging.NKN))
+		if err != nil {
+			return nil, err
+		}
+		c, err := newClient(cfg, carrier)
+		if err != nil {
+			carrier.Close()
+			return nil, err
+		}
+		c.nkn = carrier
+		return c, nil
+	case TransportUDP:
+		carrier, err := transport.NewUDP(cfg.UDP.Listen, cfg.UDP.Address, cfg.UDP.Key)
+		if err != nil {
+			return nil, err
+		}
+		c, err := newClient(cfg, carrier)
+		if err != nil {
+			carrier.Close()
+		}
+		return c, err
+	default:
+		return nil, fmt.Errorf("unknown transport %q", cfg.Transport)
+	}
 }
 
-type PeerAnnouncement struct {
-	IPAddress string `json:"ipAddress"`
-	ExitNode  bool   `json:"exitNode"`
+// NewClientWithCarrier creates a client that exchanges frames over carrier,
+// such as a loopback endpoint. The client closes the carrier on Close.
+// Discovery is only available on NKN.
+func NewClientWithCarrier(cfg config.NKNConfig, carrier transport.Carrier) (*Client, error) {
+	return newClient(cfg, carrier)
 }
 
-func NewClient(cfg config.NKNConfig) (*Client, error) {
-	account, err := nkn.NewAccount(nil)
+func newClient(cfg config.NKNConfig, carrier transport.Carrier) (*Client, error) {
+	liveness, err := newLivenessTimeouts(cfg.Liveness)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create NKN account: %w", err)
+		return nil, err
 	}
 
-	clientConfig := &nkn.ClientConfig{}
+	ctx, cancel := context.WithCancel(context.Background())
 
-	client, err := nkn.NewClient(account, "", clientConfig)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create NKN client: %w", err)
+	c := &Client{
+		config:     &cfg,
+		carrier:    carrier,
+		peers:      make(map[string]*Peer),
+		authorized: make(map[string]bool),
+		liveness:   liveness,
+		started:    time.Now(),
+		ctx:        ctx,
+		cancel:     cancel,
+		log:        logging.Logger(logging.NKN),
+		logLimit:   logging.NewLimiter(10 * time.Second),
+		pending:    make(map[string]*Peer),
+		persist:    make(chan struct{}, 1),
 	}
 
-	multiClient, err := nkn.NewMultiClient(account, "", 4, false, clientConfig)
-	if err != nil {
-		client.Close()
-		return nil, fmt.Errorf("failed to create NKN multi-client: %w", err)
+	if cfg.PeersFile != "" {
+		c.store = NewPeerStore(cfg.PeersFile)
+		if err := c.syncPeers(); err != nil {
+			c.log.Warn("failed to load peer store", "err", err)
+		}
+		c.goroutine(c.writePeers)
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-
-	c := &Client{
-		config:      &cfg,
-		client:      client,
-		multiClient: multiClient,
-		peers:       make(map[string]*Peer),
-		ctx:         ctx,
-		cancel:      cancel,
+	for i := 0; i < messageWorkers; i++ {
+		c.goroutine(c.handleMessages)
 	}
-
-	go c.handleMessages()
+	c.goroutine(c.monitorPeers)
 
 	return c, nil
 }
 
-func (c *Client) handleMessages() {
-	// Wait for connection with timeout
-	select {
-	case <-c.multiClient.OnConnect.C:
-		fmt.Printf("🔗 NKN client connected\n")
-	case <-time.After(10 * time.Second):
-		fmt.Printf("⚠️  NKN connection timeout, continuing anyway...\n")
-	case <-c.ctx.Done():
-		return
+// loadAccount returns the persistent node account, or an ephemeral one when
+// no identity file is configured.
+func loadAccount(identityFile string, log *slog.Logger) (*nkn.Account, error) {
+	if identityFile == "" {
+		account, err := nkn.NewAccount(nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create NKN account: %w", err)
+		}
+		return account, nil
 	}
 
+	id, created, err := identity.NewStore(identityFile).LoadOrCreate()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load node identity: %w", err)
+	}
+	if created {
+		log.Info("generated new node identity", "file", identityFile)
+	}
+
+	account, err := id.Account()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load node identity: %w", err)
+	}
+	return account, nil
+}
+
+// goroutine runs fn in the background until the client is closed.
+func (c *Client) goroutine(fn func()) {
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		fn()
+	}()
+}
+
+// messageWorkers is the number of handleMessages workers. It bounds the
+// frames handled at once, so a flood of frames cannot pile up goroutines;
+// excess frames wait in the carrier's queue or are dropped there.
+const messageWorkers = 32
+
+func (c *Client) handleMessages() {
+	messages := c.carrier.Receive()
 	for {
 		select {
 		case <-c.ctx.Done():
 			return
-		case msg, ok := <-c.multiClient.OnMessage.C:
+		case msg, ok := <-messages:
 			if !ok {
-				fmt.Printf("📡 NKN message channel closed\n")
 				return
 			}
-			go c.processMessage(msg)
+			c.handleFrame(msg.Src, msg.Data)
 		}
 	}
 }
 
-func (c *Client) processMessage(msg *nkn.Message) {
-	// Handle different message types for VPN functionality
-	if msg.Encrypted {
-		// Handle VPN packet data
-		c.handleVPNPacket(msg)
-	} else {
-		// Handle control messages
-		c.handleControlMessage(msg)
+// handleFrame dispatches a frame received from src.
+func (c *Client) handleFrame(src string, data []byte) {
+	frame, err := protocol.DecodeFrame(data)
+	if err != nil {
+		// Frame types added by newer versions are dropped, unframed
+		// traffic may come from older ones
+		c.handleLegacy(src, data)
+		return
+	}
+	c.upgraded(src)
+
+	switch frame.Type {
+	case protocol.FrameData:
+		c.handleVPNPacket(src, frame.Payload)
+	case protocol.FrameControl:
+		c.handleControlMessage(src, frame.Payload)
 	}
 }
 
-func (c *Client) handleVPNPacket(msg *nkn.Message) {
+func (c *Client) handleVPNPacket(src string, packet []byte) {
+	if !c.isAuthorized(src) {
+		return
+	}
+
 	// Forward received packet to TUN interface
 	if c.vpnEngine != nil {
-		if err := c.vpnEngine.InjectPacket(msg.Data); err != nil {
-			fmt.Printf("Failed to inject packet: %v\n", err)
+		if err := c.vpnEngine.InjectPacketFrom(src, packet); err != nil {
+			c.logLimit.Log(c.log, slog.LevelWarn, "inject", "failed to inject packet", "peer", src, "err", err)
 		}
 	}
 }
 
-func (c *Client) handleControlMessage(msg *nkn.Message) {
-	var controlMsg ControlMessage
-	if err := json.Unmarshal(msg.Data, &controlMsg); err != nil {
+func (c *Client) handleControlMessage(src string, data []byte) {
+	m, err := protocol.Unmarshal(data)
+	if err != nil {
+		if errors.Is(err, protocol.ErrUnsupportedVersion) {
+			c.log.Warn("ignoring message", "peer", src, "err", err)
+		}
 		return
 	}
+	c.handleMessage(src, m)
+}
 
-	switch controlMsg.Type {
-	case "peer_announcement":
-		c.handlePeerAnnouncement(msg.Src, controlMsg.Payload)
-	case "ping":
-		c.handlePing(msg.Src)
-	case "pong":
-		c.handlePong(msg.Src)
+func (c *Client) handleMessage(src string, m *protocol.Message) {
+	switch m.Type {
+	case protocol.TypeHello, protocol.TypeHelloAck:
+		c.handleHello(src, m.Type, m.Hello)
+	case protocol.TypeAnnouncement:
+		c.handlePeerAnnouncement(src, m.Announcement)
+	case protocol.TypePing:
+		if !c.isAuthorized(src) {
+			return
+		}
+		c.handlePing(src, m.Ping)
+	case protocol.TypePong:
+		if !c.isAuthorized(src) {
+			return
+		}
+		c.handlePong(src, m.Ping)
+	case protocol.TypeLeaseRequest:
+		c.handleLeaseRequest(src, m.LeaseRequest)
+	case protocol.TypeLeaseGrant:
+		c.handleLeaseGrant(src, m.LeaseGrant)
+	case protocol.TypeDiscovery:
+		c.handleDiscovery(src, m.Discovery)
+	case protocol.TypeGoodbye:
+		if !c.isAuthorized(src) {
+			return
+		}
+		c.handleGoodbye(src, m.Goodbye)
 	}
 }
 
-func (c *Client) handlePeerAnnouncement(src string, payload interface{}) {
-	data, _ := json.Marshal(payload)
-	var announcement PeerAnnouncement
-	if err := json.Unmarshal(data, &announcement); err != nil {
+func (c *Client) handlePeerAnnouncement(src string, announcement *protocol.Announcement) {
+	if err := c.auth.Authorize(src, c.GetAddress(), announcement.Timestamp, announcement.Proof); err != nil {
+		c.log.Warn("rejected announcement", "peer", src, "err", err)
 		return
 	}
 
 	c.peersMutex.Lock()
-	defer c.peersMutex.Unlock()
+	c.authorized[src] = true
 
 	peer, exists := c.peers[src]
 	if !exists {
-		peer = &Peer{Address: src}
+		peer = &Peer{Address: src, added: time.Now()}
 		c.peers[src] = peer
-		fmt.Printf("🆕 New peer discovered: %s\n", src[:16]+"...")
+		c.log.Info("new peer", "peer", src)
 	}
 
+	peer.discovered = false
 	peer.IPAddress = announcement.IPAddress
+	peer.IPv6Address = announcement.IPv6Address
+	peer.Routes = announcement.Routes
 	peer.ExitNode = announcement.ExitNode
-	peer.Online = true
 	peer.LastSeen = time.Now()
+	event, changed := c.setState(peer, PeerOnline)
+	c.persistPeer(peer)
+	c.peersMutex.Unlock()
 
-	fmt.Printf("📢 Peer %s announced: IP=%s, ExitNode=%v\n", src[:16]+"...", announcement.IPAddress, announcement.ExitNode)
+	c.log.Debug("peer announced", "peer", src, "ip", announcement.IPAddress, "exit", announcement.ExitNode)
+
+	// The peer's hello may have arrived before it was authorized
+	if !c.handshakeDone(src) {
+		c.sendHello(src, protocol.TypeHello)
+	}
 
 	// Notify VPN engine about new peer route
-	if c.vpnEngine != nil && announcement.IPAddress != "" {
+	if c.vpnEngine != nil {
 		if routeEngine, ok := c.vpnEngine.(interface{ AddPeerRoute(string, string) error }); ok {
-			routeEngine.AddPeerRoute(announcement.IPAddress, src)
+			for _, ip := range []string{announcement.IPAddress, announcement.IPv6Address} {
+				if ip != "" {
+					routeEngine.AddPeerRoute(ip, src)
+				}
+			}
+		}
+		if subnetEngine, ok := c.vpnEngine.(interface{ SetPeerSubnets(string, []string) error }); ok {
+			subnetEngine.SetPeerSubnets(src, announcement.Routes)
 		}
 	}
+
+	if changed {
+		c.emit(event)
+	}
 }
 
-func (c *Client) handlePing(src string) {
-	pong := ControlMessage{
-		Type:    "pong",
-		Payload: time.Now().Unix(),
+func (c *Client) handleLeaseRequest(src string, req *protocol.LeaseRequest) {
+	if err := c.auth.Authorize(src, c.GetAddress(), req.Timestamp, req.Proof); err != nil {
+		c.log.Warn("rejected lease request", "peer", src, "err", err)
+		return
+	}
+
+	leaser, ok := c.vpnEngine.(interface {
+		HandleLeaseRequest(src, requested string) (string, error)
+	})
+	if !ok {
+		return
+	}
+
+	ip, err := leaser.HandleLeaseRequest(src, req.RequestedIP)
+	if err != nil {
+		c.log.Warn("failed to lease address", "peer", src, "err", err)
+		return
+	}
+	c.send(src, &protocol.Message{
+		Type:       protocol.TypeLeaseGrant,
+		LeaseGrant: &protocol.LeaseGrant{IPAddress: ip},
+	})
+}
+
+func (c *Client) handleLeaseGrant(src string, grant *protocol.LeaseGrant) {
+	if leaser, ok := c.vpnEngine.(interface{ HandleLeaseGrant(src, address string) }); ok {
+		leaser.HandleLeaseGrant(src, grant.IPAddress)
+	}
+}
+
+// RequestLease asks coordinator to lease an address, preferring requested.
+// The grant is delivered to the VPN engine.
+func (c *Client) RequestLease(coordinator, requested string) error {
+	timestamp := time.Now().Unix()
+	return c.send(coordinator, &protocol.Message{
+		Type: protocol.TypeLeaseRequest,
+		LeaseRequest: &protocol.LeaseRequest{
+			RequestedIP: requested,
+			Timestamp:   timestamp,
+			Proof:       c.auth.Proof(c.GetAddress(), coordinator, timestamp),
+		},
+	})
+}
+
+func (c *Client) send(dest string, m *protocol.Message) error {
+	if c.isLegacy(dest) {
+		data, err := protocol.MarshalLegacy(m)
+		if errors.Is(err, protocol.ErrNoLegacy) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return c.carrier.Send(dest, data)
 	}
-	data, _ := json.Marshal(pong)
-	c.multiClient.Send(nkn.NewStringArray(src), data, nil)
+	return c.carrier.Send(dest, controlFrame(m))
+}
+
+func controlFrame(m *protocol.Message) []byte {
+	return protocol.EncodeFrame(protocol.FrameControl, 0, protocol.Marshal(m))
+}
+
+func (c *Client) handlePing(src string, ping *protocol.Ping) {
+	// Echo the ping so the sender can compute the round trip time
+	c.send(src, &protocol.Message{Type: protocol.TypePong, Ping: ping})
 }
 
-func (c *Client) handlePong(src string) {
+func (c *Client) handlePong(src string, ping *protocol.Ping) {
+	now := time.Now()
+
 	c.peersMutex.Lock()
-	defer c.peersMutex.Unlock()
+	peer, exists := c.peers[src]
+	if !exists {
+		c.peersMutex.Unlock()
+		return
+	}
+	peer.LastSeen = now
+	if ping.Sent != 0 && ping.Sent == peer.pingNonce {
+		rtt := now.Sub(peer.pingSent)
+		peer.pingNonce = 0
+		peer.Latency = rtt.Milliseconds()
+		peerRTT.With(src).Observe(rtt.Seconds())
+	}
 
-	if peer, exists := c.peers[src]; exists {
-		peer.LastSeen = time.Now()
-		peer.Online = true
+	event, changed := c.setState(peer, PeerOnline)
+	if changed {
+		c.persistPeer(peer)
+	}
+	c.peersMutex.Unlock()
+
+	if changed {
+		c.emit(event)
 	}
 }
 
-func (c *Client) SendPacket(dest string, data []byte) error {
-	onMessage, err := c.multiClient.Send(nkn.NewStringArray(dest), data, nil)
+// Ping sends a ping to dest. The pong updates the peer's LastSeen and
+// Latency (round trip time in milliseconds).
+func (c *Client) Ping(dest string) error {
+	nonce, err := pingNonce()
 	if err != nil {
 		return err
 	}
-	_ = onMessage // Handle response if needed
-	return nil
+	c.peersMutex.Lock()
+	if peer, exists := c.peers[dest]; exists {
+		peer.pingNonce = nonce
+		peer.pingSent = time.Now()
+	}
+	c.peersMutex.Unlock()
+
+	return c.send(dest, &protocol.Message{
+		Type: protocol.TypePing,
+		Ping: &protocol.Ping{Sent: nonce},
+	})
+}
+
+// pingNonce returns a random, non-zero ping nonce.
+func pingNonce() (int64, error) {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return 0, err
+	}
+	return int64(binary.BigEndian.Uint64(b[:])>>1) | 1, nil
+}
+
+func (c *Client) SendPacket(dest string, data []byte) error {
+	if c.isLegacy(dest) {
+		return c.carrier.Send(dest, data)
+	}
+	return c.carrier.Send(dest, protocol.EncodeFrame(protocol.FrameData, 0, data))
+}
+
+// SessionActive reports whether packets to dest currently go over an NKN
+// session rather than individual messages.
+func (c *Client) SessionActive(dest string) bool {
+	return c.nkn != nil && c.nkn.sessions != nil && c.nkn.sessions.active(dest)
 }
 
 func (c *Client) GetAddress() string {
-	return c.multiClient.Address()
+	return c.carrier.Address()
+}
+
+// ShortAddress abbreviates addr for log output. Addresses of other carriers
+// may be shorter than an NKN address.
+func ShortAddress(addr string) string {
+	if len(addr) > 16 {
+		return addr[:16] + "..."
+	}
+	return addr
+}
+
+// ValidateAddress checks that addr is a peer address on the transport of cfg:
+// a host:port pair for UDP, otherwise an NKN client address
+// ("identifier.pubkey") or a bare hex public key.
+func ValidateAddress(cfg config.NKNConfig, addr string) error {
+	if cfg.Transport == TransportUDP {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return fmt.Errorf("invalid UDP address %q: %w", addr, err)
+		}
+		if n, err := strconv.Atoi(port); host == "" || err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("invalid UDP address %q: want host:port", addr)
+		}
+		return nil
+	}
+	if _, err := nkn.ClientAddrToPubKey(addr); err != nil {
+		return fmt.Errorf("invalid NKN address %q: %w", addr, err)
+	}
+	return nil
+}
+
+// ValidateAddress checks that addr is a peer address on this client's
+// transport.
+func (c *Client) ValidateAddress(addr string) error {
+	return ValidateAddress(*c.config, addr)
 }
 
 func (c *Client) AddPeer(address string) {
 	c.peersMutex.Lock()
 	defer c.peersMutex.Unlock()
-	c.peers[address] = &Peer{
-		Address: address,
-		Online:  false,
+	peer, exists := c.peers[address]
+	if !exists {
+		peer = &Peer{
+			Address: address,
+			Online:  false,
+			State:   PeerOffline,
+			added:   time.Now(),
+		}
+		c.peers[address] = peer
 	}
-	fmt.Printf("🔗 Added peer manually: %s\n", address[:16]+"...")
+	peer.discovered = false
+	c.persistPeer(peer)
+	c.log.Info("added peer", "peer", address)
 }
 
-func (c *Client) GetPeers() map[string]*Peer {
+// GetPeers returns a snapshot of the known peers. The peers are copies, as
+// incoming messages keep updating the live entries.
+func (c *Client) GetPeers() map[string]Peer {
 	c.peersMutex.RLock()
 	defer c.peersMutex.RUnlock()
-	peers := make(map[string]*Peer)
+	peers := make(map[string]Peer, len(c.peers))
 	for k, v := range c.peers {
-		peers[k] = v
+		peers[k] = *v
 	}
 	return peers
 }
@@ -229,65 +556,117 @@ func (c *Client) SetVPNEngine(engine VPNEngine) {
 	c.vpnEngine = engine
 }
 
-func (c *Client) AnnouncePeer(ipAddress string, isExitNode bool) error {
-	announcement := ControlMessage{
-		Type: "peer_announcement",
-		Payload: PeerAnnouncement{
-			IPAddress: ipAddress,
-			ExitNode:  isExitNode,
-		},
+// SetAuthorizer restricts which peers may join. Peers become authorized once
+// they send a valid announcement; until then their packets are dropped.
+func (c *Client) SetAuthorizer(auth *Authorizer) {
+	c.peersMutex.Lock()
+	defer c.peersMutex.Unlock()
+	c.auth = auth
+	c.authorized = make(map[string]bool)
+	if !auth.Enabled() {
+		c.log.Warn("peer authorization disabled, any NKN address can join this VPN")
 	}
-	data, err := json.Marshal(announcement)
-	if err != nil {
-		return err
+}
+
+// OnEndpoint sets fn to be called with the addresses of the seeds, nodes or
+// UDP peers the carrier exchanges traffic with, before it does so. Carriers
+// that stay in process report none.
+func (c *Client) OnEndpoint(fn func(netip.Addr)) {
+	if r, ok := c.carrier.(transport.EndpointReporter); ok {
+		r.OnEndpoint(fn)
 	}
+}
 
-	// Only send to known peers - skip broadcast for now
+func (c *Client) isAuthorized(src string) bool {
 	c.peersMutex.RLock()
 	defer c.peersMutex.RUnlock()
-	
-	if len(c.peers) == 0 {
-		fmt.Printf("⚠️  No peers to announce to (use manual connection)\n")
+	return !c.auth.Enabled() || c.authorized[src]
+}
+
+func (c *Client) AnnouncePeer(ipAddress, ipv6Address string, routes []string, isExitNode bool) error {
+	// Pick up peers added by CLI commands since the last announcement
+	if err := c.syncPeers(); err != nil {
+		c.log.Warn("failed to sync peer store", "err", err)
+	}
+
+	c.announcementMutex.Lock()
+	c.announcement = &protocol.Announcement{
+		IPAddress:   ipAddress,
+		IPv6Address: ipv6Address,
+		Routes:      routes,
+		ExitNode:    isExitNode,
+	}
+	c.announcementMutex.Unlock()
+
+	// New nodes find us through the discovery topic; announcements go to
+	// the peers we know
+	c.peersMutex.RLock()
+	addrs := make([]string, 0, len(c.peers))
+	for addr := range c.peers {
+		addrs = append(addrs, addr)
+	}
+	c.peersMutex.RUnlock()
+
+	if len(addrs) == 0 {
+		c.log.Warn("no peers to announce to yet, set vpn.networkName or add peers manually")
 		return nil
 	}
-	
-	for _, peer := range c.peers {
-		c.multiClient.Send(nkn.NewStringArray(peer.Address), data, nil)
+
+	for _, addr := range addrs {
+		if err := c.announceTo(addr); err != nil {
+			c.log.Warn("failed to announce", "peer", addr, "err", err)
+		}
 	}
-	
 	return nil
 }
 
-func (c *Client) FindExitNodes() []*Peer {
+// announceTo sends our latest announcement to dest. The proof is bound to the
+// recipient, so every peer gets its own copy.
+func (c *Client) announceTo(dest string) error {
+	c.announcementMutex.Lock()
+	if c.announcement == nil {
+		c.announcementMutex.Unlock()
+		return nil
+	}
+	announcement := *c.announcement
+	c.announcementMutex.Unlock()
+
+	if !c.handshakeDone(dest) {
+		c.sendHello(dest, protocol.TypeHello)
+	}
+
+	announcement.Timestamp = time.Now().Unix()
+	announcement.Proof = c.auth.Proof(c.GetAddress(), dest, announcement.Timestamp)
+	return c.send(dest, &protocol.Message{
+		Type:         protocol.TypeAnnouncement,
+		Announcement: &announcement,
+	})
+}
+
+func (c *Client) FindExitNodes() []Peer {
 	c.peersMutex.RLock()
 	defer c.peersMutex.RUnlock()
 
-	var exitNodes []*Peer
+	var exitNodes []Peer
 	for _, peer := range c.peers {
-		if peer.ExitNode && peer.Online {
-			exitNodes = append(exitNodes, peer)
+		// Stale exit nodes are skipped so flows fail over before the peer
+		// is declared offline
+		if peer.ExitNode && peer.State == PeerOnline {
+			exitNodes = append(exitNodes, *peer)
 		}
 	}
 	return exitNodes
 }
 
 func (c *Client) Close() error {
-	fmt.Printf("🔌 Closing NKN client...\n")
-	
-	// Cancel context first to stop goroutines
+	c.log.Debug("closing client")
+
+	// Stop goroutines before the carrier goes away under them
 	c.cancel()
-	
-	// Give goroutines time to exit gracefully
-	time.Sleep(100 * time.Millisecond)
-	
-	// Close clients
-	if c.multiClient != nil {
-		c.multiClient.Close()
-	}
-	if c.client != nil {
-		c.client.Close()
-	}
-	
-	fmt.Printf("✅ NKN client closed\n")
+	c.wg.Wait()
+
+	c.carrier.Close()
+
+	c.log.Info("client closed")
 	return nil
 }
\ No newline at end of file
@@ -0,0 +1,139 @@
+package nkn
+
+import (
+	"encoding/hex"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nknorg/nkn-sdk-go"
+	"nghost/internal/config"
+	"nghost/internal/protocol"
+	"nghost/internal/transport"
+)
+
+func TestValidateAddress(t *testing.T) {
+	account, err := nkn.NewAccount(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKey := hex.EncodeToString(account.PubKey())
+
+	var message, udp config.NKNConfig
+	udp.Transport = TransportUDP
+	tests := []struct {
+		cfg   config.NKNConfig
+		addr  string
+		valid bool
+	}{
+		{message, pubKey, true},
+		{message, "nghost." + pubKey, true},
+		{message, "short", false},
+		{message, "nghost." + pubKey[:10], false},
+		{message, "192.168.1.10:7946", false},
+		{udp, "192.168.1.10:7946", true},
+		{udp, "node.lan:7946", true},
+		{udp, pubKey, false},
+		{udp, ":7946", false},
+		{udp, "192.168.1.10:0", false},
+	}
+	for _, tt := range tests {
+		err := ValidateAddress(tt.cfg, tt.addr)
+		if (err == nil) != tt.valid {
+			t.Errorf("ValidateAddress(%q, %q) = %v, want valid %v", tt.cfg.Transport, tt.addr, err, tt.valid)
+		}
+	}
+}
+
+// blockingEngine blocks every injected packet until release is closed.
+type blockingEngine struct {
+	release chan struct{}
+	active  atomic.Int32
+	max     atomic.Int32
+}
+
+func (e *blockingEngine) InjectPacketFrom(src string, packet []byte) error {
+	n := e.active.Add(1)
+	defer e.active.Add(-1)
+	for {
+		max := e.max.Load()
+		if n <= max || e.max.CompareAndSwap(max, n) {
+			break
+		}
+	}
+	<-e.release
+	return nil
+}
+
+func TestFrameHandlersAreBoundedAndAwaited(t *testing.T) {
+	hub := transport.NewHub()
+	a := newTestClient(t, hub, "a", "")
+	engine := &blockingEngine{release: make(chan struct{})}
+	a.SetVPNEngine(engine)
+	peer, err := hub.Endpoint("peer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	frame := protocol.EncodeFrame(protocol.FrameData, 0, []byte{0x45, 0, 0, 20})
+	for i := 0; i < 4*messageWorkers; i++ {
+		peer.Send("a", frame)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for engine.active.Load() < messageWorkers {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d handlers running, want %d", engine.active.Load(), messageWorkers)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		a.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatal("Close returned while frames were still being handled")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(engine.release)
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close did not return")
+	}
+	if max := engine.max.Load(); max > messageWorkers {
+		t.Fatalf("%d frames handled at once, want at most %d", max, messageWorkers)
+	}
+}
+
+// signalEngine reports every injected packet on a channel.
+type signalEngine chan struct{}
+
+func (e signalEngine) InjectPacketFrom(string, []byte) error {
+	e <- struct{}{}
+	return nil
+}
+
+// BenchmarkSendPacket measures the client overhead of a packet round over an
+// in-memory carrier, without the NKN network; -bench-transport measures the
+// network itself.
+func BenchmarkSendPacket(b *testing.B) {
+	hub := transport.NewHub()
+	a := newTestClient(b, hub, "a", "")
+	peer := newTestClient(b, hub, "b", "")
+	received := make(signalEngine)
+	peer.SetVPNEngine(received)
+
+	packet := make([]byte, 1400)
+	packet[0] = 0x45
+	b.SetBytes(int64(len(packet)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := a.SendPacket("b", packet); err != nil {
+			b.Fatal(err)
+		}
+		<-received
+	}
+}
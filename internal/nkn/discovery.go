@@ -0,0 +1,166 @@
+package nkn
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/nknorg/nkn-sdk-go"
+	"nghost/internal/protocol"
+)
+
+const (
+	// discoveryInterval is how often the topic is polled for new members and
+	// our presence is published.
+	discoveryInterval = time.Minute
+	// subscribeDuration is the subscription lifetime in blocks (about a
+	// day at 20 seconds per block). It is renewed well before it expires.
+	subscribeDuration = 4320
+	subscribeRenewal  = 12 * time.Hour
+	// maxDiscoveredPeers bounds the peers kept from discovery before they
+	// announce themselves.
+	maxDiscoveredPeers = 1024
+)
+
+var errDiscoveryUnsupported = errors.New("peer discovery needs the NKN transport")
+
+// discoveryRecipient takes the place of the recipient in the proof of a
+// Discovery, which is published to all topic members at once. Only the
+// sender can use it, so a replayed proof merely makes us announce to it.
+const discoveryRecipient = "discovery"
+
+// DiscoveryTopic derives the NKN pub/sub topic of a network. The network key
+// is mixed in so networks with the same name but different keys do not see
+// each other, and the key itself never appears on chain.
+func DiscoveryTopic(networkName, networkKey string) string {
+	sum := sha256.Sum256([]byte("nghost-topic|" + networkName + "|" + networkKey))
+	return "nghost." + hex.EncodeToString(sum[:16])
+}
+
+// StartDiscovery subscribes to topic, periodically adds its members as peers
+// and publishes our presence there. Members reply to a published Discovery
+// with their announcement.
+func (c *Client) StartDiscovery(topic string) {
+	if c.nkn == nil {
+		c.log.Warn("peer discovery needs the NKN transport, add peers manually")
+		return
+	}
+	c.log.Info("peer discovery started", "topic", topic)
+	c.goroutine(func() { c.discover(topic) })
+}
+
+func (c *Client) discover(topic string) {
+	ticker := time.NewTicker(discoveryInterval)
+	defer ticker.Stop()
+
+	var subscribed time.Time
+	for {
+		if time.Since(subscribed) > subscribeRenewal {
+			// Subscribing is a chain transaction; it becomes visible to
+			// others once it reaches the transaction pool
+			if _, err := c.nkn.multiClient.Subscribe("", topic, subscribeDuration, "", nil); err != nil {
+				c.log.Warn("failed to subscribe to discovery topic", "err", err)
+			} else {
+				subscribed = time.Now()
+			}
+		}
+
+		if err := c.Discover(topic); err != nil {
+			c.log.Warn("peer discovery failed", "err", err)
+		}
+
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Discover adds every member of topic as a peer and publishes our presence
+// there, without subscribing to it.
+func (c *Client) Discover(topic string) error {
+	if c.nkn == nil {
+		return errDiscoveryUnsupported
+	}
+	if err := c.discoverPeers(topic); err != nil {
+		return fmt.Errorf("failed to list topic members: %w", err)
+	}
+
+	data := controlFrame(&protocol.Message{
+		Type:      protocol.TypeDiscovery,
+		Discovery: c.discovery(),
+	})
+	if err := c.nkn.multiClient.Publish(topic, data, &nkn.MessageConfig{TxPool: true}); err != nil {
+		return fmt.Errorf("failed to publish to topic: %w", err)
+	}
+	return nil
+}
+
+func (c *Client) discoverPeers(topic string) error {
+	res, err := c.nkn.multiClient.GetSubscribers(topic, 0, 1000, false, true, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, members := range []map[string]string{res.Subscribers.Map(), res.SubscribersInTxPool.Map()} {
+		for addr := range members {
+			c.addDiscoveredPeer(addr)
+		}
+	}
+	return nil
+}
+
+// discovery returns the Discovery announcing our presence.
+func (c *Client) discovery() *protocol.Discovery {
+	timestamp := time.Now().Unix()
+	return &protocol.Discovery{
+		Timestamp: timestamp,
+		Proof:     c.auth.Proof(c.GetAddress(), discoveryRecipient, timestamp),
+	}
+}
+
+// handleDiscovery answers a member that published its presence with our
+// announcement, so it learns about us without waiting for the next poll.
+func (c *Client) handleDiscovery(src string, discovery *protocol.Discovery) {
+	if normalizePubKey(src) == normalizePubKey(c.GetAddress()) {
+		return
+	}
+	if err := c.auth.Authorize(src, discoveryRecipient, discovery.Timestamp, discovery.Proof); err != nil {
+		c.logLimit.Log(c.log, slog.LevelWarn, "discovery", "rejected discovery", "peer", src, "err", err)
+		return
+	}
+	c.addDiscoveredPeer(src)
+	c.announceTo(src)
+}
+
+// addDiscoveredPeer records addr as a peer. Discovered peers are not trusted
+// and not persisted until they send a valid announcement, and are forgotten
+// if they stay silent.
+func (c *Client) addDiscoveredPeer(addr string) {
+	if normalizePubKey(addr) == normalizePubKey(c.GetAddress()) {
+		return
+	}
+
+	c.peersMutex.Lock()
+	defer c.peersMutex.Unlock()
+
+	if _, exists := c.peers[addr]; exists {
+		return
+	}
+	discovered := 0
+	for _, peer := range c.peers {
+		if peer.discovered {
+			discovered++
+		}
+	}
+	if discovered >= maxDiscoveredPeers {
+		c.logLimit.Log(c.log, slog.LevelWarn, "discovered", "too many unannounced peers, ignoring discovered peer", "peer", addr)
+		return
+	}
+	c.peers[addr] = &Peer{Address: addr, State: PeerOffline, discovered: true, added: time.Now()}
+	c.log.Info("discovered peer", "peer", addr)
+}
@@ -0,0 +1,152 @@
+package nkn
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"nghost/internal/config"
+	"nghost/internal/protocol"
+	"nghost/internal/transport"
+)
+
+// newTestClient returns a client on a loopback hub that keeps its peers in a
+// temporary store and requires networkKey.
+func newTestClient(t testing.TB, hub *transport.Hub, addr, networkKey string) *Client {
+	t.Helper()
+	endpoint, err := hub.Endpoint(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.NKNConfig{PeersFile: filepath.Join(t.TempDir(), "peers.json")}
+	c, err := NewClientWithCarrier(cfg, endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetAuthorizer(NewAuthorizer(nil, networkKey))
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func storedPeers(t *testing.T, c *Client) map[string]*Peer {
+	t.Helper()
+	peers, err := c.store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return peers
+}
+
+func TestDiscoveredPeersAreNotPersisted(t *testing.T) {
+	hub := transport.NewHub()
+	a := newTestClient(t, hub, "a", "secret")
+	b := newTestClient(t, hub, "b", "secret")
+
+	a.handleDiscovery("b", b.discovery())
+	if _, ok := a.GetPeers()["b"]; !ok {
+		t.Fatal("discovered peer not kept in memory")
+	}
+	if _, ok := storedPeers(t, a)["b"]; ok {
+		t.Fatal("discovered peer persisted before announcing")
+	}
+
+	// b announces itself with a valid proof
+	b.AddPeer("a")
+	if err := b.AnnouncePeer("10.100.0.2", "", nil, false); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if peer, ok := storedPeers(t, a)["b"]; ok {
+			if peer.IPAddress != "10.100.0.2" {
+				t.Fatalf("stored peer %+v", peer)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("announced peer not persisted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestUnauthenticatedDiscoveryIsNotTrusted(t *testing.T) {
+	hub := transport.NewHub()
+	a := newTestClient(t, hub, "a", "secret")
+	b := newTestClient(t, hub, "b", "other")
+
+	a.handleDiscovery("b", b.discovery())
+	b.AddPeer("a")
+	b.AnnouncePeer("10.100.0.2", "", nil, false)
+	b.Ping("a")
+	time.Sleep(100 * time.Millisecond)
+
+	if a.isAuthorized("b") {
+		t.Fatal("peer with the wrong network key authorized")
+	}
+	if _, ok := storedPeers(t, a)["b"]; ok {
+		t.Fatal("peer with the wrong network key persisted")
+	}
+}
+
+func TestNeverSeenPeersExpire(t *testing.T) {
+	hub := transport.NewHub()
+	a := newTestClient(t, hub, "a", "")
+	a.liveness.removeAfter = 50 * time.Millisecond
+
+	a.addDiscoveredPeer("ghost")
+	a.AddPeer("manual")
+	a.checkPeers()
+	if peers := a.GetPeers(); len(peers) != 2 {
+		t.Fatalf("peers removed early: %v", peers)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	a.checkPeers()
+	if peers := a.GetPeers(); len(peers) != 0 {
+		t.Fatalf("never seen peers kept: %v", peers)
+	}
+	a.flushPeers()
+	if peers := storedPeers(t, a); len(peers) != 0 {
+		t.Fatalf("never seen peers still stored: %v", peers)
+	}
+}
+
+func TestDiscoveredPeersAreCapped(t *testing.T) {
+	hub := transport.NewHub()
+	a := newTestClient(t, hub, "a", "")
+	for i := 0; i < maxDiscoveredPeers+10; i++ {
+		a.addDiscoveredPeer(fmt.Sprintf("peer-%d", i))
+	}
+	if n := len(a.GetPeers()); n != maxDiscoveredPeers {
+		t.Fatalf("%d discovered peers kept, want %d", n, maxDiscoveredPeers)
+	}
+}
+
+func TestDiscoveryNeedsProof(t *testing.T) {
+	hub := transport.NewHub()
+	a := newTestClient(t, hub, "a", "secret")
+	if err := a.AnnouncePeer("10.100.0.1", "", []string{"192.168.1.0/24"}, true); err != nil {
+		t.Fatal(err)
+	}
+	stranger, err := hub.Endpoint("stranger")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	discovery := &protocol.Message{
+		Type:      protocol.TypeDiscovery,
+		Discovery: &protocol.Discovery{Timestamp: time.Now().Unix()},
+	}
+	stranger.Send("a", controlFrame(discovery))
+
+	select {
+	case msg := <-stranger.Receive():
+		t.Fatalf("discovery without proof answered with %x", msg.Data)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if _, ok := a.GetPeers()["stranger"]; ok {
+		t.Fatal("discovery without proof added a peer")
+	}
+}
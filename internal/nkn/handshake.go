@@ -0,0 +1,84 @@
+package nkn
+
+import (
+	"strings"
+
+	"nghost/internal/protocol"
+)
+
+// localHello describes this node for the protocol handshake, based on its
+// latest announcement.
+func (c *Client) localHello() *protocol.Hello {
+	role := protocol.RoleClient
+	var capabilities []string
+
+	c.announcementMutex.Lock()
+	if a := c.announcement; a != nil {
+		if a.ExitNode {
+			role = protocol.RoleExit
+			capabilities = append(capabilities, protocol.CapabilityExit)
+		}
+		if len(a.Routes) > 0 {
+			capabilities = append(capabilities, protocol.CapabilitySubnetRouter)
+		}
+		if a.IPv6Address != "" {
+			capabilities = append(capabilities, protocol.CapabilityIPv6)
+		}
+	}
+	c.announcementMutex.Unlock()
+
+	return protocol.NewHello(role, capabilities)
+}
+
+func (c *Client) sendHello(dest string, msgType protocol.Type) error {
+	return c.send(dest, &protocol.Message{Type: msgType, Hello: c.localHello()})
+}
+
+// handshakeDone reports whether addr completed the handshake with us.
+func (c *Client) handshakeDone(addr string) bool {
+	c.peersMutex.RLock()
+	defer c.peersMutex.RUnlock()
+	peer, exists := c.peers[addr]
+	return exists && peer.Protocol != 0
+}
+
+// handleHello records the version, role and features negotiated with src and
+// answers a hello with our own. Hellos of peers that have not proven their
+// membership yet are ignored; they are greeted again once they announce
+// themselves.
+func (c *Client) handleHello(src string, msgType protocol.Type, hello *protocol.Hello) {
+	if !c.isAuthorized(src) {
+		return
+	}
+
+	version, features, err := protocol.Negotiate(hello)
+	if err != nil {
+		c.log.Warn("handshake failed", "peer", src, "err", err)
+		return
+	}
+
+	if msgType == protocol.TypeHello {
+		c.sendHello(src, protocol.TypeHelloAck)
+	}
+
+	c.addDiscoveredPeer(src)
+
+	c.peersMutex.Lock()
+	defer c.peersMutex.Unlock()
+
+	peer, exists := c.peers[src]
+	if !exists {
+		return
+	}
+	first := peer.Protocol == 0
+	peer.Protocol = version
+	peer.Role = hello.Role.String()
+	peer.Capabilities = hello.Capabilities
+	peer.Features = features
+	c.persistPeer(peer)
+
+	if first {
+		c.log.Info("handshake completed", "peer", src, "protocol", version, "role", peer.Role,
+			"features", strings.Join(features, ","))
+	}
+}
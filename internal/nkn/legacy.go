@@ -0,0 +1,47 @@
+package nkn
+
+import (
+	"nghost/internal/protocol"
+)
+
+// handleLegacy accepts the unframed traffic of nodes from before framing:
+// JSON control messages and raw IP packets. Peers that send it are answered
+// in the same format until they send a frame.
+func (c *Client) handleLegacy(src string, data []byte) {
+	switch {
+	case protocol.IsLegacy(data):
+		m, err := protocol.UnmarshalLegacy(data)
+		if err != nil {
+			return
+		}
+		if _, known := c.legacy.LoadOrStore(src, true); !known {
+			c.log.Info("peer speaks the legacy protocol", "peer", src)
+		}
+		c.handleMessage(src, m)
+	case isIPPacket(data):
+		// Only from peers that announced themselves in the legacy format,
+		// anything else is random data
+		if _, ok := c.legacy.Load(src); ok {
+			c.handleVPNPacket(src, data)
+		}
+	}
+}
+
+// isIPPacket reports whether data looks like an IPv4 or IPv6 packet. The
+// version nibbles 4 and 6 never collide with a frame type.
+func isIPPacket(data []byte) bool {
+	return len(data) > 0 && (data[0]>>4 == 4 || data[0]>>4 == 6)
+}
+
+// isLegacy reports whether dest has to be sent the legacy format.
+func (c *Client) isLegacy(dest string) bool {
+	_, ok := c.legacy.Load(dest)
+	return ok
+}
+
+// upgraded forgets that src spoke the legacy protocol once it sends frames.
+func (c *Client) upgraded(src string) {
+	if _, ok := c.legacy.LoadAndDelete(src); ok {
+		c.log.Info("peer upgraded from the legacy protocol", "peer", src)
+	}
+}
@@ -0,0 +1,105 @@
+package nkn
+
+import (
+	"testing"
+	"time"
+
+	"nghost/internal/protocol"
+	"nghost/internal/transport"
+)
+
+// receive returns the next message delivered to endpoint.
+func receive(t *testing.T, endpoint *transport.Loopback) []byte {
+	t.Helper()
+	select {
+	case msg := <-endpoint.Receive():
+		return msg.Data
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+		return nil
+	}
+}
+
+func TestLegacyPeer(t *testing.T) {
+	hub := transport.NewHub()
+	a := newTestClient(t, hub, "a", "secret")
+	old, err := hub.Endpoint("old")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	timestamp := time.Now().Unix()
+	announcement, err := protocol.MarshalLegacy(&protocol.Message{
+		Type: protocol.TypeAnnouncement,
+		Announcement: &protocol.Announcement{
+			IPAddress: "10.100.0.3",
+			Timestamp: timestamp,
+			Proof:     NewAuthorizer(nil, "secret").Proof("old", "a", timestamp),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	old.Send("a", announcement)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !a.isAuthorized("old") {
+		if time.Now().After(deadline) {
+			t.Fatal("legacy announcement not accepted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if peer, ok := a.GetPeers()["old"]; !ok || peer.IPAddress != "10.100.0.3" {
+		t.Fatalf("peer %+v", peer)
+	}
+
+	// Legacy peers get no hello, and pings and packets in the old format
+	if err := a.Ping("old"); err != nil {
+		t.Fatal(err)
+	}
+	m, err := protocol.UnmarshalLegacy(receive(t, old))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Type != protocol.TypePing {
+		t.Fatalf("got %s, want ping", m.Type)
+	}
+	packet := []byte{0x45, 0, 0, 20}
+	if err := a.SendPacket("old", packet); err != nil {
+		t.Fatal(err)
+	}
+	if data := receive(t, old); string(data) != string(packet) {
+		t.Fatalf("got %x, want the raw packet", data)
+	}
+
+	// A frame marks the peer as upgraded
+	old.Send("a", protocol.EncodeFrame(protocol.FrameData, 0, packet))
+	deadline = time.Now().Add(5 * time.Second)
+	for a.isLegacy("old") {
+		if time.Now().After(deadline) {
+			t.Fatal("peer still treated as legacy after sending a frame")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestUnauthorizedHelloIgnored(t *testing.T) {
+	hub := transport.NewHub()
+	a := newTestClient(t, hub, "a", "secret")
+	stranger, err := hub.Endpoint("stranger")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hello := &protocol.Message{Type: protocol.TypeHello, Hello: protocol.NewHello(protocol.RoleClient, nil)}
+	stranger.Send("a", controlFrame(hello))
+
+	select {
+	case msg := <-stranger.Receive():
+		t.Fatalf("unauthorized hello answered with %x", msg.Data)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if _, ok := a.GetPeers()["stranger"]; ok {
+		t.Fatal("unauthorized hello added a peer")
+	}
+}
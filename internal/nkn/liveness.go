@@ -0,0 +1,226 @@
+package nkn
+
+import (
+	"fmt"
+	"time"
+
+	"nghost/internal/config"
+	"nghost/internal/protocol"
+)
+
+// PeerState is the liveness state of a peer. Peers move from online to stale
+// to offline as pings go unanswered and are forgotten once removed.
+type PeerState string
+
+const (
+	PeerOnline  PeerState = "online"
+	PeerStale   PeerState = "stale"
+	PeerOffline PeerState = "offline"
+	PeerRemoved PeerState = "removed"
+)
+
+const (
+	defaultPingInterval = 10 * time.Second
+	defaultStaleAfter   = 30 * time.Second
+	defaultOfflineAfter = 2 * time.Minute
+	defaultRemoveAfter  = 24 * time.Hour
+)
+
+// PeerEvent reports a liveness state transition. Peer is a snapshot taken
+// at the time of the transition.
+type PeerEvent struct {
+	Peer     Peer
+	Previous PeerState
+	State    PeerState
+	Time     time.Time
+}
+
+type livenessTimeouts struct {
+	pingInterval time.Duration
+	staleAfter   time.Duration
+	offlineAfter time.Duration
+	removeAfter  time.Duration
+}
+
+func newLivenessTimeouts(cfg config.LivenessConfig) (livenessTimeouts, error) {
+	seconds := func(v int, def time.Duration) time.Duration {
+		if v <= 0 {
+			return def
+		}
+		return time.Duration(v) * time.Second
+	}
+
+	t := livenessTimeouts{
+		pingInterval: seconds(cfg.PingInterval, defaultPingInterval),
+		staleAfter:   seconds(cfg.StaleAfter, defaultStaleAfter),
+		offlineAfter: seconds(cfg.OfflineAfter, defaultOfflineAfter),
+		removeAfter:  seconds(cfg.RemoveAfter, defaultRemoveAfter),
+	}
+	if t.staleAfter <= t.pingInterval || t.offlineAfter <= t.staleAfter || t.removeAfter <= t.offlineAfter {
+		return t, fmt.Errorf("invalid liveness timeouts: need pingInterval < staleAfter < offlineAfter < removeAfter")
+	}
+	return t, nil
+}
+
+// stateAfter returns the state of a peer that was last heard from silence ago.
+func (t livenessTimeouts) stateAfter(silence time.Duration) PeerState {
+	switch {
+	case silence < t.staleAfter:
+		return PeerOnline
+	case silence < t.offlineAfter:
+		return PeerStale
+	case silence < t.removeAfter:
+		return PeerOffline
+	default:
+		return PeerRemoved
+	}
+}
+
+// OnPeerEvent registers fn to be called on every peer state transition.
+// Handlers run on the goroutine that observed the transition, without
+// client locks held.
+func (c *Client) OnPeerEvent(fn func(PeerEvent)) {
+	c.eventsMutex.Lock()
+	defer c.eventsMutex.Unlock()
+	c.eventHandlers = append(c.eventHandlers, fn)
+}
+
+func (c *Client) emit(events ...PeerEvent) {
+	c.eventsMutex.Lock()
+	handlers := append([]func(PeerEvent){}, c.eventHandlers...)
+	c.eventsMutex.Unlock()
+
+	for _, event := range events {
+		c.log.Info("peer state changed", "peer", event.Peer.Address, "from", event.Previous, "to", event.State)
+		for _, fn := range handlers {
+			fn(event)
+		}
+	}
+}
+
+// setState moves peer to state and returns the resulting event. Callers hold
+// peersMutex and emit the event after releasing it.
+func (c *Client) setState(peer *Peer, state PeerState) (PeerEvent, bool) {
+	previous := peer.State
+	if previous == "" {
+		previous = PeerOffline
+	}
+	peer.State = state
+	peer.Online = state == PeerOnline || state == PeerStale
+	if previous == state {
+		return PeerEvent{}, false
+	}
+	return PeerEvent{Peer: *peer, Previous: previous, State: state, Time: time.Now()}, true
+}
+
+// monitorPeers pings every known peer and expires peers that stop answering.
+func (c *Client) monitorPeers() {
+	ticker := time.NewTicker(c.liveness.pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			c.checkPeers()
+		}
+	}
+}
+
+func (c *Client) checkPeers() {
+	now := time.Now()
+	var events []PeerEvent
+	var targets []string
+
+	c.peersMutex.Lock()
+	for addr, peer := range c.peers {
+		targets = append(targets, addr)
+
+		// Stored peers get a full grace period after startup. Peers we
+		// never heard from, whether discovered, added by hand or stored,
+		// are forgotten after removeAfter.
+		var state PeerState
+		if peer.LastSeen.IsZero() {
+			added := peer.added
+			if added.Before(c.started) {
+				added = c.started
+			}
+			if now.Sub(added) < c.liveness.removeAfter {
+				continue
+			}
+			state = PeerRemoved
+		} else {
+			last := peer.LastSeen
+			if last.Before(c.started) {
+				last = c.started
+			}
+			state = c.liveness.stateAfter(now.Sub(last))
+		}
+		if state == peer.State || (peer.State == PeerOffline && state != PeerRemoved) {
+			// Only answers bring a peer back; offline peers wait for removal
+			continue
+		}
+		if event, changed := c.setState(peer, state); changed {
+			events = append(events, event)
+		}
+		if state == PeerRemoved {
+			delete(c.peers, addr)
+			delete(c.authorized, addr)
+			if !peer.discovered {
+				c.forgetPeer(addr)
+			}
+			peerRTT.Delete(addr)
+			targets = targets[:len(targets)-1]
+			continue
+		}
+		c.persistPeer(peer)
+	}
+	c.peersMutex.Unlock()
+
+	c.emit(events...)
+
+	for _, addr := range targets {
+		if err := c.Ping(addr); err != nil {
+			c.log.Debug("failed to ping", "peer", addr, "err", err)
+		}
+	}
+}
+
+// Goodbye tells every known peer that this node is shutting down.
+func (c *Client) Goodbye() {
+	c.peersMutex.RLock()
+	addrs := make([]string, 0, len(c.peers))
+	for addr := range c.peers {
+		addrs = append(addrs, addr)
+	}
+	c.peersMutex.RUnlock()
+
+	goodbye := &protocol.Message{Type: protocol.TypeGoodbye, Goodbye: &protocol.Goodbye{Reason: "shutdown"}}
+	for _, addr := range addrs {
+		if err := c.send(addr, goodbye); err != nil {
+			c.log.Debug("failed to say goodbye", "peer", addr, "err", err)
+		}
+	}
+}
+
+// handleGoodbye takes a leaving peer offline right away. It comes back
+// online once it answers a ping or announces itself again.
+func (c *Client) handleGoodbye(src string, goodbye *protocol.Goodbye) {
+	c.peersMutex.Lock()
+	peer, exists := c.peers[src]
+	if !exists {
+		c.peersMutex.Unlock()
+		return
+	}
+	event, changed := c.setState(peer, PeerOffline)
+	if changed {
+		c.persistPeer(peer)
+	}
+	c.peersMutex.Unlock()
+
+	c.log.Info("peer is leaving", "peer", src, "reason", goodbye.Reason)
+	if changed {
+		c.emit(event)
+	}
+}
@@ -0,0 +1,74 @@
+package nkn
+
+import (
+	"testing"
+	"time"
+
+	"nghost/internal/protocol"
+	"nghost/internal/transport"
+)
+
+func TestPongMeasuredLocally(t *testing.T) {
+	hub := transport.NewHub()
+	a := newTestClient(t, hub, "a", "")
+	peer, err := hub.Endpoint("peer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.AddPeer("peer")
+
+	pong := func(sent int64) {
+		peer.Send("a", controlFrame(&protocol.Message{Type: protocol.TypePong, Ping: &protocol.Ping{Sent: sent}}))
+	}
+	latency := func() int64 {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			a.peersMutex.RLock()
+			p := a.peers["peer"]
+			lastSeen, latency := p.LastSeen, p.Latency
+			a.peersMutex.RUnlock()
+			if !lastSeen.IsZero() {
+				return latency
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("pong not handled")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	if err := a.Ping("peer"); err != nil {
+		t.Fatal(err)
+	}
+	m, err := protocol.Unmarshal(receivePayload(t, peer))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A pong claiming an early send time does not count
+	pong(time.Now().Add(-time.Hour).UnixNano())
+	if got := latency(); got != 0 {
+		t.Fatalf("forged pong measured %dms", got)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	pong(m.Ping.Sent)
+	deadline := time.Now().Add(5 * time.Second)
+	for latency() < 50 {
+		if time.Now().After(deadline) {
+			t.Fatalf("latency %dms, want at least 50ms", latency())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// receivePayload returns the control message of the next frame delivered to
+// endpoint.
+func receivePayload(t *testing.T, endpoint *transport.Loopback) []byte {
+	t.Helper()
+	frame, err := protocol.DecodeFrame(receive(t, endpoint))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return frame.Payload
+}
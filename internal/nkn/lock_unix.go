@@ -0,0 +1,36 @@
+//go:build linux || darwin
+
+package nkn
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes an advisory flock on path, shared or exclusive, and returns
+// a function releasing it.
+func lockFile(path string, exclusive bool) (func(), error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil && !exclusive {
+		// Readers without write access to a root-owned store can still
+		// take a shared lock on a read-only descriptor
+		f, err = os.Open(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	if err := syscall.Flock(int(f.Fd()), how); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
@@ -0,0 +1,8 @@
+//go:build windows
+
+package nkn
+
+// lockFile is a no-op on Windows until file locking is implemented there.
+func lockFile(path string, exclusive bool) (func(), error) {
+	return func() {}, nil
+}
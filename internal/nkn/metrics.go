@@ -0,0 +1,12 @@
+package nkn
+
+import "nghost/internal/metrics"
+
+var (
+	peerRTT = metrics.NewHistogramVec("nghost_peer_rtt_seconds",
+		"Round trip time of liveness pings to each peer.", metrics.RTTBuckets, "peer")
+	nknConnected = metrics.NewGauge("nghost_nkn_connected",
+		"1 while the NKN client is connected to the network, 0 otherwise.")
+	nknNodesConnected = metrics.NewGauge("nghost_nkn_node_connections",
+		"NKN sub-clients with an open connection to a node.")
+)
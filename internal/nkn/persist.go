@@ -0,0 +1,149 @@
+package nkn
+
+import (
+	"os"
+	"time"
+)
+
+// persistDelay batches peer store writes. Liveness updates touch every peer
+// at once, and each write locks, syncs and replaces the whole file.
+const persistDelay = time.Second
+
+// persistPeer queues a snapshot of peer for the store unless it was only
+// discovered. Callers hold peersMutex; the write happens later, without it.
+func (c *Client) persistPeer(peer *Peer) {
+	if c.store == nil || peer.discovered {
+		return
+	}
+	snapshot := *peer
+	c.queuePeer(peer.Address, &snapshot)
+}
+
+// forgetPeer queues the removal of addr from the store. Callers hold
+// peersMutex.
+func (c *Client) forgetPeer(addr string) {
+	if c.store == nil {
+		return
+	}
+	c.queuePeer(addr, nil)
+}
+
+// queuePeer records the latest state of addr to write, nil to delete it.
+func (c *Client) queuePeer(addr string, peer *Peer) {
+	c.pendingMutex.Lock()
+	c.pending[addr] = peer
+	c.pendingMutex.Unlock()
+
+	select {
+	case c.persist <- struct{}{}:
+	default:
+	}
+}
+
+// writePeers flushes queued peers persistDelay after the first change, and
+// once more when the client closes.
+func (c *Client) writePeers() {
+	for {
+		select {
+		case <-c.ctx.Done():
+			c.flushPeers()
+			return
+		case <-c.persist:
+		}
+
+		select {
+		case <-c.ctx.Done():
+		case <-time.After(persistDelay):
+		}
+		c.flushPeers()
+	}
+}
+
+// flushPeers writes the queued peers to the store in one update.
+func (c *Client) flushPeers() {
+	c.flushMutex.Lock()
+	defer c.flushMutex.Unlock()
+
+	c.pendingMutex.Lock()
+	pending := c.pending
+	c.pending = make(map[string]*Peer)
+	c.pendingMutex.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+
+	err := c.store.Update(func(peers map[string]*Peer) error {
+		for addr, peer := range pending {
+			if peer == nil {
+				delete(peers, addr)
+			} else {
+				peers[addr] = peer
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		c.log.Warn("failed to save peers", "count", len(pending), "err", err)
+		return
+	}
+	c.storeFile = statFile(c.store.Path())
+}
+
+// syncPeers merges peers from the shared store that this client does not know
+// about yet, if the store changed since we last read or wrote it, e.g. by
+// import-peers. Liveness of stored peers is unknown until they announce
+// again.
+func (c *Client) syncPeers() error {
+	if c.store == nil {
+		return nil
+	}
+
+	// Holding flushMutex keeps our own writes out until the merge is done
+	c.flushMutex.Lock()
+	defer c.flushMutex.Unlock()
+
+	current := statFile(c.store.Path())
+	if sameFile(current, c.storeFile) {
+		return nil
+	}
+	stored, err := c.store.Load()
+	if err != nil {
+		return err
+	}
+	c.storeFile = current
+
+	c.peersMutex.Lock()
+	defer c.peersMutex.Unlock()
+	c.pendingMutex.Lock()
+	defer c.pendingMutex.Unlock()
+	for addr, peer := range stored {
+		// Peers we removed may still be stored until the next flush
+		if _, queued := c.pending[addr]; queued {
+			continue
+		}
+		if _, exists := c.peers[addr]; !exists {
+			peer.Online = false
+			peer.State = PeerOffline
+			peer.added = time.Now()
+			c.peers[addr] = peer
+		}
+	}
+	return nil
+}
+
+func statFile(path string) os.FileInfo {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil
+	}
+	return info
+}
+
+// sameFile reports whether a and b describe the same version of a file. The
+// store is replaced on every write, so a new version is a new file.
+func sameFile(a, b os.FileInfo) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return os.SameFile(a, b) && a.ModTime().Equal(b.ModTime()) && a.Size() == b.Size()
+}
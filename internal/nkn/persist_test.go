@@ -0,0 +1,67 @@
+package nkn
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"nghost/internal/transport"
+)
+
+func TestPeerWritesAreBatched(t *testing.T) {
+	hub := transport.NewHub()
+	a := newTestClient(t, hub, "a", "")
+
+	for i := 0; i < 50; i++ {
+		a.AddPeer(fmt.Sprintf("peer-%d", i))
+	}
+	if peers := storedPeers(t, a); len(peers) != 0 {
+		t.Fatalf("%d peers written before the batch delay", len(peers))
+	}
+
+	deadline := time.Now().Add(persistDelay + 5*time.Second)
+	for len(storedPeers(t, a)) != 50 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d of 50 peers written", len(storedPeers(t, a)))
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestSyncPeersOnlyRereadsChangedStore(t *testing.T) {
+	hub := transport.NewHub()
+	a := newTestClient(t, hub, "a", "")
+	a.AddPeer("b")
+	a.flushPeers()
+
+	// Written by import-peers while the daemon runs
+	err := a.store.Update(func(peers map[string]*Peer) error {
+		peers["imported"] = &Peer{Address: "imported"}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := a.GetPeers()["imported"]; ok {
+		t.Fatal("GetPeers read the store")
+	}
+	if err := a.syncPeers(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := a.GetPeers()["imported"]; !ok {
+		t.Fatal("imported peer not picked up")
+	}
+
+	// A peer we removed is not brought back by a stale store
+	a.peersMutex.Lock()
+	delete(a.peers, "b")
+	a.forgetPeer("b")
+	a.peersMutex.Unlock()
+	a.storeFile = nil
+	if err := a.syncPeers(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := a.GetPeers()["b"]; ok {
+		t.Fatal("removed peer restored from the store")
+	}
+}
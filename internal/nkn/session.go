@@ -0,0 +1,237 @@
+package nkn
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/nknorg/nkn-sdk-go"
+)
+
+const (
+	TransportMessage = "message"
+	TransportSession = "session"
+	TransportUDP     = "udp"
+
+	// sessionDialTimeout bounds how long a session dial may take before the
+	// peer is served in message mode.
+	sessionDialTimeout = 10 * time.Second
+	// sessionRetryInterval is how long to wait before dialing a peer again
+	// after a failed dial or a broken session.
+	sessionRetryInterval = 30 * time.Second
+	// maxSessionFrame is the largest frame the two byte length prefix can
+	// carry. Larger frames go out as messages.
+	maxSessionFrame = 0xffff
+)
+
+// sessionTransport carries frames over one multiplexed ncp session per peer.
+// Frames are length prefixed on the stream. While a session is being dialed
+// or after it failed, packets go out as individual NKN messages instead.
+type sessionTransport struct {
+	dial    func(dest string) (io.ReadWriteCloser, error)
+	deliver func(src string, frame []byte)
+	log     *slog.Logger
+	now     func() time.Time
+
+	mu       sync.Mutex
+	sessions map[string]*peerSession
+	dialing  map[string]bool
+	failed   map[string]time.Time
+}
+
+type peerSession struct {
+	remote  string
+	session io.ReadWriteCloser
+	writeMu sync.Mutex
+}
+
+func newSessionTransport(multiClient *nkn.MultiClient, deliver func(string, []byte), log *slog.Logger) (*sessionTransport, error) {
+	if err := multiClient.Listen(nil); err != nil {
+		return nil, fmt.Errorf("failed to listen for sessions: %w", err)
+	}
+
+	t := newSessions(func(dest string) (io.ReadWriteCloser, error) {
+		session, err := multiClient.DialWithConfig(dest, &nkn.DialConfig{
+			DialTimeout: int32(sessionDialTimeout / time.Millisecond),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return session, nil
+	}, deliver, log)
+	go t.acceptSessions(multiClient)
+	return t, nil
+}
+
+// newSessions returns a session transport that opens sessions with dial.
+func newSessions(dial func(string) (io.ReadWriteCloser, error), deliver func(string, []byte), log *slog.Logger) *sessionTransport {
+	return &sessionTransport{
+		dial:     dial,
+		deliver:  deliver,
+		log:      log,
+		now:      time.Now,
+		sessions: make(map[string]*peerSession),
+		dialing:  make(map[string]bool),
+		failed:   make(map[string]time.Time),
+	}
+}
+
+func (t *sessionTransport) acceptSessions(multiClient *nkn.MultiClient) {
+	for {
+		session, err := multiClient.AcceptSession()
+		if err != nil {
+			if !errors.Is(err, nkn.ErrClosed) {
+				t.log.Warn("failed to accept session", "err", err)
+			}
+			return
+		}
+
+		// Frames from peers that are not authorized are dropped by the
+		// client like any other traffic
+		t.add(session.RemoteAddr().String(), session)
+	}
+}
+
+// send writes frame to the session of dest. It returns false if there is no
+// usable session, in which case the caller sends a message instead.
+func (t *sessionTransport) send(dest string, frame []byte) bool {
+	if len(frame) > maxSessionFrame {
+		return false
+	}
+
+	t.mu.Lock()
+	ps := t.sessions[dest]
+	if ps == nil {
+		t.dialLocked(dest)
+	}
+	t.mu.Unlock()
+	if ps == nil {
+		return false
+	}
+
+	if err := ps.write(frame); err != nil {
+		t.log.Warn("session broke, falling back to messages", "peer", dest, "err", err)
+		t.remove(ps, true)
+		return false
+	}
+	return true
+}
+
+// dialLocked starts dialing dest in the background unless a dial is running
+// or recently failed. Callers hold t.mu.
+func (t *sessionTransport) dialLocked(dest string) {
+	if t.dialing[dest] || t.now().Sub(t.failed[dest]) < sessionRetryInterval {
+		return
+	}
+	t.dialing[dest] = true
+
+	go func() {
+		session, err := t.dial(dest)
+
+		t.mu.Lock()
+		delete(t.dialing, dest)
+		if err != nil {
+			t.failed[dest] = t.now()
+		}
+		t.mu.Unlock()
+
+		if err != nil {
+			t.log.Warn("failed to open session, using messages", "peer", dest, "err", err)
+			return
+		}
+		t.add(dest, session)
+	}()
+}
+
+// add registers session as the session to remote and starts reading from
+// it. If both sides dialed at the same time, the first session wins for
+// sending; frames arriving on either are delivered.
+func (t *sessionTransport) add(remote string, session io.ReadWriteCloser) {
+	ps := &peerSession{remote: remote, session: session}
+
+	t.mu.Lock()
+	if _, exists := t.sessions[remote]; !exists {
+		t.sessions[remote] = ps
+		t.log.Info("session established", "peer", remote)
+	}
+	delete(t.failed, remote)
+	t.mu.Unlock()
+
+	go t.read(ps)
+}
+
+func (t *sessionTransport) read(ps *peerSession) {
+	defer t.remove(ps, false)
+
+	r := bufio.NewReader(ps.session)
+	var header [2]byte
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return
+		}
+		size := int(binary.BigEndian.Uint16(header[:]))
+		if size == 0 {
+			return
+		}
+		frame := make([]byte, size)
+		if _, err := io.ReadFull(r, frame); err != nil {
+			return
+		}
+		t.deliver(ps.remote, frame)
+	}
+}
+
+func (t *sessionTransport) remove(ps *peerSession, failed bool) {
+	t.mu.Lock()
+	if t.sessions[ps.remote] == ps {
+		delete(t.sessions, ps.remote)
+	}
+	if failed {
+		t.failed[ps.remote] = t.now()
+	}
+	t.mu.Unlock()
+
+	ps.session.Close()
+}
+
+// active reports whether a session to dest is established.
+func (t *sessionTransport) active(dest string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.sessions[dest] != nil
+}
+
+// close closes all sessions. The multi-client stops accepting on its own
+// when it is closed.
+func (t *sessionTransport) close() {
+	t.mu.Lock()
+	sessions := make([]*peerSession, 0, len(t.sessions))
+	for _, ps := range t.sessions {
+		sessions = append(sessions, ps)
+	}
+	t.mu.Unlock()
+
+	for _, ps := range sessions {
+		ps.session.Close()
+	}
+}
+
+func (ps *peerSession) write(frame []byte) error {
+	if len(frame) > maxSessionFrame {
+		return fmt.Errorf("frame of %d bytes too large for a session", len(frame))
+	}
+
+	buf := make([]byte, 2+len(frame))
+	binary.BigEndian.PutUint16(buf, uint16(len(frame)))
+	copy(buf[2:], frame)
+
+	ps.writeMu.Lock()
+	defer ps.writeMu.Unlock()
+	_, err := ps.session.Write(buf)
+	return err
+}
@@ -0,0 +1,175 @@
+package nkn
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"nghost/internal/logging"
+)
+
+type delivery struct {
+	src   string
+	frame []byte
+}
+
+func newTestSessions(t testing.TB, dial func(string) (io.ReadWriteCloser, error)) (*sessionTransport, chan delivery) {
+	t.Helper()
+	delivered := make(chan delivery, 16)
+	s := newSessions(dial, func(src string, frame []byte) {
+		delivered <- delivery{src, frame}
+	}, logging.Logger(logging.NKN))
+	t.Cleanup(s.close)
+	return s, delivered
+}
+
+func noDial(string) (io.ReadWriteCloser, error) {
+	return nil, errors.New("no dial")
+}
+
+// readFrame reads one length prefixed frame from the remote end of a session.
+func readFrame(t *testing.T, r io.Reader) []byte {
+	t.Helper()
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		t.Error(err)
+		return nil
+	}
+	frame := make([]byte, binary.BigEndian.Uint16(header[:]))
+	if _, err := io.ReadFull(r, frame); err != nil {
+		t.Error(err)
+	}
+	return frame
+}
+
+func TestSessionFraming(t *testing.T) {
+	s, delivered := newTestSessions(t, noDial)
+	local, remote := net.Pipe()
+	defer remote.Close()
+	s.add("peer", local)
+
+	// Frames are split by their length prefix, however they arrive
+	go remote.Write([]byte{0, 3, 'a', 'b', 'c', 0, 2, 'd', 'e'})
+	for _, want := range []string{"abc", "de"} {
+		select {
+		case d := <-delivered:
+			if d.src != "peer" || string(d.frame) != want {
+				t.Fatalf("got %q from %s, want %q from peer", d.frame, d.src, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("frame %q not delivered", want)
+		}
+	}
+
+	read := make(chan []byte, 1)
+	go func() { read <- readFrame(t, remote) }()
+	if !s.send("peer", []byte("hello")) {
+		t.Fatal("send fell back to messages")
+	}
+	if frame := <-read; string(frame) != "hello" {
+		t.Fatalf("sent %q, want hello", frame)
+	}
+}
+
+func TestSessionFrameLimit(t *testing.T) {
+	s, _ := newTestSessions(t, noDial)
+	local, remote := net.Pipe()
+	defer remote.Close()
+	s.add("peer", local)
+
+	largest := bytes.Repeat([]byte{1}, maxSessionFrame)
+	read := make(chan []byte, 1)
+	go func() { read <- readFrame(t, remote) }()
+	if !s.send("peer", largest) {
+		t.Fatal("largest frame fell back to messages")
+	}
+	if frame := <-read; !bytes.Equal(frame, largest) {
+		t.Fatalf("sent %d bytes, want %d", len(frame), len(largest))
+	}
+
+	// A larger frame goes out as a message and leaves the session alone
+	if s.send("peer", append(largest, 1)) {
+		t.Fatal("frame over the limit sent on the session")
+	}
+	if !s.active("peer") {
+		t.Fatal("session closed by a frame over the limit")
+	}
+}
+
+// brokenSession fails every write and blocks reads until it is closed.
+type brokenSession struct {
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (s *brokenSession) Read([]byte) (int, error) {
+	<-s.closed
+	return 0, io.EOF
+}
+
+func (s *brokenSession) Write([]byte) (int, error) {
+	return 0, errors.New("broken")
+}
+
+func (s *brokenSession) Close() error {
+	s.once.Do(func() { close(s.closed) })
+	return nil
+}
+
+func TestSessionFallsBackAfterWriteError(t *testing.T) {
+	var dials atomic.Int32
+	s, _ := newTestSessions(t, func(string) (io.ReadWriteCloser, error) {
+		dials.Add(1)
+		return nil, errors.New("unreachable")
+	})
+	var elapsed atomic.Int64
+	s.now = func() time.Time { return time.Now().Add(time.Duration(elapsed.Load())) }
+
+	s.add("peer", &brokenSession{closed: make(chan struct{})})
+	if s.send("peer", []byte("hello")) {
+		t.Fatal("send on a broken session succeeded")
+	}
+	if s.active("peer") {
+		t.Fatal("broken session still active")
+	}
+
+	// The peer is served by messages without dialing again until the retry
+	// interval passed
+	if s.send("peer", []byte("hello")) || dials.Load() != 0 {
+		t.Fatalf("got %d dials before the retry interval, want 0", dials.Load())
+	}
+	elapsed.Store(int64(sessionRetryInterval))
+	if s.send("peer", []byte("hello")) {
+		t.Fatal("send succeeded without a session")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for dials.Load() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d dials after the retry interval, want 1", dials.Load())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func BenchmarkSessionSend(b *testing.B) {
+	s, _ := newTestSessions(b, noDial)
+	local, remote := net.Pipe()
+	defer remote.Close()
+	s.add("peer", local)
+	go io.Copy(io.Discard, remote)
+
+	frame := make([]byte, 1400)
+	b.SetBytes(int64(len(frame)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if !s.send("peer", frame) {
+			b.Fatal("send fell back to messages")
+		}
+	}
+}
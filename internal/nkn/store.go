@@ -0,0 +1,112 @@
+package nkn
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// PeerStore persists known peers in a JSON file shared between the daemon and
+// CLI commands. Writers hold an exclusive lock and replace the file
+// atomically, so readers never observe a partially written peer list.
+type PeerStore struct {
+	path string
+}
+
+func NewPeerStore(path string) *PeerStore {
+	return &PeerStore{path: path}
+}
+
+func (s *PeerStore) Path() string {
+	return s.path
+}
+
+// Load returns the stored peers. A missing file is an empty peer list.
+func (s *PeerStore) Load() (map[string]*Peer, error) {
+	unlock, err := lockFile(s.path+".lock", false)
+	if os.IsNotExist(err) {
+		return s.read()
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	return s.read()
+}
+
+// Update loads the stored peers, applies fn and writes the result back while
+// holding the store lock.
+func (s *PeerStore) Update(fn func(peers map[string]*Peer) error) error {
+	unlock, err := lockFile(s.path+".lock", true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	peers, err := s.read()
+	if err != nil {
+		return err
+	}
+	if err := fn(peers); err != nil {
+		return err
+	}
+	return s.write(peers)
+}
+
+func (s *PeerStore) read() (map[string]*Peer, error) {
+	peers := make(map[string]*Peer)
+
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return peers, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return peers, nil
+	}
+
+	if err := json.Unmarshal(data, &peers); err != nil {
+		return nil, fmt.Errorf("failed to parse peer store %s: %w", s.path, err)
+	}
+	for addr, peer := range peers {
+		if peer == nil {
+			delete(peers, addr)
+			continue
+		}
+		peer.Address = addr
+	}
+	return peers, nil
+}
+
+func (s *PeerStore) write(peers map[string]*Peer) error {
+	data, err := json.MarshalIndent(peers, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
@@ -0,0 +1,71 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Every NKN message starts with a two byte frame header: the frame type and
+// a flags byte, followed by the payload. Receivers dispatch on the type only,
+// so data and control traffic never get mixed up regardless of how NKN
+// delivered the message.
+const FrameHeaderLen = 2
+
+type FrameType byte
+
+const (
+	// FrameData carries a raw IP packet.
+	FrameData FrameType = 1
+	// FrameControl carries a Message.
+	FrameControl FrameType = 2
+)
+
+// No flags are defined yet. Receivers ignore flags they do not know, so
+// senders may only set flags whose absence is harmless to older nodes.
+
+var (
+	ErrShortFrame   = errors.New("frame too short")
+	ErrUnknownFrame = errors.New("unknown frame type")
+)
+
+// Frame is a decoded frame. Payload aliases the buffer it was decoded from.
+type Frame struct {
+	Type    FrameType
+	Flags   byte
+	Payload []byte
+}
+
+func (t FrameType) String() string {
+	switch t {
+	case FrameData:
+		return "data"
+	case FrameControl:
+		return "control"
+	default:
+		return fmt.Sprintf("frame(%d)", byte(t))
+	}
+}
+
+// EncodeFrame returns payload prefixed with a frame header.
+func EncodeFrame(t FrameType, flags byte, payload []byte) []byte {
+	b := make([]byte, FrameHeaderLen+len(payload))
+	b[0] = byte(t)
+	b[1] = flags
+	copy(b[FrameHeaderLen:], payload)
+	return b
+}
+
+// DecodeFrame splits data into header and payload. Frame types this build
+// does not know fail with ErrUnknownFrame so callers can drop them.
+func DecodeFrame(data []byte) (Frame, error) {
+	if len(data) < FrameHeaderLen {
+		return Frame{}, ErrShortFrame
+	}
+	f := Frame{Type: FrameType(data[0]), Flags: data[1], Payload: data[FrameHeaderLen:]}
+	switch f.Type {
+	case FrameData, FrameControl:
+		return f, nil
+	default:
+		return f, fmt.Errorf("%w: %s", ErrUnknownFrame, f.Type)
+	}
+}
@@ -0,0 +1,139 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Nodes from before this protocol sent unframed JSON control messages and
+// raw IP packets. They are still understood, and answered in kind, so
+// networks can be upgraded node by node. Legacy messages carry no version;
+// Unmarshal and UnmarshalLegacy never confuse the two since JSON objects
+// start with '{', which is not a frame type.
+
+// ErrNoLegacy is returned for messages older nodes have no equivalent of.
+var ErrNoLegacy = errors.New("no legacy equivalent")
+
+var legacyTypes = map[string]Type{
+	"peer_announcement": TypeAnnouncement,
+	"ping":              TypePing,
+	"pong":              TypePong,
+	"lease_request":     TypeLeaseRequest,
+	"lease_grant":       TypeLeaseGrant,
+	"peer_discovery":    TypeDiscovery,
+}
+
+type legacyMessage struct {
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+type legacyAnnouncement struct {
+	IPAddress   string   `json:"ipAddress"`
+	IPv6Address string   `json:"ipv6Address,omitempty"`
+	Routes      []string `json:"routes,omitempty"`
+	ExitNode    bool     `json:"exitNode"`
+	Timestamp   int64    `json:"timestamp,omitempty"`
+	Proof       string   `json:"proof,omitempty"`
+}
+
+type legacyPing struct {
+	Sent int64 `json:"sent"`
+}
+
+type legacyLeaseRequest struct {
+	RequestedIP string `json:"requestedIP"`
+	Timestamp   int64  `json:"timestamp,omitempty"`
+	Proof       string `json:"proof,omitempty"`
+}
+
+type legacyLeaseGrant struct {
+	IPAddress string `json:"ipAddress"`
+}
+
+type legacyDiscovery struct {
+	Timestamp int64  `json:"timestamp"`
+	Proof     string `json:"proof,omitempty"`
+}
+
+// IsLegacy reports whether data is an unframed legacy control message.
+func IsLegacy(data []byte) bool {
+	return len(data) > 0 && data[0] == '{'
+}
+
+// UnmarshalLegacy decodes a legacy JSON control message. The result has a
+// zero Version.
+func UnmarshalLegacy(data []byte) (*Message, error) {
+	var lm legacyMessage
+	if err := json.Unmarshal(data, &lm); err != nil {
+		return nil, fmt.Errorf("invalid legacy message: %w", err)
+	}
+	t, ok := legacyTypes[lm.Type]
+	if !ok {
+		return nil, fmt.Errorf("unknown legacy message type %q", lm.Type)
+	}
+	if len(lm.Payload) == 0 || string(lm.Payload) == "null" {
+		return nil, fmt.Errorf("%s: %w", t, ErrMissingBody)
+	}
+
+	m := &Message{Type: t}
+	var err error
+	switch t {
+	case TypeAnnouncement:
+		var a legacyAnnouncement
+		err = json.Unmarshal(lm.Payload, &a)
+		m.Announcement = (*Announcement)(&a)
+	case TypePing, TypePong:
+		var p legacyPing
+		err = json.Unmarshal(lm.Payload, &p)
+		m.Ping = (*Ping)(&p)
+	case TypeLeaseRequest:
+		var r legacyLeaseRequest
+		err = json.Unmarshal(lm.Payload, &r)
+		m.LeaseRequest = (*LeaseRequest)(&r)
+	case TypeLeaseGrant:
+		var g legacyLeaseGrant
+		err = json.Unmarshal(lm.Payload, &g)
+		m.LeaseGrant = (*LeaseGrant)(&g)
+	case TypeDiscovery:
+		var d legacyDiscovery
+		err = json.Unmarshal(lm.Payload, &d)
+		m.Discovery = (*Discovery)(&d)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid legacy %s: %w", t, err)
+	}
+	return m, nil
+}
+
+// MarshalLegacy encodes m as a legacy JSON control message. Hellos and
+// goodbyes fail with ErrNoLegacy.
+func MarshalLegacy(m *Message) ([]byte, error) {
+	var payload interface{}
+	switch {
+	case m.Type == TypeAnnouncement && m.Announcement != nil:
+		payload = (*legacyAnnouncement)(m.Announcement)
+	case (m.Type == TypePing || m.Type == TypePong) && m.Ping != nil:
+		payload = (*legacyPing)(m.Ping)
+	case m.Type == TypeLeaseRequest && m.LeaseRequest != nil:
+		payload = (*legacyLeaseRequest)(m.LeaseRequest)
+	case m.Type == TypeLeaseGrant && m.LeaseGrant != nil:
+		payload = (*legacyLeaseGrant)(m.LeaseGrant)
+	case m.Type == TypeDiscovery && m.Discovery != nil:
+		payload = (*legacyDiscovery)(m.Discovery)
+	default:
+		return nil, fmt.Errorf("%s: %w", m.Type, ErrNoLegacy)
+	}
+
+	var name string
+	for n, t := range legacyTypes {
+		if t == m.Type {
+			name = n
+		}
+	}
+	return json.Marshal(struct {
+		Type    string      `json:"type"`
+		Payload interface{} `json:"payload"`
+	}{name, payload})
+}
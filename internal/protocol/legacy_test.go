@@ -0,0 +1,80 @@
+package protocol
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestUnmarshalLegacy(t *testing.T) {
+	// As sent by nodes before the protobuf protocol
+	data := []byte(`{"type":"peer_announcement","payload":{"ipAddress":"10.100.0.2","routes":["192.168.1.0/24"],"exitNode":true,"timestamp":1700000000,"proof":"p"}}`)
+	m, err := UnmarshalLegacy(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Message{Type: TypeAnnouncement, Announcement: &Announcement{
+		IPAddress: "10.100.0.2",
+		Routes:    []string{"192.168.1.0/24"},
+		ExitNode:  true,
+		Timestamp: 1700000000,
+		Proof:     "p",
+	}}
+	if !reflect.DeepEqual(m, want) {
+		t.Fatalf("got %+v, want %+v", m, want)
+	}
+
+	for _, data := range []string{
+		`{"type":"hello","payload":{}}`,
+		`{"type":"ping"}`,
+		`{"type":"ping","payload":{"sent":"now"}}`,
+		`{"type":`,
+	} {
+		if _, err := UnmarshalLegacy([]byte(data)); err == nil {
+			t.Errorf("%s accepted", data)
+		}
+	}
+}
+
+func TestLegacyRoundTrip(t *testing.T) {
+	for _, m := range []*Message{
+		{Type: TypeAnnouncement, Announcement: &Announcement{IPAddress: "10.100.0.2", IPv6Address: "fd6e:6768:6f73::2", Timestamp: 1, Proof: "p"}},
+		{Type: TypePing, Ping: &Ping{Sent: 1700000000123456789}},
+		{Type: TypePong, Ping: &Ping{Sent: 1}},
+		{Type: TypeLeaseRequest, LeaseRequest: &LeaseRequest{RequestedIP: "10.100.0.9", Timestamp: 42, Proof: "p"}},
+		{Type: TypeLeaseGrant, LeaseGrant: &LeaseGrant{IPAddress: "10.100.0.9"}},
+		{Type: TypeDiscovery, Discovery: &Discovery{Timestamp: 7}},
+	} {
+		t.Run(m.Type.String(), func(t *testing.T) {
+			data, err := MarshalLegacy(m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !IsLegacy(data) {
+				t.Fatalf("%s not recognized as legacy", data)
+			}
+			got, err := UnmarshalLegacy(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, m) {
+				t.Fatalf("got %+v, want %+v", got, m)
+			}
+		})
+	}
+}
+
+func TestMarshalLegacyUnsupported(t *testing.T) {
+	for _, m := range []*Message{
+		{Type: TypeHello, Hello: NewHello(RoleClient, nil)},
+		{Type: TypeGoodbye, Goodbye: &Goodbye{}},
+		{Type: TypePing},
+	} {
+		if _, err := MarshalLegacy(m); !errors.Is(err, ErrNoLegacy) {
+			t.Errorf("%s: got %v, want ErrNoLegacy", m.Type, err)
+		}
+	}
+	if IsLegacy(EncodeFrame(FrameControl, 0, Marshal(&Message{Type: TypeDiscovery, Discovery: &Discovery{}}))) {
+		t.Error("frame recognized as legacy")
+	}
+}
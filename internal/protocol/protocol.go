@@ -0,0 +1,234 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+const (
+	// Version is the protocol version this build speaks.
+	Version uint32 = 1
+	// MinVersion is the oldest protocol version this build still accepts.
+	MinVersion uint32 = 1
+
+	// Software identifies this implementation in hello messages.
+	Software = "nghost"
+)
+
+var (
+	ErrUnsupportedVersion = errors.New("unsupported protocol version")
+	ErrMissingBody        = errors.New("message body missing")
+)
+
+type Type uint32
+
+const (
+	TypeHello        Type = 1
+	TypeHelloAck     Type = 2
+	TypeAnnouncement Type = 3
+	TypePing         Type = 4
+	TypePong         Type = 5
+	TypeLeaseRequest Type = 6
+	TypeLeaseGrant   Type = 7
+	TypeDiscovery    Type = 8
+	TypeGoodbye      Type = 9
+)
+
+var typeNames = map[Type]string{
+	TypeHello:        "hello",
+	TypeHelloAck:     "hello_ack",
+	TypeAnnouncement: "announcement",
+	TypePing:         "ping",
+	TypePong:         "pong",
+	TypeLeaseRequest: "lease_request",
+	TypeLeaseGrant:   "lease_grant",
+	TypeDiscovery:    "discovery",
+	TypeGoodbye:      "goodbye",
+}
+
+func (t Type) String() string {
+	if name, ok := typeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("type(%d)", uint32(t))
+}
+
+type Role uint32
+
+const (
+	RoleClient Role = 1
+	RoleExit   Role = 2
+)
+
+func (r Role) String() string {
+	switch r {
+	case RoleClient:
+		return "client"
+	case RoleExit:
+		return "exit"
+	default:
+		return "unknown"
+	}
+}
+
+// Capabilities describe what a node offers to the network.
+const (
+	CapabilityExit         = "exit"
+	CapabilitySubnetRouter = "subnet-router"
+	CapabilityIPv6         = "ipv6"
+)
+
+// Features are optional protocol behaviours. The handshake records the
+// features both sides have in common on the peer.
+const (
+	FeatureLatency   = "latency"
+	FeatureLeases    = "leases"
+	FeatureDiscovery = "discovery"
+)
+
+// Features lists the features this build supports.
+var Features = []string{FeatureLatency, FeatureLeases, FeatureDiscovery}
+
+// Message is a control message. Exactly one body matching Type is set.
+type Message struct {
+	Version      uint32
+	Type         Type
+	Hello        *Hello
+	Announcement *Announcement
+	Ping         *Ping
+	LeaseRequest *LeaseRequest
+	LeaseGrant   *LeaseGrant
+	Discovery    *Discovery
+	Goodbye      *Goodbye
+}
+
+// Hello opens the handshake between two nodes and is answered with a
+// HelloAck carrying the other node's Hello.
+type Hello struct {
+	Version      uint32
+	MinVersion   uint32
+	Role         Role
+	Capabilities []string
+	Features     []string
+	Software     string
+}
+
+type Announcement struct {
+	IPAddress   string
+	IPv6Address string
+	Routes      []string
+	ExitNode    bool
+	Timestamp   int64
+	Proof       string
+}
+
+// Ping carries a random nonce, echoed back unchanged in the pong. Older nodes
+// sent their clock instead; either way only the sender interprets it.
+type Ping struct {
+	Sent int64
+}
+
+// LeaseRequest asks the lease coordinator for a VPN address. It carries the
+// same membership proof as an announcement.
+type LeaseRequest struct {
+	RequestedIP string
+	Timestamp   int64
+	Proof       string
+}
+
+type LeaseGrant struct {
+	IPAddress string
+}
+
+// Discovery is published on the network topic so members learn about new
+// nodes without exchanging addresses by hand. It carries the same membership
+// proof as an announcement.
+type Discovery struct {
+	Timestamp int64
+	Proof     string
+}
+
+// Goodbye tells peers that the sender is shutting down, so they can drop
+// its routes without waiting for pings to time out.
+type Goodbye struct {
+	Reason string
+}
+
+// NewHello returns the hello of this build for a node with role and
+// capabilities.
+func NewHello(role Role, capabilities []string) *Hello {
+	return &Hello{
+		Version:      Version,
+		MinVersion:   MinVersion,
+		Role:         role,
+		Capabilities: capabilities,
+		Features:     Features,
+		Software:     Software,
+	}
+}
+
+// Negotiate returns the protocol version and features to use with a node
+// that sent remote, or ErrUnsupportedVersion if the version ranges do not
+// overlap.
+func Negotiate(remote *Hello) (uint32, []string, error) {
+	version := Version
+	if remote.Version < version {
+		version = remote.Version
+	}
+	if version < MinVersion || version < remote.MinVersion {
+		return 0, nil, fmt.Errorf("%w: peer speaks %d-%d, we speak %d-%d",
+			ErrUnsupportedVersion, remote.MinVersion, remote.Version, MinVersion, Version)
+	}
+
+	local := make(map[string]bool, len(Features))
+	for _, f := range Features {
+		local[f] = true
+	}
+	var common []string
+	for _, f := range remote.Features {
+		if local[f] {
+			common = append(common, f)
+		}
+	}
+	sort.Strings(common)
+	return version, common, nil
+}
+
+// body returns the body matching m.Type, or nil if it is missing.
+func (m *Message) body() interface{} {
+	switch m.Type {
+	case TypeHello, TypeHelloAck:
+		if m.Hello != nil {
+			return m.Hello
+		}
+	case TypeAnnouncement:
+		if m.Announcement != nil {
+			return m.Announcement
+		}
+	case TypePing, TypePong:
+		if m.Ping != nil {
+			return m.Ping
+		}
+	case TypeLeaseRequest:
+		if m.LeaseRequest != nil {
+			return m.LeaseRequest
+		}
+	case TypeLeaseGrant:
+		if m.LeaseGrant != nil {
+			return m.LeaseGrant
+		}
+	case TypeDiscovery:
+		if m.Discovery != nil {
+			return m.Discovery
+		}
+	case TypeGoodbye:
+		if m.Goodbye != nil {
+			return m.Goodbye
+		}
+	default:
+		// Unknown types from newer peers carry bodies we cannot read
+		return struct{}{}
+	}
+	return nil
+}
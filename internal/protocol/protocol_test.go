@@ -0,0 +1,145 @@
+package protocol
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestMarshalRoundTrip(t *testing.T) {
+	messages := []*Message{
+		{Type: TypeHello, Hello: NewHello(RoleExit, []string{CapabilityExit, CapabilityIPv6})},
+		{Type: TypeHelloAck, Hello: NewHello(RoleClient, nil)},
+		{Type: TypeAnnouncement, Announcement: &Announcement{
+			IPAddress:   "10.100.0.2",
+			IPv6Address: "fd6e:6768:6f73::2",
+			Routes:      []string{"192.168.1.0/24", "192.168.2.0/24"},
+			ExitNode:    true,
+			Timestamp:   1700000000,
+			Proof:       "c2lnbmF0dXJl",
+		}},
+		{Type: TypePing, Ping: &Ping{Sent: 1700000000123456789}},
+		{Type: TypePong, Ping: &Ping{Sent: -1}},
+		{Type: TypeLeaseRequest, LeaseRequest: &LeaseRequest{RequestedIP: "10.100.0.9", Timestamp: 42, Proof: "p"}},
+		{Type: TypeLeaseGrant, LeaseGrant: &LeaseGrant{IPAddress: "10.100.0.9"}},
+		{Type: TypeDiscovery, Discovery: &Discovery{Timestamp: 7}},
+		{Type: TypeGoodbye, Goodbye: &Goodbye{Reason: "shutdown"}},
+		{Type: TypeGoodbye, Goodbye: &Goodbye{}},
+	}
+	for _, m := range messages {
+		t.Run(m.Type.String(), func(t *testing.T) {
+			got, err := Unmarshal(Marshal(m))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := *m
+			want.Version = Version
+			if !reflect.DeepEqual(got, &want) {
+				t.Fatalf("got %+v, want %+v", got, &want)
+			}
+		})
+	}
+}
+
+func TestUnmarshalSkipsUnknownFields(t *testing.T) {
+	data := Marshal(&Message{Type: TypeLeaseGrant, LeaseGrant: &LeaseGrant{IPAddress: "10.100.0.9"}})
+	// Fields a newer peer might add, in every wire type
+	data = protowire.AppendTag(data, 99, protowire.VarintType)
+	data = protowire.AppendVarint(data, 1)
+	data = protowire.AppendTag(data, 100, protowire.BytesType)
+	data = protowire.AppendString(data, "future")
+	data = protowire.AppendTag(data, 101, protowire.Fixed32Type)
+	data = protowire.AppendFixed32(data, 1)
+	data = protowire.AppendTag(data, 102, protowire.Fixed64Type)
+	data = protowire.AppendFixed64(data, 1)
+
+	m, err := Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.LeaseGrant == nil || m.LeaseGrant.IPAddress != "10.100.0.9" {
+		t.Fatalf("lease grant lost: %+v", m)
+	}
+}
+
+func TestUnmarshalUnknownType(t *testing.T) {
+	m, err := Unmarshal(Marshal(&Message{Type: Type(200)}))
+	if err != nil {
+		t.Fatalf("unknown type rejected: %v", err)
+	}
+	if m.Type.String() != "type(200)" {
+		t.Fatalf("type %s", m.Type)
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"missing body", Marshal(&Message{Type: TypeAnnouncement}), ErrMissingBody},
+		{"wrong body", Marshal(&Message{Type: TypePing, Goodbye: &Goodbye{}}), ErrMissingBody},
+		{"old version", protowire.AppendVarint(protowire.AppendTag(nil, 2, protowire.VarintType), uint64(TypeGoodbye)), ErrUnsupportedVersion},
+		{"truncated", Marshal(&Message{Type: TypeGoodbye, Goodbye: &Goodbye{Reason: "bye"}})[:7], nil},
+		{"garbage", []byte{0xff, 0xff, 0xff}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Unmarshal(tt.data)
+			if err == nil {
+				t.Fatal("no error")
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	remote := &Hello{Version: Version + 1, MinVersion: MinVersion, Features: []string{FeatureLeases, "future", FeatureLatency}}
+	version, features, err := Negotiate(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != Version {
+		t.Fatalf("version %d, want %d", version, Version)
+	}
+	if want := []string{FeatureLatency, FeatureLeases}; !reflect.DeepEqual(features, want) {
+		t.Fatalf("features %v, want %v", features, want)
+	}
+
+	if _, _, err := Negotiate(&Hello{Version: Version + 2, MinVersion: Version + 1}); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Fatalf("got %v, want ErrUnsupportedVersion", err)
+	}
+}
+
+func TestDecodeFrame(t *testing.T) {
+	payload := []byte{0x45, 0x00, 0x00, 0x14}
+	f, err := DecodeFrame(EncodeFrame(FrameData, 0x80, payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Type != FrameData || f.Flags != 0x80 || !reflect.DeepEqual(f.Payload, payload) {
+		t.Fatalf("got %+v", f)
+	}
+
+	f, err = DecodeFrame(EncodeFrame(FrameControl, 0, nil))
+	if err != nil || f.Type != FrameControl || len(f.Payload) != 0 {
+		t.Fatalf("empty control frame: %+v, %v", f, err)
+	}
+
+	for _, data := range [][]byte{nil, {byte(FrameData)}} {
+		if _, err := DecodeFrame(data); !errors.Is(err, ErrShortFrame) {
+			t.Errorf("DecodeFrame(%v): got %v, want ErrShortFrame", data, err)
+		}
+	}
+	for _, typ := range []byte{0, 3, 0xff} {
+		if _, err := DecodeFrame([]byte{typ, 0, 1}); !errors.Is(err, ErrUnknownFrame) {
+			t.Errorf("frame type %d: got %v, want ErrUnknownFrame", typ, err)
+		}
+	}
+}
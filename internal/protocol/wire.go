@@ -0,0 +1,321 @@
+package protocol
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Marshal encodes m in the protobuf wire format described in protocol.proto.
+// A zero Version is sent as the current Version.
+func Marshal(m *Message) []byte {
+	version := m.Version
+	if version == 0 {
+		version = Version
+	}
+
+	var b []byte
+	b = appendVarint(b, 1, uint64(version))
+	b = appendVarint(b, 2, uint64(m.Type))
+	if m.Hello != nil {
+		b = appendMessage(b, 10, m.Hello.marshal())
+	}
+	if m.Announcement != nil {
+		b = appendMessage(b, 11, m.Announcement.marshal())
+	}
+	if m.Ping != nil {
+		b = appendMessage(b, 12, m.Ping.marshal())
+	}
+	if m.LeaseRequest != nil {
+		b = appendMessage(b, 13, m.LeaseRequest.marshal())
+	}
+	if m.LeaseGrant != nil {
+		b = appendMessage(b, 14, m.LeaseGrant.marshal())
+	}
+	if m.Discovery != nil {
+		b = appendMessage(b, 15, m.Discovery.marshal())
+	}
+	if m.Goodbye != nil {
+		b = appendMessage(b, 16, m.Goodbye.marshal())
+	}
+	return b
+}
+
+// Unmarshal decodes a message. Unknown fields are skipped so newer peers can
+// add fields without breaking us. Messages older than MinVersion fail with
+// ErrUnsupportedVersion.
+func Unmarshal(data []byte) (*Message, error) {
+	m := &Message{}
+	err := consumeFields(data, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		var err error
+		switch {
+		case num == 1:
+			err = consumeVarint(typ, b, func(v uint64) { m.Version = uint32(v) })
+		case num == 2:
+			err = consumeVarint(typ, b, func(v uint64) { m.Type = Type(v) })
+		case num == 10 && typ == protowire.BytesType:
+			m.Hello = &Hello{}
+			err = consumeMessage(b, m.Hello.unmarshal)
+		case num == 11 && typ == protowire.BytesType:
+			m.Announcement = &Announcement{}
+			err = consumeMessage(b, m.Announcement.unmarshal)
+		case num == 12 && typ == protowire.BytesType:
+			m.Ping = &Ping{}
+			err = consumeMessage(b, m.Ping.unmarshal)
+		case num == 13 && typ == protowire.BytesType:
+			m.LeaseRequest = &LeaseRequest{}
+			err = consumeMessage(b, m.LeaseRequest.unmarshal)
+		case num == 14 && typ == protowire.BytesType:
+			m.LeaseGrant = &LeaseGrant{}
+			err = consumeMessage(b, m.LeaseGrant.unmarshal)
+		case num == 15 && typ == protowire.BytesType:
+			m.Discovery = &Discovery{}
+			err = consumeMessage(b, m.Discovery.unmarshal)
+		case num == 16 && typ == protowire.BytesType:
+			m.Goodbye = &Goodbye{}
+			err = consumeMessage(b, m.Goodbye.unmarshal)
+		default:
+			return fieldLen(num, typ, b)
+		}
+		if err != nil {
+			return 0, err
+		}
+		return fieldLen(num, typ, b)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid message: %w", err)
+	}
+
+	if m.Version < MinVersion {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, m.Version)
+	}
+	if m.body() == nil {
+		return nil, fmt.Errorf("%s: %w", m.Type, ErrMissingBody)
+	}
+	return m, nil
+}
+
+func (h *Hello) marshal() []byte {
+	var b []byte
+	b = appendVarint(b, 1, uint64(h.Version))
+	b = appendVarint(b, 2, uint64(h.MinVersion))
+	b = appendVarint(b, 3, uint64(h.Role))
+	b = appendStrings(b, 4, h.Capabilities)
+	b = appendStrings(b, 5, h.Features)
+	b = appendString(b, 6, h.Software)
+	return b
+}
+
+func (h *Hello) unmarshal(num protowire.Number, typ protowire.Type, b []byte) error {
+	switch num {
+	case 1:
+		return consumeVarint(typ, b, func(v uint64) { h.Version = uint32(v) })
+	case 2:
+		return consumeVarint(typ, b, func(v uint64) { h.MinVersion = uint32(v) })
+	case 3:
+		return consumeVarint(typ, b, func(v uint64) { h.Role = Role(v) })
+	case 4:
+		return consumeString(typ, b, func(s string) { h.Capabilities = append(h.Capabilities, s) })
+	case 5:
+		return consumeString(typ, b, func(s string) { h.Features = append(h.Features, s) })
+	case 6:
+		return consumeString(typ, b, func(s string) { h.Software = s })
+	}
+	return nil
+}
+
+func (a *Announcement) marshal() []byte {
+	var b []byte
+	b = appendString(b, 1, a.IPAddress)
+	b = appendString(b, 2, a.IPv6Address)
+	b = appendStrings(b, 3, a.Routes)
+	b = appendVarint(b, 4, protowire.EncodeBool(a.ExitNode))
+	b = appendVarint(b, 5, uint64(a.Timestamp))
+	b = appendString(b, 6, a.Proof)
+	return b
+}
+
+func (a *Announcement) unmarshal(num protowire.Number, typ protowire.Type, b []byte) error {
+	switch num {
+	case 1:
+		return consumeString(typ, b, func(s string) { a.IPAddress = s })
+	case 2:
+		return consumeString(typ, b, func(s string) { a.IPv6Address = s })
+	case 3:
+		return consumeString(typ, b, func(s string) { a.Routes = append(a.Routes, s) })
+	case 4:
+		return consumeVarint(typ, b, func(v uint64) { a.ExitNode = protowire.DecodeBool(v) })
+	case 5:
+		return consumeVarint(typ, b, func(v uint64) { a.Timestamp = int64(v) })
+	case 6:
+		return consumeString(typ, b, func(s string) { a.Proof = s })
+	}
+	return nil
+}
+
+func (p *Ping) marshal() []byte {
+	return appendVarint(nil, 1, uint64(p.Sent))
+}
+
+func (p *Ping) unmarshal(num protowire.Number, typ protowire.Type, b []byte) error {
+	if num == 1 {
+		return consumeVarint(typ, b, func(v uint64) { p.Sent = int64(v) })
+	}
+	return nil
+}
+
+func (r *LeaseRequest) marshal() []byte {
+	var b []byte
+	b = appendString(b, 1, r.RequestedIP)
+	b = appendVarint(b, 2, uint64(r.Timestamp))
+	b = appendString(b, 3, r.Proof)
+	return b
+}
+
+func (r *LeaseRequest) unmarshal(num protowire.Number, typ protowire.Type, b []byte) error {
+	switch num {
+	case 1:
+		return consumeString(typ, b, func(s string) { r.RequestedIP = s })
+	case 2:
+		return consumeVarint(typ, b, func(v uint64) { r.Timestamp = int64(v) })
+	case 3:
+		return consumeString(typ, b, func(s string) { r.Proof = s })
+	}
+	return nil
+}
+
+func (g *LeaseGrant) marshal() []byte {
+	return appendString(nil, 1, g.IPAddress)
+}
+
+func (g *LeaseGrant) unmarshal(num protowire.Number, typ protowire.Type, b []byte) error {
+	if num == 1 {
+		return consumeString(typ, b, func(s string) { g.IPAddress = s })
+	}
+	return nil
+}
+
+func (d *Discovery) marshal() []byte {
+	var b []byte
+	b = appendVarint(b, 1, uint64(d.Timestamp))
+	b = appendString(b, 2, d.Proof)
+	return b
+}
+
+func (d *Discovery) unmarshal(num protowire.Number, typ protowire.Type, b []byte) error {
+	switch num {
+	case 1:
+		return consumeVarint(typ, b, func(v uint64) { d.Timestamp = int64(v) })
+	case 2:
+		return consumeString(typ, b, func(s string) { d.Proof = s })
+	}
+	return nil
+}
+
+func (g *Goodbye) marshal() []byte {
+	return appendString(nil, 1, g.Reason)
+}
+
+func (g *Goodbye) unmarshal(num protowire.Number, typ protowire.Type, b []byte) error {
+	if num == 1 {
+		return consumeString(typ, b, func(s string) { g.Reason = s })
+	}
+	return nil
+}
+
+// appendVarint and appendString omit zero values like proto3 does.
+func appendVarint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func appendStrings(b []byte, num protowire.Number, ss []string) []byte {
+	for _, s := range ss {
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		b = protowire.AppendString(b, s)
+	}
+	return b
+}
+
+func appendMessage(b []byte, num protowire.Number, body []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, body)
+}
+
+// consumeFields calls fn for every field in b. fn returns the length of the
+// field value it consumed.
+func consumeFields(b []byte, fn func(num protowire.Number, typ protowire.Type, b []byte) (int, error)) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		n, err := fn(num, typ, b)
+		if err != nil {
+			return err
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// consumeMessage decodes the embedded message at the start of b, calling fn
+// for every field of it.
+func consumeMessage(b []byte, fn func(num protowire.Number, typ protowire.Type, b []byte) error) error {
+	body, n := protowire.ConsumeBytes(b)
+	if n < 0 {
+		return protowire.ParseError(n)
+	}
+	return consumeFields(body, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		if err := fn(num, typ, b); err != nil {
+			return 0, err
+		}
+		return fieldLen(num, typ, b)
+	})
+}
+
+// consumeVarint and consumeString pass the field value at the start of b to
+// set. Fields of an unexpected wire type are ignored.
+func consumeVarint(typ protowire.Type, b []byte, set func(uint64)) error {
+	if typ != protowire.VarintType {
+		return nil
+	}
+	v, n := protowire.ConsumeVarint(b)
+	if n < 0 {
+		return protowire.ParseError(n)
+	}
+	set(v)
+	return nil
+}
+
+func consumeString(typ protowire.Type, b []byte, set func(string)) error {
+	if typ != protowire.BytesType {
+		return nil
+	}
+	s, n := protowire.ConsumeString(b)
+	if n < 0 {
+		return protowire.ParseError(n)
+	}
+	set(s)
+	return nil
+}
+
+func fieldLen(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+	n := protowire.ConsumeFieldValue(num, typ, b)
+	if n < 0 {
+		return 0, protowire.ParseError(n)
+	}
+	return n, nil
+}
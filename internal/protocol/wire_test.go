@@ -0,0 +1,243 @@
+package protocol
+
+import (
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The codec in wire.go is written by hand. These tests tie it to
+// protocol.proto: every field declared there must be encoded under its
+// number and wire type, and decode back into the same Go field.
+
+type protoField struct {
+	name     string
+	typ      string
+	num      protowire.Number
+	repeated bool
+}
+
+var (
+	protoMessageRe = regexp.MustCompile(`(?s)message (\w+) \{(.*?)\n\}`)
+	protoFieldRe   = regexp.MustCompile(`(?m)^\s*(repeated )?(\w+) (\w+) = (\d+);`)
+)
+
+func parseProto(t *testing.T) map[string][]protoField {
+	t.Helper()
+	data, err := os.ReadFile("protocol.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := map[string][]protoField{}
+	for _, m := range protoMessageRe.FindAllStringSubmatch(string(data), -1) {
+		var fields []protoField
+		for _, f := range protoFieldRe.FindAllStringSubmatch(m[2], -1) {
+			num, _ := strconv.Atoi(f[4])
+			fields = append(fields, protoField{name: f[3], typ: f[2], num: protowire.Number(num), repeated: f[1] != ""})
+		}
+		messages[m[1]] = fields
+	}
+	if len(messages) == 0 {
+		t.Fatal("no messages found in protocol.proto")
+	}
+	return messages
+}
+
+// goName maps a proto field name to the Go field name, e.g. ipv6_address
+// to IPv6Address.
+func goName(name string) string {
+	var b strings.Builder
+	for _, part := range strings.Split(name, "_") {
+		switch part {
+		case "ip":
+			b.WriteString("IP")
+		case "ipv6":
+			b.WriteString("IPv6")
+		default:
+			b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+		}
+	}
+	return b.String()
+}
+
+func wireType(messages map[string][]protoField, typ string) protowire.Type {
+	if _, ok := messages[typ]; ok || typ == "string" {
+		return protowire.BytesType
+	}
+	return protowire.VarintType // integers, bools and enums
+}
+
+type body interface {
+	marshal() []byte
+	unmarshal(num protowire.Number, typ protowire.Type, b []byte) error
+}
+
+var bodies = map[string]body{
+	"Hello":        &Hello{},
+	"Announcement": &Announcement{},
+	"Ping":         &Ping{},
+	"LeaseRequest": &LeaseRequest{},
+	"LeaseGrant":   &LeaseGrant{},
+	"Discovery":    &Discovery{},
+	"Goodbye":      &Goodbye{},
+}
+
+// setNonZero sets v to a value that is encoded.
+func setNonZero(t *testing.T, v reflect.Value) {
+	t.Helper()
+	switch v.Kind() {
+	case reflect.Uint32:
+		v.SetUint(99)
+	case reflect.Int64:
+		v.SetInt(-99)
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.String:
+		v.SetString("x")
+	case reflect.Slice:
+		v.Set(reflect.ValueOf([]string{"x", "y"}))
+	case reflect.Pointer:
+		v.Set(reflect.New(v.Type().Elem()))
+		for i := 0; i < v.Elem().NumField(); i++ {
+			setNonZero(t, v.Elem().Field(i))
+		}
+	default:
+		t.Fatalf("unsupported kind %s", v.Kind())
+	}
+}
+
+// fieldsOf returns the field numbers and wire types in data.
+func fieldsOf(t *testing.T, data []byte) map[protowire.Number]protowire.Type {
+	t.Helper()
+	fields := map[protowire.Number]protowire.Type{}
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeField(data)
+		if n < 0 {
+			t.Fatal(protowire.ParseError(n))
+		}
+		fields[num] = typ
+		data = data[n:]
+	}
+	return fields
+}
+
+func TestBodiesMatchProto(t *testing.T) {
+	messages := parseProto(t)
+	for name, fields := range messages {
+		if name == "Message" {
+			continue
+		}
+		t.Run(name, func(t *testing.T) {
+			proto, ok := bodies[name]
+			if !ok {
+				t.Fatalf("no Go type for message %s", name)
+			}
+			typ := reflect.TypeOf(proto).Elem()
+			if typ.NumField() != len(fields) {
+				t.Fatalf("Go type has %d fields, protocol.proto %d", typ.NumField(), len(fields))
+			}
+
+			for _, f := range fields {
+				v := reflect.New(typ)
+				field := v.Elem().FieldByName(goName(f.name))
+				if !field.IsValid() {
+					t.Fatalf("no Go field for %s", f.name)
+				}
+				if (field.Kind() == reflect.Slice) != f.repeated {
+					t.Fatalf("%s: repeated mismatch", f.name)
+				}
+				setNonZero(t, field)
+
+				data := v.Interface().(body).marshal()
+				got := fieldsOf(t, data)
+				if len(got) != 1 || got[f.num] != wireType(messages, f.typ) {
+					t.Fatalf("%s encoded as %v, want field %d of wire type %d", f.name, got, f.num, wireType(messages, f.typ))
+				}
+
+				decoded := reflect.New(typ)
+				if err := consumeMessage(protowire.AppendBytes(nil, data), decoded.Interface().(body).unmarshal); err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(decoded.Interface(), v.Interface()) {
+					t.Fatalf("%s: decoded %+v, want %+v", f.name, decoded.Interface(), v.Interface())
+				}
+			}
+		})
+	}
+}
+
+func TestMessageMatchesProto(t *testing.T) {
+	messages := parseProto(t)
+	fields := messages["Message"]
+	typ := reflect.TypeOf(Message{})
+	if typ.NumField() != len(fields) {
+		t.Fatalf("Message has %d fields, protocol.proto %d", typ.NumField(), len(fields))
+	}
+
+	for _, f := range fields {
+		m := &Message{}
+		field := reflect.ValueOf(m).Elem().FieldByName(goName(f.name))
+		if !field.IsValid() {
+			t.Fatalf("no Go field for %s", f.name)
+		}
+		if f.typ != "Type" && f.typ != "uint32" {
+			if _, ok := bodies[f.typ]; !ok || field.Type() != reflect.TypeOf(bodies[f.typ]) {
+				t.Fatalf("%s: Go type %s, protocol.proto %s", f.name, field.Type(), f.typ)
+			}
+		}
+		setNonZero(t, field)
+
+		// The version is always sent
+		got := fieldsOf(t, Marshal(m))
+		if got[f.num] != wireType(messages, f.typ) {
+			t.Fatalf("%s encoded as %v, want field %d of wire type %d", f.name, got, f.num, wireType(messages, f.typ))
+		}
+		for num := range got {
+			if num != f.num && num != 1 {
+				t.Fatalf("%s encoded as %v, want only field %d", f.name, got, f.num)
+			}
+		}
+
+		decoded, err := Unmarshal(Marshal(m))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Version == 0 {
+			m.Version = Version
+		}
+		if !reflect.DeepEqual(decoded, m) {
+			t.Fatalf("%s: decoded %+v, want %+v", f.name, decoded, m)
+		}
+	}
+}
+
+func FuzzUnmarshal(f *testing.F) {
+	for _, m := range []*Message{
+		{Type: TypeHello, Hello: NewHello(RoleExit, []string{CapabilityExit})},
+		{Type: TypeAnnouncement, Announcement: &Announcement{IPAddress: "10.100.0.2", Routes: []string{"192.168.1.0/24"}, ExitNode: true, Timestamp: 1, Proof: "p"}},
+		{Type: TypePong, Ping: &Ping{Sent: -1}},
+		{Type: TypeLeaseRequest, LeaseRequest: &LeaseRequest{RequestedIP: "10.100.0.9", Timestamp: 42, Proof: "p"}},
+		{Type: TypeGoodbye, Goodbye: &Goodbye{Reason: "shutdown"}},
+	} {
+		f.Add(Marshal(m))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		m, err := Unmarshal(data)
+		if err != nil {
+			return
+		}
+		again, err := Unmarshal(Marshal(m))
+		if err != nil {
+			t.Fatalf("re-encoded %+v does not decode: %v", m, err)
+		}
+		if !reflect.DeepEqual(again, m) {
+			t.Fatalf("round trip changed %+v to %+v", m, again)
+		}
+	})
+}
@@ -0,0 +1,147 @@
+package simnet
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var ErrDeviceClosed = errors.New("simulated device closed")
+
+// Device is a programmable TUN endpoint. Packets passed to Send are read by
+// the engine as if the host had routed them into the VPN; packets the engine
+// writes can be awaited with Expect.
+type Device struct {
+	name string
+
+	mu       sync.Mutex
+	address  string
+	address6 string
+	outbound chan []byte
+	inbound  [][]byte
+	arrived  chan struct{}
+	closed   bool
+	done     chan struct{}
+}
+
+func newDevice(name, address string) *Device {
+	return &Device{
+		name:     name,
+		address:  address,
+		outbound: make(chan []byte, 256),
+		arrived:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Send hands packet to the engine as outbound traffic of the host.
+func (d *Device) Send(packet []byte) error {
+	select {
+	case d.outbound <- append([]byte(nil), packet...):
+		return nil
+	case <-d.done:
+		return ErrDeviceClosed
+	}
+}
+
+// Expect waits until the engine wrote a packet matching match and removes
+// it. Earlier packets that do not match are kept.
+func (d *Device) Expect(match func([]byte) bool, timeout time.Duration) ([]byte, error) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for {
+		d.mu.Lock()
+		for i, packet := range d.inbound {
+			if match(packet) {
+				d.inbound = append(d.inbound[:i], d.inbound[i+1:]...)
+				d.mu.Unlock()
+				return packet, nil
+			}
+		}
+		arrived := d.arrived
+		d.mu.Unlock()
+
+		select {
+		case <-arrived:
+		case <-deadline.C:
+			return nil, fmt.Errorf("%s: no matching packet within %v", d.name, timeout)
+		}
+	}
+}
+
+// Drain removes and returns the packets matching match that the engine
+// wrote so far, in the order they were written.
+func (d *Device) Drain(match func([]byte) bool) [][]byte {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var matched [][]byte
+	kept := d.inbound[:0]
+	for _, packet := range d.inbound {
+		if match(packet) {
+			matched = append(matched, packet)
+		} else {
+			kept = append(kept, packet)
+		}
+	}
+	d.inbound = kept
+	return matched
+}
+
+// Address returns the IPv4 address the engine assigned to the device.
+func (d *Device) Address() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.address
+}
+
+func (d *Device) Read() ([]byte, error) {
+	select {
+	case packet := <-d.outbound:
+		return packet, nil
+	case <-d.done:
+		return nil, ErrDeviceClosed
+	}
+}
+
+func (d *Device) Write(packet []byte) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return ErrDeviceClosed
+	}
+	d.inbound = append(d.inbound, append([]byte(nil), packet...))
+	close(d.arrived)
+	d.arrived = make(chan struct{})
+	return nil
+}
+
+func (d *Device) GetName() string {
+	return d.name
+}
+
+func (d *Device) ConfigureIPv6(address, cidr string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.address6 = address
+	return nil
+}
+
+func (d *Device) SetAddress(address string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.address = address
+	return nil
+}
+
+func (d *Device) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.closed {
+		d.closed = true
+		close(d.done)
+	}
+	return nil
+}
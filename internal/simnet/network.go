@@ -0,0 +1,292 @@
+// Package simnet runs several VPN engines in one process over an in-memory
+// network, with programmable devices in place of TUN interfaces. It is used to
+// check delivery, routing, exit node selection and peer expiry end to end,
+// optionally under loss, latency and reordering.
+package simnet
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"nghost/internal/config"
+	"nghost/internal/nkn"
+	"nghost/internal/transport"
+	"nghost/internal/vpn"
+)
+
+const (
+	simCIDR       = "10.200.0.0/16"
+	simNetworkKey = "simnet"
+	simMTU        = 1400
+)
+
+// simLiveness makes peers go stale after 2 seconds and disappear after 5, so
+// expiry can be observed within a scenario.
+var simLiveness = config.LivenessConfig{
+	PingInterval: 1,
+	StaleAfter:   2,
+	OfflineAfter: 3,
+	RemoveAfter:  5,
+}
+
+// Conditions describe the links between all nodes.
+type Conditions struct {
+	Loss    float64       // probability that a message is dropped
+	Latency time.Duration // one-way delay of every message
+	Jitter  time.Duration // random extra delay of up to Jitter
+	Reorder float64       // probability that a message is held back so later ones overtake it
+}
+
+func (c Conditions) String() string {
+	return fmt.Sprintf("loss %.0f%%, latency %v, jitter %v, reorder %.0f%%",
+		c.Loss*100, c.Latency, c.Jitter, c.Reorder*100)
+}
+
+// NodeConfig describes a node of the simulated network.
+type NodeConfig struct {
+	Name            string
+	Exit            bool
+	Latency         time.Duration // extra one-way delay of messages to and from the node
+	AdvertiseRoutes []string
+}
+
+// Node is an engine with its client and device.
+type Node struct {
+	Name    string
+	Exit    bool
+	Client  *nkn.Client
+	Engine  *vpn.Engine
+	Device  *Device
+	net     *Network
+	latency time.Duration
+	stopped bool
+	down    bool // messages to and from the node are dropped
+}
+
+// Network is a set of nodes connected through a loopback hub.
+type Network struct {
+	hub *transport.Hub
+
+	mu         sync.Mutex
+	conditions Conditions
+	rand       *rand.Rand
+	nodes      map[string]*Node
+	order      []*Node
+}
+
+func NewNetwork() *Network {
+	return &Network{
+		hub:   transport.NewHub(),
+		rand:  rand.New(rand.NewSource(time.Now().UnixNano())),
+		nodes: make(map[string]*Node),
+	}
+}
+
+// SetConditions changes the link conditions for messages sent from now on.
+func (n *Network) SetConditions(c Conditions) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.conditions = c
+}
+
+// Start creates the nodes, makes every node a known peer of every other and
+// starts their engines.
+func (n *Network) Start(nodes ...NodeConfig) error {
+	for _, nc := range nodes {
+		if err := n.addNode(nc); err != nil {
+			n.Close()
+			return fmt.Errorf("node %s: %w", nc.Name, err)
+		}
+	}
+
+	for _, a := range n.order {
+		for _, b := range n.order {
+			if a != b {
+				a.Client.AddPeer(b.Name)
+			}
+		}
+	}
+
+	for _, node := range n.order {
+		start := node.Engine.StartDaemon
+		if node.Exit {
+			start = node.Engine.StartExitNode
+		}
+		if err := start(); err != nil {
+			n.Close()
+			return fmt.Errorf("node %s: %w", node.Name, err)
+		}
+	}
+	return nil
+}
+
+func (n *Network) addNode(nc NodeConfig) error {
+	endpoint, err := n.hub.Endpoint(nc.Name)
+	if err != nil {
+		return err
+	}
+
+	client, err := nkn.NewClientWithCarrier(config.NKNConfig{Liveness: simLiveness}, &carrier{Loopback: endpoint, net: n})
+	if err != nil {
+		endpoint.Close()
+		return err
+	}
+
+	node := &Node{Name: nc.Name, Exit: nc.Exit, Client: client, net: n, latency: nc.Latency}
+	vpnConfig := config.VPNConfig{
+		InterfaceName:   "sim-" + nc.Name,
+		CIDR:            simCIDR,
+		MTU:             simMTU,
+		NetworkKey:      simNetworkKey,
+		AdvertiseRoutes: nc.AdvertiseRoutes,
+		AcceptRoutes:    true,
+	}
+	node.Engine, err = vpn.NewSimulatedEngine(vpnConfig, client, func(name, address, cidr string, mtu int) (vpn.Device, error) {
+		node.Device = newDevice(name, address)
+		return node.Device, nil
+	})
+	if err != nil {
+		client.Close()
+		return err
+	}
+
+	n.mu.Lock()
+	n.nodes[nc.Name] = node
+	n.order = append(n.order, node)
+	n.mu.Unlock()
+	return nil
+}
+
+// Node returns the node called name.
+func (n *Network) Node(name string) *Node {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.nodes[name]
+}
+
+// Nodes returns all nodes in the order they were started.
+func (n *Network) Nodes() []*Node {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]*Node(nil), n.order...)
+}
+
+// WaitConverged waits until every running node has a route to every other
+// running node and sees all running exit nodes as online. It only looks at
+// peer snapshots, as the nodes keep updating their peers meanwhile.
+func (n *Network) WaitConverged(timeout time.Duration) error {
+	return waitFor(timeout, "network to converge", func() bool {
+		nodes := n.Nodes()
+		for _, a := range nodes {
+			if a.stopped {
+				continue
+			}
+			peers := a.Client.GetPeers()
+			exits := make(map[string]bool)
+			for _, peer := range a.Client.FindExitNodes() {
+				exits[peer.Address] = true
+			}
+			for _, b := range nodes {
+				if a == b || b.stopped {
+					continue
+				}
+				peer, ok := peers[b.Name]
+				if !ok || peer.IPAddress != b.Device.Address() || !peer.Online {
+					return false
+				}
+				if b.Exit && !exits[b.Name] {
+					return false
+				}
+			}
+		}
+		return true
+	})
+}
+
+// Close stops all nodes.
+func (n *Network) Close() {
+	for _, node := range n.Nodes() {
+		node.Stop()
+	}
+}
+
+// IP returns the VPN address of the node.
+func (node *Node) IP() net.IP {
+	if node.Device == nil {
+		return nil
+	}
+	return net.ParseIP(node.Device.Address())
+}
+
+// Crash cuts the node off the network and stops it, so its peers only
+// notice through missed pings.
+func (node *Node) Crash() {
+	node.net.mu.Lock()
+	node.down = true
+	node.net.mu.Unlock()
+	node.Stop()
+}
+
+// Stop shuts the node down gracefully.
+func (node *Node) Stop() {
+	if node.stopped {
+		return
+	}
+	node.stopped = true
+	node.Engine.Stop()
+	node.Client.Close()
+}
+
+// carrier applies the network conditions to a loopback endpoint.
+type carrier struct {
+	*transport.Loopback
+	net *Network
+}
+
+func (c *carrier) Send(dest string, data []byte) error {
+	n := c.net
+	n.mu.Lock()
+	cond := n.conditions
+	drop := cond.Loss > 0 && n.rand.Float64() < cond.Loss
+	delay := cond.Latency
+	if cond.Jitter > 0 {
+		delay += time.Duration(n.rand.Int63n(int64(cond.Jitter)))
+	}
+	if cond.Reorder > 0 && n.rand.Float64() < cond.Reorder {
+		delay += cond.Latency + cond.Jitter + time.Millisecond
+	}
+	for _, name := range []string{c.Address(), dest} {
+		if node := n.nodes[name]; node != nil {
+			delay += node.latency
+			drop = drop || node.down
+		}
+	}
+	n.mu.Unlock()
+
+	if drop {
+		return nil
+	}
+	if delay <= 0 {
+		return c.Loopback.Send(dest, data)
+	}
+
+	data = append([]byte(nil), data...)
+	time.AfterFunc(delay, func() {
+		c.Loopback.Send(dest, data)
+	})
+	return nil
+}
+
+func waitFor(timeout time.Duration, what string, cond func() bool) error {
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out after %v waiting for %s", timeout, what)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return nil
+}
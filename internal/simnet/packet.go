@@ -0,0 +1,80 @@
+package simnet
+
+import (
+	"encoding/binary"
+	"net"
+)
+
+const (
+	ipv4HeaderLen = 20
+	udpHeaderLen  = 8
+	protoUDP      = 17
+)
+
+// UDPPacket builds an IPv4 UDP packet with valid checksums.
+func UDPPacket(src, dst net.IP, srcPort, dstPort uint16, payload []byte) []byte {
+	src, dst = src.To4(), dst.To4()
+	total := ipv4HeaderLen + udpHeaderLen + len(payload)
+	packet := make([]byte, total)
+
+	ip := packet[:ipv4HeaderLen]
+	ip[0] = 0x45 // version 4, header length 5*4
+	binary.BigEndian.PutUint16(ip[2:], uint16(total))
+	ip[8] = 64 // TTL
+	ip[9] = protoUDP
+	copy(ip[12:16], src)
+	copy(ip[16:20], dst)
+	binary.BigEndian.PutUint16(ip[10:], checksum(ip, 0))
+
+	udp := packet[ipv4HeaderLen:]
+	binary.BigEndian.PutUint16(udp[0:], srcPort)
+	binary.BigEndian.PutUint16(udp[2:], dstPort)
+	binary.BigEndian.PutUint16(udp[4:], uint16(udpHeaderLen+len(payload)))
+	copy(udp[udpHeaderLen:], payload)
+
+	// Pseudo header: addresses, protocol and UDP length
+	var pseudo uint32
+	for i := 0; i < 4; i += 2 {
+		pseudo += uint32(binary.BigEndian.Uint16(src[i:]))
+		pseudo += uint32(binary.BigEndian.Uint16(dst[i:]))
+	}
+	pseudo += protoUDP + uint32(len(udp))
+	sum := checksum(udp, pseudo)
+	if sum == 0 {
+		sum = 0xffff
+	}
+	binary.BigEndian.PutUint16(udp[6:], sum)
+
+	return packet
+}
+
+func checksum(data []byte, initial uint32) uint16 {
+	sum := initial
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(data[i:]))
+	}
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+	return ^uint16(sum)
+}
+
+// MatchUDP returns a matcher for IPv4 UDP packets from src to dst carrying
+// payload.
+func MatchUDP(src, dst net.IP, payload []byte) func([]byte) bool {
+	return func(packet []byte) bool {
+		if len(packet) < ipv4HeaderLen+udpHeaderLen || packet[0]>>4 != 4 || packet[9] != protoUDP {
+			return false
+		}
+		headerLen := int(packet[0]&0x0f) * 4
+		if len(packet) < headerLen+udpHeaderLen {
+			return false
+		}
+		return net.IP(packet[12:16]).Equal(src) &&
+			net.IP(packet[16:20]).Equal(dst) &&
+			string(packet[headerLen+udpHeaderLen:]) == string(payload)
+	}
+}
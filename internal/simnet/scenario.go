@@ -0,0 +1,353 @@
+package simnet
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+
+	"nghost/internal/nkn"
+)
+
+const (
+	convergeTimeout = 15 * time.Second
+	burstPackets    = 100
+)
+
+// internetHost stands in for a host on the internet (TEST-NET-2).
+var internetHost = net.ParseIP("198.51.100.7")
+
+// Scenario is an end-to-end check on a fresh network. Run returns a short
+// summary on success. Slow scenarios wait for liveness timeouts.
+type Scenario struct {
+	Name string
+	Run  func(nodes int, cond Conditions) (string, error)
+	Slow bool
+}
+
+var Scenarios = []Scenario{
+	{Name: "delivery", Run: runDelivery},
+	{Name: "subnet-routing", Run: runSubnetRouting},
+	{Name: "exit-selection", Run: runExitSelection, Slow: true},
+	{Name: "peer-expiry", Run: runPeerExpiry, Slow: true},
+	{Name: "graceful-leave", Run: runGracefulLeave},
+}
+
+// Result is the outcome of a scenario.
+type Result struct {
+	Scenario string
+	Summary  string
+	Err      error
+	Duration time.Duration
+}
+
+// Run runs scenarios on networks of nodes nodes under cond.
+func Run(scenarios []Scenario, nodes int, cond Conditions) []Result {
+	var results []Result
+	for _, s := range scenarios {
+		start := time.Now()
+		summary, err := s.Run(nodes, cond)
+		results = append(results, Result{
+			Scenario: s.Name,
+			Summary:  summary,
+			Err:      err,
+			Duration: time.Since(start),
+		})
+	}
+	return results
+}
+
+// startNetwork starts a network and applies cond once it has converged, so
+// setup does not depend on announcements getting through.
+func startNetwork(cond Conditions, nodes ...NodeConfig) (*Network, error) {
+	n := NewNetwork()
+	if err := n.Start(nodes...); err != nil {
+		return nil, err
+	}
+	if err := n.WaitConverged(convergeTimeout); err != nil {
+		n.Close()
+		return nil, err
+	}
+	n.SetConditions(cond)
+	return n, nil
+}
+
+// runDelivery sends a burst of packets between every pair of nodes and
+// checks that they arrive unchanged, allowing for the configured loss.
+func runDelivery(nodes int, cond Conditions) (string, error) {
+	var configs []NodeConfig
+	for i := 1; i <= nodes; i++ {
+		configs = append(configs, NodeConfig{Name: fmt.Sprintf("node-%d", i)})
+	}
+	n, err := startNetwork(cond, configs...)
+	if err != nil {
+		return "", err
+	}
+	defer n.Close()
+
+	sent, received, reordered := 0, 0, 0
+	for _, a := range n.Nodes() {
+		for _, b := range n.Nodes() {
+			if a == b {
+				continue
+			}
+			for seq := 0; seq < burstPackets; seq++ {
+				if err := a.Device.Send(UDPPacket(a.IP(), b.IP(), 40000, 9, sequencePayload(seq))); err != nil {
+					return "", err
+				}
+				sent++
+			}
+		}
+	}
+
+	// Wait for stragglers, then count what arrived and in which order
+	time.Sleep(deliveryWait(cond))
+	for _, b := range n.Nodes() {
+		for _, a := range n.Nodes() {
+			if a == b {
+				continue
+			}
+			last := -1
+			for _, packet := range b.Device.Drain(matchUDPFrom(a.IP(), b.IP())) {
+				seq := int(binary.BigEndian.Uint32(packet[len(packet)-4:]))
+				if seq < last {
+					reordered++
+				}
+				last = seq
+				received++
+			}
+		}
+	}
+
+	summary := fmt.Sprintf("%d nodes, %d/%d packets delivered, %d reordered", nodes, received, sent, reordered)
+	if min := minDelivered(sent, cond.Loss); received < min {
+		return summary, fmt.Errorf("only %d of %d packets delivered, expected at least %d", received, sent, min)
+	}
+	return summary, nil
+}
+
+// runSubnetRouting checks that a subnet advertised by one node is reachable
+// from another and that replies from the subnet are accepted.
+func runSubnetRouting(nodes int, cond Conditions) (string, error) {
+	const lanHost = "192.168.77.10"
+	n, err := startNetwork(cond,
+		NodeConfig{Name: "client"},
+		NodeConfig{Name: "router", AdvertiseRoutes: []string{"192.168.77.0/24"}},
+	)
+	if err != nil {
+		return "", err
+	}
+	defer n.Close()
+
+	client, router := n.Node("client"), n.Node("router")
+	lan := net.ParseIP(lanHost)
+
+	if err := waitFor(convergeTimeout, "subnet route", func() bool {
+		for _, route := range client.Engine.Routes() {
+			if route.Prefix.String() == "192.168.77.0/24" && route.Peer == router.Name {
+				return true
+			}
+		}
+		return false
+	}); err != nil {
+		return "", err
+	}
+
+	if err := roundTrip(client, router, client.IP(), lan, cond); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("192.168.77.0/24 via %s reachable in both directions", router.Name), nil
+}
+
+// runExitSelection checks that traffic to the internet leaves through the
+// exit node with the lowest latency and fails over when it disappears.
+func runExitSelection(nodes int, cond Conditions) (string, error) {
+	n, err := startNetwork(cond,
+		NodeConfig{Name: "client"},
+		NodeConfig{Name: "exit-near", Exit: true, Latency: 5 * time.Millisecond},
+		NodeConfig{Name: "exit-far", Exit: true, Latency: 40 * time.Millisecond},
+	)
+	if err != nil {
+		return "", err
+	}
+	defer n.Close()
+
+	client, near, far := n.Node("client"), n.Node("exit-near"), n.Node("exit-far")
+
+	// Both exit nodes need a measured latency before the choice means anything
+	if err := waitFor(convergeTimeout, "exit node latencies", func() bool {
+		exits := client.Client.FindExitNodes()
+		for _, peer := range exits {
+			if peer.Latency == 0 {
+				return false
+			}
+		}
+		return len(exits) == 2
+	}); err != nil {
+		return "", err
+	}
+
+	if err := roundTrip(client, near, client.IP(), internetHost, cond); err != nil {
+		return "", fmt.Errorf("before failover: %w", err)
+	}
+	if active := client.Engine.Status().ActiveExit; active != near.Name {
+		return "", fmt.Errorf("active exit node is %q, want %q", active, near.Name)
+	}
+
+	near.Crash()
+	if err := waitFor(convergeTimeout, "exit node to go stale", func() bool {
+		for _, peer := range client.Client.FindExitNodes() {
+			if peer.Address == near.Name {
+				return false
+			}
+		}
+		return true
+	}); err != nil {
+		return "", err
+	}
+
+	if err := roundTrip(client, far, client.IP(), internetHost, cond); err != nil {
+		return "", fmt.Errorf("after failover: %w", err)
+	}
+	return fmt.Sprintf("picked %s, failed over to %s", near.Name, far.Name), nil
+}
+
+// runPeerExpiry checks that a silent peer goes stale, offline and is
+// removed along with its route.
+func runPeerExpiry(nodes int, cond Conditions) (string, error) {
+	n, err := startNetwork(cond, NodeConfig{Name: "observer"}, NodeConfig{Name: "leaver"})
+	if err != nil {
+		return "", err
+	}
+	defer n.Close()
+
+	observer, leaver := n.Node("observer"), n.Node("leaver")
+	leaverIP := leaver.IP()
+
+	states := make(chan nkn.PeerState, 8)
+	observer.Client.OnPeerEvent(func(event nkn.PeerEvent) {
+		if event.Peer.Address == leaver.Name {
+			select {
+			case states <- event.State:
+			default:
+			}
+		}
+	})
+
+	start := time.Now()
+	leaver.Crash()
+
+	want := []nkn.PeerState{nkn.PeerStale, nkn.PeerOffline, nkn.PeerRemoved}
+	timeout := time.After(time.Duration(simLiveness.RemoveAfter+3) * time.Second)
+	for _, state := range want {
+		select {
+		case got := <-states:
+			if got != state {
+				return "", fmt.Errorf("peer went %s, want %s", got, state)
+			}
+		case <-timeout:
+			return "", fmt.Errorf("peer never went %s", state)
+		}
+	}
+	elapsed := time.Since(start)
+
+	if _, ok := observer.Client.GetPeers()[leaver.Name]; ok {
+		return "", fmt.Errorf("removed peer still listed")
+	}
+	for _, route := range observer.Engine.Routes() {
+		if route.Peer == leaver.Name {
+			return "", fmt.Errorf("route %s to removed peer %s still installed", route.Prefix, leaverIP)
+		}
+	}
+	return fmt.Sprintf("stale -> offline -> removed after %v", elapsed.Round(100*time.Millisecond)), nil
+}
+
+// runGracefulLeave checks that peers withdraw the routes of a node that shuts
+// down cleanly as soon as it says goodbye, well before it would go stale.
+func runGracefulLeave(nodes int, cond Conditions) (string, error) {
+	n, err := startNetwork(cond, NodeConfig{Name: "observer"}, NodeConfig{Name: "leaver"})
+	if err != nil {
+		return "", err
+	}
+	defer n.Close()
+
+	observer, leaver := n.Node("observer"), n.Node("leaver")
+
+	start := time.Now()
+	leaver.Stop()
+
+	// Under loss the goodbye may not arrive and liveness takes over
+	timeout := time.Duration(simLiveness.StaleAfter) * time.Second
+	if cond.Loss > 0 {
+		timeout = time.Duration(simLiveness.RemoveAfter) * time.Second
+	}
+	if err := waitFor(timeout, "routes of the leaving peer to be withdrawn", func() bool {
+		for _, route := range observer.Engine.Routes() {
+			if route.Peer == leaver.Name {
+				return false
+			}
+		}
+		return true
+	}); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("routes withdrawn %v after goodbye", time.Since(start).Round(10*time.Millisecond)), nil
+}
+
+// roundTrip sends a packet from host src behind node a to dst, expects it on
+// node b's device and sends the reply back. Under loss the packet is resent
+// a few times, as a transport protocol would.
+func roundTrip(a, b *Node, src, dst net.IP, cond Conditions) error {
+	attempts := 1
+	if cond.Loss > 0 {
+		attempts = 10
+	}
+
+	request := UDPPacket(src, dst, 40000, 53, []byte("request"))
+	if err := deliver(a, b, request, MatchUDP(src, dst, []byte("request")), attempts, cond); err != nil {
+		return fmt.Errorf("%s -> %s: %w", src, dst, err)
+	}
+
+	reply := UDPPacket(dst, src, 53, 40000, []byte("reply"))
+	if err := deliver(b, a, reply, MatchUDP(dst, src, []byte("reply")), attempts, cond); err != nil {
+		return fmt.Errorf("%s -> %s: %w", dst, src, err)
+	}
+	return nil
+}
+
+func deliver(from, to *Node, packet []byte, match func([]byte) bool, attempts int, cond Conditions) error {
+	var err error
+	for i := 0; i < attempts; i++ {
+		if err = from.Device.Send(packet); err != nil {
+			return err
+		}
+		if _, err = to.Device.Expect(match, deliveryWait(cond)); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// deliveryWait is how long a packet may take under cond.
+func deliveryWait(cond Conditions) time.Duration {
+	return time.Second + 3*(cond.Latency+cond.Jitter)
+}
+
+// minDelivered is the fewest of sent packets expected to arrive with the
+// given loss rate, with some slack for randomness.
+func minDelivered(sent int, loss float64) int {
+	return int(float64(sent) * (1 - loss) * 0.8)
+}
+
+func sequencePayload(seq int) []byte {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, uint32(seq))
+	return payload
+}
+
+func matchUDPFrom(src, dst net.IP) func([]byte) bool {
+	return func(packet []byte) bool {
+		return len(packet) >= ipv4HeaderLen+udpHeaderLen+4 && packet[9] == protoUDP &&
+			net.IP(packet[12:16]).Equal(src) && net.IP(packet[16:20]).Equal(dst)
+	}
+}
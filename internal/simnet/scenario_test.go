@@ -0,0 +1,33 @@
+package simnet
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScenarios(t *testing.T) {
+	for _, s := range Scenarios {
+		t.Run(s.Name, func(t *testing.T) {
+			if s.Slow && testing.Short() {
+				t.Skip("slow scenario")
+			}
+			summary, err := s.Run(4, Conditions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Log(summary)
+		})
+	}
+}
+
+func TestDeliveryUnderLoss(t *testing.T) {
+	if testing.Short() {
+		t.Skip("slow scenario")
+	}
+	cond := Conditions{Loss: 0.1, Latency: 5 * time.Millisecond, Jitter: 5 * time.Millisecond, Reorder: 0.1}
+	summary, err := runDelivery(3, cond)
+	if err != nil {
+		t.Fatalf("%s: %v", cond, err)
+	}
+	t.Log(summary)
+}
@@ -0,0 +1,107 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"sync"
+)
+
+// EndpointReporter is implemented by carriers that exchange traffic with
+// hosts outside the VPN.
+type EndpointReporter interface {
+	// OnEndpoint sets fn to be called with every remote address the
+	// carrier talks to, including the ones it talked to before.
+	OnEndpoint(fn func(netip.Addr))
+}
+
+// Endpoints records the remote addresses of a carrier. The callback runs
+// before traffic to a new address is sent, so in full-tunnel mode the engine
+// can route it around the TUN device first and the carrier never ends up
+// tunnelling its own traffic.
+type Endpoints struct {
+	mu   sync.Mutex
+	seen map[netip.Addr]bool
+	fn   func(netip.Addr)
+}
+
+// Add records addr and reports it if it is new.
+func (e *Endpoints) Add(addr netip.Addr) {
+	addr = addr.Unmap()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.seen[addr] {
+		return
+	}
+	if e.seen == nil {
+		e.seen = make(map[netip.Addr]bool)
+	}
+	e.seen[addr] = true
+	if e.fn != nil {
+		e.fn(addr)
+	}
+}
+
+func (e *Endpoints) OnEndpoint(fn func(netip.Addr)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.fn = fn
+	for addr := range e.seen {
+		fn(addr)
+	}
+}
+
+// DialContext returns a dial function that resolves the host with the
+// resolver of d, records the addresses and dials them in turn. The DNS
+// servers d queries are recorded as well.
+func (e *Endpoints) DialContext(d *net.Dialer) func(ctx context.Context, network, address string) (net.Conn, error) {
+	var resolverDial func(ctx context.Context, network, address string) (net.Conn, error)
+	if d.Resolver != nil {
+		resolverDial = d.Resolver.Dial
+	}
+	if resolverDial == nil {
+		resolverDial = (&net.Dialer{}).DialContext
+	}
+	// The Go resolver is the one that dials through Dial
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			if server, err := netip.ParseAddrPort(address); err == nil {
+				e.Add(server.Addr())
+			}
+			return resolverDial(ctx, network, address)
+		},
+	}
+	dialer := *d
+	dialer.Resolver = resolver
+
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		addrs, err := resolver.LookupNetIP(ctx, "ip", host)
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			e.Add(addr)
+		}
+
+		// Dial the addresses just reported rather than resolving again,
+		// which may return others
+		var errs []error
+		for _, addr := range addrs {
+			conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(addr.Unmap().String(), port))
+			if err == nil {
+				return conn, nil
+			}
+			errs = append(errs, err)
+			if ctx.Err() != nil {
+				break
+			}
+		}
+		return nil, errors.Join(errs...)
+	}
+}
@@ -0,0 +1,102 @@
+package transport
+
+import (
+	"fmt"
+	"sync"
+)
+
+// loopbackQueue is the number of messages buffered per endpoint. Messages to
+// a full endpoint are dropped, like packets on a congested link.
+const loopbackQueue = 1024
+
+// Hub connects loopback endpoints in one process. It lets several engines
+// talk to each other without any network, e.g. for tests and simulations.
+type Hub struct {
+	mu        sync.RWMutex
+	endpoints map[string]*Loopback
+}
+
+func NewHub() *Hub {
+	return &Hub{endpoints: make(map[string]*Loopback)}
+}
+
+// Endpoint attaches a new endpoint with address addr to the hub.
+func (h *Hub) Endpoint(addr string) (*Loopback, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, exists := h.endpoints[addr]; exists {
+		return nil, fmt.Errorf("loopback address %q already in use", addr)
+	}
+	l := &Loopback{hub: h, addr: addr, recv: make(chan Message, loopbackQueue)}
+	h.endpoints[addr] = l
+	return l, nil
+}
+
+func (h *Hub) endpoint(addr string) *Loopback {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.endpoints[addr]
+}
+
+// Loopback is a Carrier endpoint on a Hub.
+type Loopback struct {
+	hub  *Hub
+	addr string
+
+	mu     sync.RWMutex
+	recv   chan Message
+	closed bool
+}
+
+func (l *Loopback) Address() string {
+	return l.addr
+}
+
+func (l *Loopback) Send(dest string, data []byte) error {
+	l.mu.RLock()
+	closed := l.closed
+	l.mu.RUnlock()
+	if closed {
+		return ErrClosed
+	}
+
+	peer := l.hub.endpoint(dest)
+	if peer == nil {
+		return fmt.Errorf("%w: %s", ErrUnreachable, dest)
+	}
+	peer.deliver(Message{Src: l.addr, Data: append([]byte(nil), data...)})
+	return nil
+}
+
+func (l *Loopback) deliver(msg Message) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.closed {
+		return
+	}
+	select {
+	case l.recv <- msg:
+	default:
+	}
+}
+
+func (l *Loopback) Receive() <-chan Message {
+	return l.recv
+}
+
+func (l *Loopback) Close() error {
+	l.hub.mu.Lock()
+	if l.hub.endpoints[l.addr] == l {
+		delete(l.hub.endpoints, l.addr)
+	}
+	l.hub.mu.Unlock()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.closed {
+		l.closed = true
+		close(l.recv)
+	}
+	return nil
+}
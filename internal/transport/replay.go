@@ -0,0 +1,53 @@
+package transport
+
+// replayWords is the size of a replay window in 64-bit words. One word is
+// always being cleared, so the window covers (replayWords-1)*64 sequence
+// numbers below the highest one seen.
+const (
+	replayWords  = 16
+	replayWindow = (replayWords - 1) * 64
+)
+
+// replayFilter rejects datagrams seen before, using a sliding window over
+// the sequence numbers of one sender (RFC 6479). Each run of the sender has a
+// new, higher epoch: a higher epoch restarts the window and datagrams from
+// older epochs are rejected.
+type replayFilter struct {
+	epoch uint64
+	top   uint64
+	bits  [replayWords]uint64
+}
+
+// accept reports whether the datagram (epoch, seq) is new and records it.
+// Sequence numbers start at 1.
+func (f *replayFilter) accept(epoch, seq uint64) bool {
+	switch {
+	case epoch < f.epoch:
+		return false
+	case epoch > f.epoch:
+		*f = replayFilter{epoch: epoch}
+	}
+
+	if seq > f.top {
+		// Clear the words the window slides over
+		current, next := f.top/64, seq/64
+		words := next - current
+		if words > replayWords {
+			words = replayWords
+		}
+		for i := uint64(1); i <= words; i++ {
+			f.bits[(current+i)%replayWords] = 0
+		}
+		f.top = seq
+	} else if f.top-seq >= replayWindow {
+		return false
+	}
+
+	word := &f.bits[(seq/64)%replayWords]
+	bit := uint64(1) << (seq % 64)
+	if *word&bit != 0 {
+		return false
+	}
+	*word |= bit
+	return true
+}
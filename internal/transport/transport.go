@@ -0,0 +1,29 @@
+package transport
+
+import "errors"
+
+var (
+	ErrClosed      = errors.New("transport closed")
+	ErrUnreachable = errors.New("destination unreachable")
+)
+
+// Carrier moves framed messages between nodes. Addresses are opaque strings
+// whose format depends on the carrier: NKN client addresses, host:port pairs
+// for UDP, or arbitrary names on a loopback hub. Delivery is best effort, like
+// IP; reliability is up to the protocols running inside the VPN.
+type Carrier interface {
+	// Address is the address other nodes send to.
+	Address() string
+	// Send delivers data to dest.
+	Send(dest string, data []byte) error
+	// Receive returns the channel incoming messages are delivered on. It is
+	// closed when the carrier is closed.
+	Receive() <-chan Message
+	Close() error
+}
+
+// Message is a message received from Src.
+type Message struct {
+	Src  string
+	Data []byte
+}
@@ -0,0 +1,237 @@
+package transport
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"nghost/internal/firewall"
+)
+
+// maxDatagram is the largest UDP payload we accept.
+const maxDatagram = 65535
+
+var ErrNoKey = errors.New("UDP transport needs a network key")
+
+// UDP carries messages as UDP datagrams, so nodes on a LAN can form a VPN
+// without NKN seeds. Node addresses are host:port pairs.
+//
+// Datagrams are sealed with AES-GCM under a key derived from the network key
+// and carry the sender's advertised address, so the source of a message is
+// that address rather than the unauthenticated one the datagram arrived from.
+// Members share the key, so this keeps outsiders out but does not tell
+// members apart.
+//
+// Each datagram also carries the epoch of the sender (when it started) and a
+// sequence number per destination, so receivers drop replayed datagrams.
+type UDP struct {
+	conn  *net.UDPConn
+	addr  string
+	aead  cipher.AEAD
+	epoch uint64
+	recv  chan Message
+
+	mu        sync.Mutex
+	dests     map[string]*udpDest
+	endpoints Endpoints
+
+	// Only used by readLoop
+	filters map[string]*replayFilter
+}
+
+type udpDest struct {
+	addr *net.UDPAddr
+	seq  uint64
+}
+
+// NewUDP listens on listen. address is the host:port other nodes send to and
+// defaults to listen, which then needs a specific host. key is the network
+// key shared by all nodes.
+func NewUDP(listen, address, key string) (*UDP, error) {
+	if key == "" {
+		return nil, ErrNoKey
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	laddr, err := net.ResolveUDPAddr("udp", listen)
+	if err != nil {
+		return nil, fmt.Errorf("invalid UDP listen address: %w", err)
+	}
+	if address == "" {
+		if laddr.IP == nil || laddr.IP.IsUnspecified() {
+			return nil, fmt.Errorf("UDP listen address %q has no host, set the advertised address", listen)
+		}
+		address = laddr.String()
+	}
+	if _, err := net.ResolveUDPAddr("udp", address); err != nil {
+		return nil, fmt.Errorf("invalid UDP address: %w", err)
+	}
+	if len(address) > 255 {
+		return nil, fmt.Errorf("UDP address %q is too long", address)
+	}
+
+	// The socket carries the firewall mark, so the kill switch lets the
+	// datagrams through
+	conn, err := firewall.ListenConfig().ListenPacket(context.Background(), "udp", laddr.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", listen, err)
+	}
+
+	u := &UDP{
+		conn:    conn.(*net.UDPConn),
+		addr:    address,
+		aead:    aead,
+		epoch:   uint64(time.Now().UnixNano()),
+		recv:    make(chan Message, 1024),
+		dests:   make(map[string]*udpDest),
+		filters: make(map[string]*replayFilter),
+	}
+	go u.readLoop()
+	return u, nil
+}
+
+func (u *UDP) Address() string {
+	return u.addr
+}
+
+func (u *UDP) Send(dest string, data []byte) error {
+	raddr, seq, err := u.resolve(dest)
+	if err != nil {
+		return err
+	}
+	datagram, err := u.seal(seq, data)
+	if err != nil {
+		return err
+	}
+	if _, err := u.conn.WriteToUDP(datagram, raddr); err != nil {
+		if errors.Is(err, net.ErrClosed) {
+			return ErrClosed
+		}
+		return err
+	}
+	return nil
+}
+
+// resolve returns the address of dest and the next sequence number for it.
+func (u *UDP) resolve(dest string) (*net.UDPAddr, uint64, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	d, ok := u.dests[dest]
+	if !ok {
+		raddr, err := net.ResolveUDPAddr("udp", dest)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%w: %v", ErrUnreachable, err)
+		}
+		d = &udpDest{addr: raddr}
+		u.dests[dest] = d
+		u.endpoints.Add(raddr.AddrPort().Addr())
+	}
+	d.seq++
+	return d.addr, d.seq, nil
+}
+
+func (u *UDP) OnEndpoint(fn func(netip.Addr)) {
+	u.endpoints.OnEndpoint(fn)
+}
+
+func (u *UDP) readLoop() {
+	defer close(u.recv)
+
+	buf := make([]byte, maxDatagram)
+	for {
+		n, _, err := u.conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		src, data, ok := u.open(buf[:n])
+		if !ok {
+			// Not from a member, corrupted or replayed
+			continue
+		}
+		msg := Message{Src: src, Data: data}
+		select {
+		case u.recv <- msg:
+		default:
+			// Receiver is not keeping up; drop like a full socket buffer
+		}
+	}
+}
+
+// newAEAD derives the datagram key from the network key.
+func newAEAD(key string) (cipher.AEAD, error) {
+	sum := sha256.Sum256([]byte("nghost-udp|" + key))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal returns the datagram carrying data: a random nonce followed by the
+// sealed length-prefixed sender address, epoch, sequence number and data.
+func (u *UDP) seal(seq uint64, data []byte) ([]byte, error) {
+	nonceSize := u.aead.NonceSize()
+	plain := make([]byte, 0, 1+len(u.addr)+16+len(data))
+	plain = append(plain, byte(len(u.addr)))
+	plain = append(plain, u.addr...)
+	plain = binary.BigEndian.AppendUint64(plain, u.epoch)
+	plain = binary.BigEndian.AppendUint64(plain, seq)
+	plain = append(plain, data...)
+
+	datagram := make([]byte, nonceSize, nonceSize+len(plain)+u.aead.Overhead())
+	if _, err := rand.Read(datagram); err != nil {
+		return nil, err
+	}
+	return u.aead.Seal(datagram, datagram, plain, nil), nil
+}
+
+// open authenticates a datagram and returns the sender address and data.
+// Datagrams seen before are rejected. It is only called by readLoop.
+func (u *UDP) open(datagram []byte) (string, []byte, bool) {
+	nonceSize := u.aead.NonceSize()
+	if len(datagram) < nonceSize {
+		return "", nil, false
+	}
+	plain, err := u.aead.Open(nil, datagram[:nonceSize], datagram[nonceSize:], nil)
+	if err != nil || len(plain) < 1 || len(plain) < 1+int(plain[0])+16 {
+		return "", nil, false
+	}
+	n := int(plain[0])
+	src := string(plain[1 : 1+n])
+	epoch := binary.BigEndian.Uint64(plain[1+n:])
+	seq := binary.BigEndian.Uint64(plain[1+n+8:])
+
+	filter, ok := u.filters[src]
+	if !ok {
+		filter = &replayFilter{}
+		u.filters[src] = filter
+	}
+	if !filter.accept(epoch, seq) {
+		return "", nil, false
+	}
+	return src, plain[1+n+16:], true
+}
+
+func (u *UDP) Receive() <-chan Message {
+	return u.recv
+}
+
+func (u *UDP) Close() error {
+	return u.conn.Close()
+}
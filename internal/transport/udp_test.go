@@ -0,0 +1,151 @@
+package transport
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func newTestUDP(t *testing.T, key string) *UDP {
+	t.Helper()
+	u, err := NewUDP("127.0.0.1:0", "", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Advertise the port we actually got
+	u.addr = u.conn.LocalAddr().String()
+	t.Cleanup(func() { u.Close() })
+	return u
+}
+
+func TestUDPNeedsKey(t *testing.T) {
+	if _, err := NewUDP("127.0.0.1:0", "", ""); !errors.Is(err, ErrNoKey) {
+		t.Fatalf("got %v, want ErrNoKey", err)
+	}
+}
+
+func TestUDPAuthenticatesDatagrams(t *testing.T) {
+	a := newTestUDP(t, "secret")
+	b := newTestUDP(t, "secret")
+	stranger := newTestUDP(t, "other")
+
+	// Datagrams sealed with another key, or not sealed at all, are dropped
+	if err := stranger.Send(b.Address(), []byte("forged")); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := net.Dial("udp", b.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	if _, err := raw.Write([]byte("plaintext")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.Send(b.Address(), []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-b.Receive():
+		if msg.Src != a.Address() || string(msg.Data) != "hello" {
+			t.Fatalf("got %q from %s, want hello from %s", msg.Data, msg.Src, a.Address())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+	}
+
+	select {
+	case msg := <-b.Receive():
+		t.Fatalf("unexpected message %q from %s", msg.Data, msg.Src)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestUDPSealHidesData(t *testing.T) {
+	u := newTestUDP(t, "secret")
+	datagram, err := u.seal(1, []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(datagram, []byte("hello")) || bytes.Contains(datagram, []byte(u.addr)) {
+		t.Fatal("datagram carries plaintext")
+	}
+
+	// Any modification is rejected
+	for i := range datagram {
+		tampered := append([]byte(nil), datagram...)
+		tampered[i] ^= 1
+		if _, _, ok := u.open(tampered); ok {
+			t.Fatalf("datagram modified at byte %d accepted", i)
+		}
+	}
+	if _, _, ok := u.open(datagram[:5]); ok {
+		t.Fatal("truncated datagram accepted")
+	}
+}
+
+func TestUDPDropsReplayedDatagrams(t *testing.T) {
+	a := newTestUDP(t, "secret")
+	b := newTestUDP(t, "secret")
+
+	datagram, err := a.seal(1, []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := net.Dial("udp", b.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	for i := 0; i < 3; i++ {
+		if _, err := raw.Write(datagram); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case msg := <-b.Receive():
+		if string(msg.Data) != "hello" {
+			t.Fatalf("got %q, want hello", msg.Data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+	}
+	select {
+	case msg := <-b.Receive():
+		t.Fatalf("replayed message %q accepted", msg.Data)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestReplayFilter(t *testing.T) {
+	var f replayFilter
+	accept := func(epoch, seq uint64, want bool) {
+		t.Helper()
+		if got := f.accept(epoch, seq); got != want {
+			t.Fatalf("accept(%d, %d) = %v, want %v", epoch, seq, got, want)
+		}
+	}
+
+	// Out of order within the window, but only once
+	accept(1, 5, true)
+	accept(1, 3, true)
+	accept(1, 4, true)
+	accept(1, 3, false)
+	accept(1, 5, false)
+
+	// Sliding far ahead forgets old datagrams and rejects them
+	accept(1, 5+replayWindow, true)
+	accept(1, 6, true)
+	accept(1, 5, false)
+	accept(1, 4, false)
+	accept(1, 6, false)
+
+	// A restarted sender begins a new window; the old run is rejected
+	accept(2, 1, true)
+	accept(2, 2, true)
+	accept(1, 10+replayWindow, false)
+	accept(2, 1, false)
+}
@@ -2,45 +2,62 @@ package tun
 
 import (
 	"fmt"
+	"log/slog"
 	"net"
+	"os"
 	"runtime"
+
+	"nghost/internal/logging"
 )
 
 type Device struct {
 	name           string
+	address        string
+	address6       string
 	cidr           string
 	mtu            int
 	fd             int
+	file           *os.File
 	simulationMode *SimulationDevice
+	log            *slog.Logger
 }
 
-func NewDevice(name, cidr string, mtu int) (*Device, error) {
+// NewDevice creates a TUN interface and assigns it address inside cidr.
+func NewDevice(name, address, cidr string, mtu int) (*Device, error) {
 	device := &Device{
-		name: name,
-		cidr: cidr,
-		mtu:  mtu,
+		name:    name,
+		address: address,
+		cidr:    cidr,
+		mtu:     mtu,
+		log:     logging.Logger(logging.TUN),
 	}
 
 	if err := device.create(); err != nil {
 		// If TUN creation fails, offer simulation mode
-		fmt.Printf("❌ Failed to create real TUN device: %v\n", err)
-		fmt.Printf("🔄 Falling back to simulation mode for testing...\n")
-		
+		device.log.Error("failed to create TUN device, falling back to simulation mode", "err", err)
+
 		simDevice, simErr := NewSimulationDevice(name, cidr, mtu)
 		if simErr != nil {
 			return nil, fmt.Errorf("both real and simulation TUN failed: real=%v, sim=%v", err, simErr)
 		}
-		
+
 		// Return a device that wraps the simulation
 		return &Device{
 			name:           name,
+			address:        address,
 			cidr:           cidr,
 			mtu:            mtu,
 			fd:             -1, // Mark as simulation
 			simulationMode: simDevice,
+			log:            device.log,
 		}, nil
 	}
 
+	if err := device.pollable(); err != nil {
+		device.Close()
+		return nil, err
+	}
+
 	if err := device.configure(); err != nil {
 		device.Close()
 		return nil, err
@@ -68,9 +85,10 @@ func (d *Device) configure() error {
 		return fmt.Errorf("invalid CIDR: %w", err)
 	}
 
-	// Get the first usable IP in the network for this interface
-	ip := network.IP
-	ip[len(ip)-1] = 1 // Use .1 as the interface IP
+	ip := net.ParseIP(d.address)
+	if ip == nil || !network.Contains(ip) {
+		return fmt.Errorf("address %q is not inside %s", d.address, d.cidr)
+	}
 
 	switch runtime.GOOS {
 	case "linux":
@@ -88,7 +106,7 @@ func (d *Device) Read() ([]byte, error) {
 	if d.simulationMode != nil {
 		return d.simulationMode.Read()
 	}
-	
+
 	switch runtime.GOOS {
 	case "linux", "darwin":
 		return d.readUnix()
@@ -103,7 +121,7 @@ func (d *Device) Write(packet []byte) error {
 	if d.simulationMode != nil {
 		return d.simulationMode.Write(packet)
 	}
-	
+
 	switch runtime.GOOS {
 	case "linux", "darwin":
 		return d.writeUnix(packet)
@@ -118,11 +136,74 @@ func (d *Device) GetName() string {
 	return d.name
 }
 
+func (d *Device) GetAddress() string {
+	return d.address
+}
+
+// ConfigureIPv6 adds an IPv6 address from cidr to the interface.
+func (d *Device) ConfigureIPv6(address, cidr string) error {
+	ip := net.ParseIP(address)
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return fmt.Errorf("invalid IPv6 CIDR: %w", err)
+	}
+	if ip == nil || ip.To4() != nil || !network.Contains(ip) {
+		return fmt.Errorf("address %q is not an IPv6 address inside %s", address, cidr)
+	}
+	ones, _ := network.Mask.Size()
+
+	if d.simulationMode != nil {
+		d.address6 = address
+		return nil
+	}
+
+	switch runtime.GOOS {
+	case "linux":
+		err = d.configureIPv6Linux(ip.String(), ones)
+	case "darwin":
+		err = d.configureIPv6Darwin(ip.String(), ones)
+	default:
+		err = fmt.Errorf("unsupported platform: %s", runtime.GOOS)
+	}
+	if err != nil {
+		return err
+	}
+	d.address6 = address
+	return nil
+}
+
+// SetAddress replaces the interface address, e.g. after an address conflict
+// was resolved in favour of another peer.
+func (d *Device) SetAddress(address string) error {
+	if net.ParseIP(address) == nil {
+		return fmt.Errorf("invalid address %q", address)
+	}
+	if d.simulationMode != nil {
+		d.address = address
+		return nil
+	}
+
+	var err error
+	switch runtime.GOOS {
+	case "linux":
+		err = d.setAddressLinux(d.address, address)
+	case "darwin":
+		err = d.setAddressDarwin(address)
+	default:
+		err = fmt.Errorf("unsupported platform: %s", runtime.GOOS)
+	}
+	if err != nil {
+		return err
+	}
+	d.address = address
+	return nil
+}
+
 func (d *Device) Close() error {
 	if d.simulationMode != nil {
 		return d.simulationMode.Close()
 	}
-	
+
 	switch runtime.GOOS {
 	case "linux", "darwin":
 		return d.closeUnix()
@@ -131,4 +212,4 @@ func (d *Device) Close() error {
 	default:
 		return fmt.Errorf("unsupported platform: %s", runtime.GOOS)
 	}
-}
\ No newline at end of file
+}
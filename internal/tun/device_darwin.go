@@ -9,26 +9,26 @@ import (
 )
 
 func (d *Device) createDarwin() error {
-	fmt.Printf("🔧 Attempting to create TUN device on macOS...\n")
+	d.log.Debug("creating TUN device")
 	
 	// Try to use the utun implementation first
-	fmt.Printf("🔧 Trying utun control interface...\n")
+	d.log.Debug("trying utun control interface")
 	if err := d.createDarwinUtun(); err == nil {
-		fmt.Printf("✅ Created utun device: %s\n", d.name)
+		d.log.Info("created utun device", "device", d.name)
 		return nil
 	} else {
-		fmt.Printf("❌ utun control failed: %v\n", err)
+		d.log.Debug("utun control failed", "err", err)
 	}
 	
 	// On macOS, we can try to create TUN using ifconfig
 	// This requires the system to have TUN/TAP support
-	fmt.Printf("🔧 Trying ifconfig method...\n")
+	d.log.Debug("trying ifconfig")
 	for i := 0; i < 10; i++ {
 		interfaceName := fmt.Sprintf("utun%d", i)
 		
 		// Try to create the interface using ifconfig
 		cmd := exec.Command("ifconfig", interfaceName, "create")
-		fmt.Printf("🔧 Trying to create interface: %s\n", interfaceName)
+		d.log.Debug("creating interface", "device", interfaceName)
 		if err := cmd.Run(); err == nil {
 			// Interface created successfully, now try to open it
 			tunPath := fmt.Sprintf("/dev/%s", interfaceName)
@@ -36,13 +36,13 @@ func (d *Device) createDarwin() error {
 			if err == nil {
 				d.fd = fd
 				d.name = interfaceName
-				fmt.Printf("✅ Created TUN device: %s (fd=%d)\n", interfaceName, fd)
+				d.log.Info("created TUN device", "device", interfaceName, "fd", fd)
 				return nil
 			} else {
-				fmt.Printf("❌ Failed to open %s: %v\n", tunPath, err)
+				d.log.Debug("failed to open device", "path", tunPath, "err", err)
 			}
 		} else {
-			fmt.Printf("❌ Failed to create %s: %v\n", interfaceName, err)
+			d.log.Debug("failed to create interface", "device", interfaceName, "err", err)
 		}
 	}
 	
@@ -50,7 +50,7 @@ func (d *Device) createDarwin() error {
 }
 
 func (d *Device) configureDarwin(ip, network string) error {
-	fmt.Printf("🔧 Configuring interface %s with IP %s\n", d.name, ip)
+	d.log.Debug("configuring interface", "device", d.name, "ip", ip)
 	
 	commands := [][]string{
 		{"ifconfig", d.name, ip, ip, "up"},
@@ -58,17 +58,16 @@ func (d *Device) configureDarwin(ip, network string) error {
 	}
 
 	for _, cmd := range commands {
-		fmt.Printf("🔧 Running: %v\n", cmd)
+		d.log.Debug("running command", "cmd", cmd)
 		if err := exec.Command(cmd[0], cmd[1:]...).Run(); err != nil {
-			fmt.Printf("❌ Command failed: %v\n", err)
+			d.log.Debug("command failed", "cmd", cmd, "err", err)
 			// Don't fail on route command errors (might already exist)
 			if cmd[0] == "route" {
-				fmt.Printf("⚠️  Route command failed (may already exist), continuing...\n")
+				d.log.Warn("route command failed, the route may already exist", "cmd", cmd)
 				continue
 			}
 			return fmt.Errorf("failed to run command %v: %w", cmd, err)
 		}
-		fmt.Printf("✅ Command succeeded\n")
 	}
 	return nil
 }
\ No newline at end of file
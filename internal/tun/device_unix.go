@@ -3,10 +3,15 @@
 package tun
 
 import (
+	"errors"
 	"fmt"
+	"net/netip"
+	"os"
 	"os/exec"
 	"syscall"
 	"unsafe"
+
+	"nghost/internal/netlink"
 )
 
 const (
@@ -40,26 +45,95 @@ func (d *Device) createLinux() error {
 	return nil
 }
 
-
 func (d *Device) configureLinux(ip, network string) error {
-	commands := [][]string{
-		{"ip", "addr", "add", ip + "/16", "dev", d.name},
-		{"ip", "link", "set", "dev", d.name, "up"},
-		{"ip", "link", "set", "dev", d.name, "mtu", fmt.Sprintf("%d", d.mtu)},
+	prefix, err := interfacePrefix(ip, network)
+	if err != nil {
+		return err
+	}
+	if err := netlink.AddAddress(d.name, prefix); err != nil && !errors.Is(err, netlink.ErrExists) {
+		return fmt.Errorf("failed to add address: %w", err)
+	}
+	if err := netlink.SetMTU(d.name, d.mtu); err != nil {
+		return fmt.Errorf("failed to set MTU: %w", err)
+	}
+	if err := netlink.SetLinkUp(d.name); err != nil {
+		return fmt.Errorf("failed to bring interface up: %w", err)
+	}
+	return nil
+}
+
+func (d *Device) configureIPv6Linux(ip string, prefixLen int) error {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return err
+	}
+	if err := netlink.AddAddress(d.name, netip.PrefixFrom(addr, prefixLen)); err != nil && !errors.Is(err, netlink.ErrExists) {
+		return fmt.Errorf("failed to add IPv6 address: %w", err)
+	}
+	return nil
+}
+
+func (d *Device) configureIPv6Darwin(ip string, prefixLen int) error {
+	cmd := []string{"ifconfig", d.name, "inet6", ip, "prefixlen", fmt.Sprintf("%d", prefixLen), "alias"}
+	if err := exec.Command(cmd[0], cmd[1:]...).Run(); err != nil {
+		return fmt.Errorf("failed to run command %v: %w", cmd, err)
+	}
+	return nil
+}
+
+func (d *Device) setAddressLinux(oldIP, newIP string) error {
+	oldPrefix, err := interfacePrefix(oldIP, d.cidr)
+	if err != nil {
+		return err
+	}
+	newPrefix, err := interfacePrefix(newIP, d.cidr)
+	if err != nil {
+		return err
+	}
+	if err := netlink.DeleteAddress(d.name, oldPrefix); err != nil && !errors.Is(err, netlink.ErrNotFound) {
+		return fmt.Errorf("failed to remove address: %w", err)
 	}
+	if err := netlink.AddAddress(d.name, newPrefix); err != nil && !errors.Is(err, netlink.ErrExists) {
+		return fmt.Errorf("failed to add address: %w", err)
+	}
+	return nil
+}
 
-	for _, cmd := range commands {
-		if err := exec.Command(cmd[0], cmd[1:]...).Run(); err != nil {
-			return fmt.Errorf("failed to run command %v: %w", cmd, err)
-		}
+// interfacePrefix combines ip with the prefix length of network, so the
+// kernel routes the whole VPN range to the interface.
+func interfacePrefix(ip, network string) (netip.Prefix, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	cidr, err := netip.ParsePrefix(network)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid CIDR: %w", err)
+	}
+	return netip.PrefixFrom(addr.Unmap(), cidr.Bits()), nil
+}
+
+func (d *Device) setAddressDarwin(ip string) error {
+	cmd := []string{"ifconfig", d.name, ip, ip, "up"}
+	if err := exec.Command(cmd[0], cmd[1:]...).Run(); err != nil {
+		return fmt.Errorf("failed to run command %v: %w", cmd, err)
 	}
 	return nil
 }
 
+// pollable hands the descriptor to the Go runtime poller, so Close
+// interrupts a pending Read instead of leaving it blocked forever.
+func (d *Device) pollable() error {
+	if err := syscall.SetNonblock(d.fd, true); err != nil {
+		return fmt.Errorf("failed to set TUN device non-blocking: %w", err)
+	}
+	d.file = os.NewFile(uintptr(d.fd), d.name)
+	return nil
+}
 
 func (d *Device) readUnix() ([]byte, error) {
 	buf := make([]byte, 65536)
-	n, err := syscall.Read(d.fd, buf)
+	n, err := d.file.Read(buf)
 	if err != nil {
 		return nil, err
 	}
@@ -67,13 +141,16 @@ func (d *Device) readUnix() ([]byte, error) {
 }
 
 func (d *Device) writeUnix(packet []byte) error {
-	_, err := syscall.Write(d.fd, packet)
+	_, err := d.file.Write(packet)
 	return err
 }
 
 func (d *Device) closeUnix() error {
+	if d.file != nil {
+		return d.file.Close()
+	}
 	if d.fd > 0 {
 		return syscall.Close(d.fd)
 	}
 	return nil
-}
\ No newline at end of file
+}
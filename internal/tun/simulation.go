@@ -2,17 +2,22 @@ package tun
 
 import (
 	"fmt"
+	"log/slog"
 	"net"
 	"time"
+
+	"nghost/internal/logging"
 )
 
 // SimulationDevice provides a mock TUN device for testing
 type SimulationDevice struct {
-	name     string
-	cidr     string
-	mtu      int
-	packets  chan []byte
-	running  bool
+	name    string
+	cidr    string
+	mtu     int
+	packets chan []byte
+	running bool
+	done    chan struct{}
+	log     *slog.Logger
 }
 
 func NewSimulationDevice(name, cidr string, mtu int) (*SimulationDevice, error) {
@@ -27,10 +32,11 @@ func NewSimulationDevice(name, cidr string, mtu int) (*SimulationDevice, error)
 		mtu:     mtu,
 		packets: make(chan []byte, 100),
 		running: true,
+		done:    make(chan struct{}),
+		log:     logging.Logger(logging.TUN).With("device", name),
 	}
 
-	fmt.Printf("🔧 Created simulation TUN device: %s (%s)\n", name, cidr)
-	fmt.Printf("📝 Note: This is a simulation mode - no actual network traffic will be routed\n")
+	sim.log.Warn("created simulation TUN device, no traffic will be routed", "cidr", cidr)
 
 	// Start simulation packet generator
 	go sim.generateSimulationTraffic()
@@ -42,14 +48,16 @@ func (s *SimulationDevice) generateSimulationTraffic() {
 	ticker := time.NewTicker(10 * time.Second)
 	defer ticker.Stop()
 
-	for s.running {
+	for {
 		select {
+		case <-s.done:
+			return
 		case <-ticker.C:
 			// Generate a fake ping packet
 			packet := s.createSimulationPacket()
 			select {
 			case s.packets <- packet:
-				fmt.Printf("📡 Simulation: Generated test packet (%d bytes)\n", len(packet))
+				s.log.Debug("generated test packet", "bytes", len(packet))
 			default:
 				// Channel full, skip
 			}
@@ -60,26 +68,26 @@ func (s *SimulationDevice) generateSimulationTraffic() {
 func (s *SimulationDevice) createSimulationPacket() []byte {
 	// Create a minimal IP packet structure for simulation
 	packet := make([]byte, 60)
-	
+
 	// IP header (simplified)
-	packet[0] = 0x45        // Version (4) + Header Length (5*4=20 bytes)
-	packet[1] = 0x00        // Type of Service
-	packet[2] = 0x00        // Total Length (high byte)
-	packet[3] = 0x3c        // Total Length (low byte) = 60
-	packet[9] = 0x01        // Protocol (ICMP)
-	
+	packet[0] = 0x45 // Version (4) + Header Length (5*4=20 bytes)
+	packet[1] = 0x00 // Type of Service
+	packet[2] = 0x00 // Total Length (high byte)
+	packet[3] = 0x3c // Total Length (low byte) = 60
+	packet[9] = 0x01 // Protocol (ICMP)
+
 	// Source IP: 10.100.0.1
 	packet[12] = 10
 	packet[13] = 100
 	packet[14] = 0
 	packet[15] = 1
-	
+
 	// Destination IP: 10.100.0.2
 	packet[16] = 10
 	packet[17] = 100
 	packet[18] = 0
 	packet[19] = 2
-	
+
 	return packet
 }
 
@@ -87,10 +95,12 @@ func (s *SimulationDevice) Read() ([]byte, error) {
 	if !s.running {
 		return nil, fmt.Errorf("simulation device closed")
 	}
-	
+
 	select {
 	case packet := <-s.packets:
 		return packet, nil
+	case <-s.done:
+		return nil, fmt.Errorf("simulation device closed")
 	case <-time.After(30 * time.Second):
 		// Return timeout to prevent blocking
 		return nil, fmt.Errorf("simulation timeout")
@@ -101,19 +111,22 @@ func (s *SimulationDevice) Write(packet []byte) error {
 	if !s.running {
 		return fmt.Errorf("simulation device closed")
 	}
-	
+
 	if len(packet) > 20 {
 		// Parse destination IP for logging
 		destIP := net.IP(packet[16:20])
-		fmt.Printf("📤 Simulation: Would send packet to %s (%d bytes)\n", destIP, len(packet))
+		s.log.Debug("would send packet", "dst", destIP, "bytes", len(packet))
 	}
-	
+
 	return nil
 }
 
 func (s *SimulationDevice) Close() error {
+	if !s.running {
+		return nil
+	}
 	s.running = false
-	close(s.packets)
-	fmt.Printf("🔧 Closed simulation TUN device: %s\n", s.name)
+	close(s.done)
+	s.log.Debug("closed simulation TUN device")
 	return nil
-}
\ No newline at end of file
+}
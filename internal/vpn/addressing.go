@@ -0,0 +1,180 @@
+package vpn
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"nghost/internal/ipam"
+	"nghost/internal/nkn"
+)
+
+const leaseTimeout = 10 * time.Second
+
+var ErrLeaseUnauthorized = errors.New("lease requests need vpn.networkKey or vpn.allowedPeers")
+
+func (e *Engine) localIP() net.IP {
+	e.myIPMu.RLock()
+	defer e.myIPMu.RUnlock()
+	return e.myIP
+}
+
+func (e *Engine) setLocalIP(ip net.IP) {
+	e.myIPMu.Lock()
+	defer e.myIPMu.Unlock()
+	e.myIP = ip
+}
+
+func (e *Engine) localIP6() net.IP {
+	e.myIPMu.RLock()
+	defer e.myIPMu.RUnlock()
+	return e.myIP6
+}
+
+func (e *Engine) setLocalIP6(ip net.IP) {
+	e.myIPMu.Lock()
+	defer e.myIPMu.Unlock()
+	e.myIP6 = ip
+}
+
+// poolFor returns the address pool of ip's family, or nil if that family is
+// not enabled.
+func (e *Engine) poolFor(ip net.IP) *ipam.Pool {
+	if ip.To4() != nil {
+		return e.pool
+	}
+	return e.pool6
+}
+
+// allocateAddress picks our VPN IP: a static assignment wins, then a lease
+// from the configured coordinator, and otherwise the deterministic address
+// derived from our NKN address.
+func (e *Engine) allocateAddress() (net.IP, error) {
+	self := e.transport.GetAddress()
+	if ip := e.pool.Static(self); ip != nil {
+		return ip, nil
+	}
+
+	coordinator := e.config.LeaseCoordinator
+	if coordinator == self && !e.auth.Enabled() {
+		e.log.Warn("not handing out leases", "err", ErrLeaseUnauthorized)
+	}
+	if coordinator != "" && coordinator != self {
+		ip, err := e.requestLease(coordinator)
+		if err == nil {
+			if err := e.pool.Claim(self, ip); err != nil {
+				return nil, err
+			}
+			e.log.Info("leased VPN IP from coordinator", "ip", ip)
+			return ip, nil
+		}
+		e.log.Warn("lease request failed, using derived address", "err", err)
+	}
+
+	return e.pool.Allocate(self)
+}
+
+func (e *Engine) requestLease(coordinator string) (net.IP, error) {
+	self := e.transport.GetAddress()
+	candidate, err := e.pool.Allocate(self)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := e.transport.RequestLease(coordinator, candidate.String()); err != nil {
+		return nil, err
+	}
+
+	select {
+	case ip := <-e.leases:
+		return ip, nil
+	case <-time.After(leaseTimeout):
+		return nil, fmt.Errorf("no lease from %s after %s", nkn.ShortAddress(coordinator), leaseTimeout)
+	case <-e.ctx.Done():
+		return nil, e.ctx.Err()
+	}
+}
+
+// HandleLeaseRequest runs on the coordinator. The requested address is granted
+// if free, otherwise the next free address for src is handed out. Every NKN
+// address holds at most one lease, but anyone can create addresses, so leases
+// are only handed to members of an authorized network.
+func (e *Engine) HandleLeaseRequest(src, requested string) (string, error) {
+	if e.config.LeaseCoordinator != e.transport.GetAddress() {
+		return "", errors.New("not a lease coordinator")
+	}
+	if !e.auth.Enabled() {
+		return "", ErrLeaseUnauthorized
+	}
+
+	if ip := net.ParseIP(requested); ip != nil {
+		if err := e.pool.Claim(src, ip); err == nil {
+			return ip.String(), nil
+		}
+	}
+
+	ip, err := e.pool.Allocate(src)
+	if err != nil {
+		return "", err
+	}
+	e.log.Info("leased address", "ip", ip, "peer", src)
+	return ip.String(), nil
+}
+
+func (e *Engine) HandleLeaseGrant(src, address string) {
+	if src != e.config.LeaseCoordinator {
+		return
+	}
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return
+	}
+	select {
+	case e.leases <- ip:
+	default:
+	}
+}
+
+// claimPeerAddress records the address a peer announced. When the peer
+// announces our own IPv4 address, the node with the lower NKN address keeps
+// it and the other one moves to a new address. IPv6 conflicts are only
+// reported, as hashing into a /64 makes them practically impossible.
+func (e *Engine) claimPeerAddress(nknAddr string, ip net.IP) error {
+	pool := e.poolFor(ip)
+	if pool == nil {
+		return fmt.Errorf("address family of %s is not enabled", ip)
+	}
+
+	err := pool.Claim(nknAddr, ip)
+	if err == nil || !errors.Is(err, ipam.ErrConflict) {
+		return err
+	}
+
+	self := e.transport.GetAddress()
+	if pool != e.pool || !ip.Equal(e.localIP()) || nknAddr > self || e.pool.Static(self) != nil {
+		e.log.Warn("address conflict", "peer", nknAddr, "ip", ip, "err", err)
+		return err
+	}
+
+	e.log.Warn("address conflict with our IP, moving to a new address", "peer", nknAddr, "ip", ip)
+	e.pool.Release(self)
+	if err := e.pool.Claim(nknAddr, ip); err != nil {
+		return err
+	}
+
+	newIP, err := e.pool.Allocate(self)
+	if err != nil {
+		return fmt.Errorf("failed to allocate new address: %w", err)
+	}
+	if e.tunDevice != nil {
+		if err := e.tunDevice.SetAddress(newIP.String()); err != nil {
+			return fmt.Errorf("failed to change interface address: %w", err)
+		}
+	}
+	e.setLocalIP(newIP)
+	e.log.Info("new VPN IP", "ip", newIP)
+
+	go e.announce()
+	return nil
+}
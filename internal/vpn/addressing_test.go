@@ -0,0 +1,104 @@
+package vpn
+
+import (
+	"errors"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"nghost/internal/config"
+	"nghost/internal/nkn"
+)
+
+// fakeTransport is a transport that records lease requests and never
+// answers them, and reports a fixed set of exit nodes.
+type fakeTransport struct {
+	addr        string
+	requests    chan string
+	exits       []nkn.Peer
+	exitLookups atomic.Int32
+}
+
+func (t *fakeTransport) GetAddress() string              { return t.addr }
+func (t *fakeTransport) SendPacket(string, []byte) error { return nil }
+func (t *fakeTransport) GetPeers() map[string]nkn.Peer   { return nil }
+func (t *fakeTransport) FindExitNodes() []nkn.Peer {
+	t.exitLookups.Add(1)
+	return t.exits
+}
+func (t *fakeTransport) AnnouncePeer(string, string, []string, bool) error { return nil }
+func (t *fakeTransport) SetVPNEngine(nkn.VPNEngine)                        {}
+func (t *fakeTransport) SetAuthorizer(*nkn.Authorizer)                     {}
+func (t *fakeTransport) OnPeerEvent(func(nkn.PeerEvent))                   {}
+func (t *fakeTransport) StartDiscovery(string)                             {}
+func (t *fakeTransport) OnEndpoint(func(netip.Addr))                       {}
+func (t *fakeTransport) Goodbye()                                          {}
+
+func (t *fakeTransport) RequestLease(coordinator, requested string) error {
+	t.requests <- requested
+	return nil
+}
+
+func newFakeEngine(t *testing.T, cfg config.VPNConfig, addr string) (*Engine, *fakeTransport) {
+	t.Helper()
+	cfg.CIDR = "10.100.0.0/24"
+	transport := &fakeTransport{addr: addr, requests: make(chan string, 1)}
+	e, err := NewSimulatedEngine(cfg, transport, func(name, address, cidr string, mtu int) (Device, error) {
+		return nil, errors.New("no device")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e, transport
+}
+
+func TestLeaseRequestsNeedAuthorization(t *testing.T) {
+	open, _ := newFakeEngine(t, config.VPNConfig{LeaseCoordinator: "coordinator"}, "coordinator")
+	if _, err := open.HandleLeaseRequest("peer", "10.100.0.5"); !errors.Is(err, ErrLeaseUnauthorized) {
+		t.Fatalf("open network: got %v, want ErrLeaseUnauthorized", err)
+	}
+
+	closed, _ := newFakeEngine(t, config.VPNConfig{LeaseCoordinator: "coordinator", NetworkKey: "secret"}, "coordinator")
+	ip, err := closed.HandleLeaseRequest("peer", "10.100.0.5")
+	if err != nil || ip != "10.100.0.5" {
+		t.Fatalf("got %q, %v, want 10.100.0.5", ip, err)
+	}
+}
+
+func TestStartDaemonWaitsForLeaseUnlocked(t *testing.T) {
+	e, transport := newFakeEngine(t, config.VPNConfig{LeaseCoordinator: "coordinator", NetworkKey: "secret"}, "node")
+
+	started := make(chan error, 1)
+	go func() { started <- e.StartDaemon() }()
+	select {
+	case <-transport.requests:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no lease requested")
+	}
+
+	status := make(chan Status, 1)
+	go func() { status <- e.Status() }()
+	select {
+	case s := <-status:
+		if s.Running {
+			t.Fatal("running before the lease arrived")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("status blocked while waiting for the lease")
+	}
+	if err := e.StartDaemon(); err == nil {
+		t.Fatal("second start succeeded")
+	}
+
+	// Stopping abandons the lease request instead of waiting it out
+	e.Stop()
+	select {
+	case err := <-started:
+		if err == nil {
+			t.Fatal("start succeeded after stop")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("start still waiting for the lease after stop")
+	}
+}
@@ -0,0 +1,158 @@
+package vpn
+
+import (
+	"errors"
+	"net/netip"
+	"sync"
+
+	"nghost/internal/netlink"
+)
+
+// bypassRoutes send destinations through the default gateway the host had
+// before the engine started, around the routes that lead into the TUN
+// device. They keep the transport out of the tunnel and carry the
+// destinations excluded by split tunneling.
+type bypassRoutes struct {
+	mu       sync.Mutex
+	gateway  netlink.Route
+	gateway6 netlink.Route // unset if the host has no IPv6 default route
+	routes   map[netip.Prefix]*bypassRoute
+	stopped  bool
+}
+
+type bypassRoute struct {
+	route netlink.Route
+	refs  int
+}
+
+// setupBypass looks up the default gateways and pins the addresses the
+// transport talks to. Endpoints it already uses are reported right away,
+// before any route into the tunnel exists.
+func (e *Engine) setupBypass() error {
+	if e.bypass != nil {
+		return nil
+	}
+	gateway, err := e.getDefaultGateway(netip.Addr{})
+	if err != nil {
+		return err
+	}
+	e.bypass = &bypassRoutes{gateway: gateway, routes: make(map[netip.Prefix]*bypassRoute)}
+	if e.localIP6() != nil {
+		if gateway6, err := e.getDefaultGateway(netip.IPv6Unspecified()); err == nil {
+			e.bypass.gateway6 = gateway6
+		}
+	}
+
+	e.onStop("bypass routes", e.removeBypassRoutes)
+
+	e.endpointsMu.Lock()
+	defer e.endpointsMu.Unlock()
+	for addr := range e.endpoints {
+		e.addBypassRoute(netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	e.bypassEndpoints = true
+	return nil
+}
+
+// addEndpoint records an address the transport talks to and, once bypass
+// routes are set up, pins it to the original default gateway.
+func (e *Engine) addEndpoint(addr netip.Addr) {
+	e.endpointsMu.Lock()
+	defer e.endpointsMu.Unlock()
+	e.endpoints[addr] = true
+	if e.bypassEndpoints {
+		e.addBypassRoute(netip.PrefixFrom(addr, addr.BitLen()))
+	}
+}
+
+// transportEndpoints returns the addresses the transport talked to so far.
+func (e *Engine) transportEndpoints() []netip.Addr {
+	e.endpointsMu.Lock()
+	defer e.endpointsMu.Unlock()
+	addrs := make([]netip.Addr, 0, len(e.endpoints))
+	for addr := range e.endpoints {
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
+
+// addBypassRoute routes dst through the original default gateway if traffic
+// to it would otherwise follow the default route or enter the tunnel.
+// Destinations on the local network keep their routes.
+func (e *Engine) addBypassRoute(dst netip.Prefix) {
+	b := e.bypass
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.stopped {
+		return
+	}
+	if existing, ok := b.routes[dst]; ok {
+		existing.refs++
+		return
+	}
+
+	gateway := b.gateway
+	if dst.Addr().Is6() {
+		gateway = b.gateway6
+	}
+	if !gateway.Gateway.IsValid() {
+		return
+	}
+
+	current, err := netlink.LookupRoute(dst.Addr())
+	if err != nil {
+		e.log.Warn("failed to look up route", "dst", dst, "err", err)
+		return
+	}
+	viaDefault := current.Gateway == gateway.Gateway && current.Link == gateway.Link
+	if !viaDefault && current.Link != e.interfaceName() {
+		return
+	}
+
+	route := netlink.Route{Dst: dst, Gateway: gateway.Gateway, Link: gateway.Link}
+	if err := netlink.AddRoute(route); err != nil {
+		if !errors.Is(err, netlink.ErrExists) {
+			e.log.Warn("failed to add bypass route", "route", route, "err", err)
+		}
+		return
+	}
+	b.routes[dst] = &bypassRoute{route: route, refs: 1}
+	e.log.Debug("added bypass route", "route", route)
+}
+
+// removeBypassRoute drops a reference to the bypass route for dst and
+// removes the route with the last one.
+func (e *Engine) removeBypassRoute(dst netip.Prefix) {
+	b := e.bypass
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	existing, ok := b.routes[dst]
+	if !ok {
+		return
+	}
+	if existing.refs--; existing.refs > 0 {
+		return
+	}
+	delete(b.routes, dst)
+	if err := netlink.DeleteRoute(existing.route); err != nil && !errors.Is(err, netlink.ErrNotFound) {
+		e.log.Warn("failed to remove bypass route", "route", existing.route, "err", err)
+	}
+}
+
+// removeBypassRoutes removes all bypass routes. Endpoints reported later,
+// while the transport outlives the engine, are no longer pinned.
+func (e *Engine) removeBypassRoutes() error {
+	b := e.bypass
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.stopped = true
+
+	var errs []error
+	for dst, existing := range b.routes {
+		if err := netlink.DeleteRoute(existing.route); err != nil && !errors.Is(err, netlink.ErrNotFound) {
+			errs = append(errs, err)
+		}
+		delete(b.routes, dst)
+	}
+	return errors.Join(errs...)
+}
@@ -1,77 +1,284 @@
 package vpn
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
 	"net"
-	"os/exec"
+	"net/netip"
 	"sync"
 	"time"
 
 	"nghost/internal/config"
+	"nghost/internal/firewall"
+	"nghost/internal/ipam"
+	"nghost/internal/logging"
 	"nghost/internal/nkn"
 	"nghost/internal/tun"
 )
 
+// Transport is the overlay the engine sends packets and control messages
+// over. It is implemented by *nkn.Client on any of its carriers.
+type Transport interface {
+	GetAddress() string
+	SendPacket(dest string, packet []byte) error
+	GetPeers() map[string]nkn.Peer
+	FindExitNodes() []nkn.Peer
+	AnnouncePeer(ipAddress, ipv6Address string, routes []string, exitNode bool) error
+	RequestLease(coordinator, requested string) error
+	SetVPNEngine(engine nkn.VPNEngine)
+	SetAuthorizer(auth *nkn.Authorizer)
+	OnPeerEvent(fn func(nkn.PeerEvent))
+	StartDiscovery(topic string)
+	OnEndpoint(fn func(netip.Addr))
+	Goodbye()
+}
+
+// Device is the interface packets are read from and written to. It is
+// implemented by *tun.Device.
+type Device interface {
+	Read() ([]byte, error)
+	Write(packet []byte) error
+	GetName() string
+	ConfigureIPv6(address, cidr string) error
+	SetAddress(address string) error
+	Close() error
+}
+
+// DeviceFactory creates the device of an engine once its address is known.
+type DeviceFactory func(name, address, cidr string, mtu int) (Device, error)
+
 type Engine struct {
 	config     *config.VPNConfig
-	nknClient  *nkn.Client
-	tunDevice  *tun.Device
-	routes     map[string]string // CIDR -> NKN address mapping
+	transport  Transport
+	auth       *nkn.Authorizer
+	tunDevice  Device
+	newDevice  DeviceFactory
+	host       bool // manage host routes, forwarding and NAT
+	routes     *routeTable
+	subnets    map[string]map[netip.Prefix]bool // NKN address -> advertised subnets
 	routesMu   sync.RWMutex
+	accepted   []netip.Prefix // limits the accepted peer subnets if set
 	running    bool
+	starting   bool
 	runningMu  sync.RWMutex
 	isExitNode bool
 	myIP       net.IP
+	myIP6      net.IP
+	myIPMu     sync.RWMutex
+	network    *net.IPNet
+	network6   *net.IPNet
+	pool       *ipam.Pool
+	pool6      *ipam.Pool
+	leases     chan net.IP
+	exits      *exitSelector
+	drops      dropStats
+	counters   peerCounters
+	log        *slog.Logger
+	logLimit   *logging.Limiter // for errors that repeat per packet
+
+	firewall      firewall.Firewall
+	firewallRules firewall.Ruleset
+	firewallMu    sync.Mutex
+
+	// OS routes into the TUN device this engine added, with the number of
+	// users of each
+	systemRoutes   map[netip.Prefix]*systemRoute
+	systemRoutesMu sync.Mutex
+
+	// Addresses the transport talks to outside the VPN
+	endpoints       map[netip.Addr]bool
+	bypassEndpoints bool // pin endpoints with bypass routes
+	endpointsMu     sync.Mutex
+
+	bypass *bypassRoutes // nil unless full-tunnel mode or split tunneling is on
+	split  *splitTunnel
+
+	// Background goroutines stop when ctx is cancelled; changes to the host
+	// are reverted by the cleanup actions
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+	cleanup   []undoAction
+	cleanupMu sync.Mutex
+	stopOnce  sync.Once
 }
 
-func NewEngine(cfg config.VPNConfig, nknClient *nkn.Client) (*Engine, error) {
-	return &Engine{
+// Status is a snapshot of the engine state reported on the control socket.
+type Status struct {
+	Running    bool         `json:"running"`
+	Interface  string       `json:"interface"`
+	CIDR       string       `json:"cidr"`
+	VPNIP      string       `json:"vpnIP"`
+	VPNIP6     string       `json:"vpnIP6,omitempty"`
+	ExitNode   bool         `json:"exitNode"`
+	NKNAddress string       `json:"nknAddress"`
+	Peers      int          `json:"peers"`
+	Routes     int          `json:"routes"`
+	ExitNodes  []string     `json:"exitNodes"`
+	ActiveExit string       `json:"activeExit,omitempty"`
+	Dropped    DropCounters `json:"dropped"`
+}
+
+func NewEngine(cfg config.VPNConfig, transport Transport) (*Engine, error) {
+	auth := nkn.NewAuthorizer(cfg.AllowedPeers, cfg.NetworkKey)
+	transport.SetAuthorizer(auth)
+
+	pool, err := ipam.NewPool(cfg.CIDR, cfg.StaticIPs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address configuration: %w", err)
+	}
+	// Only peer leases are renewed by announcements; ours must not expire
+	// and be handed to someone else
+	pool.Pin(transport.GetAddress())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	e := &Engine{
+		ctx:       ctx,
+		cancel:    cancel,
 		config:    &cfg,
-		nknClient: nknClient,
-		routes:    make(map[string]string),
-	}, nil
+		transport: transport,
+		auth:      auth,
+		newDevice: newTUNDevice,
+		host:      true,
+		routes:    newRouteTable(),
+		subnets:   make(map[string]map[netip.Prefix]bool),
+		network:   pool.Network(),
+		endpoints: make(map[netip.Addr]bool),
+		pool:      pool,
+		leases:    make(chan net.IP, 1),
+		exits:     newExitSelector(),
+		log:       logging.Logger(logging.VPN),
+		logLimit:  logging.NewLimiter(10 * time.Second),
+	}
+
+	if cfg.CIDR6 != "" {
+		pool6, err := ipam.NewPool(cfg.CIDR6, nil)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IPv6 configuration: %w", err)
+		}
+		if pool6.Network().IP.To4() != nil {
+			return nil, fmt.Errorf("cidr6 %s is not an IPv6 network", cfg.CIDR6)
+		}
+		pool6.Pin(transport.GetAddress())
+		e.pool6 = pool6
+		e.network6 = pool6.Network()
+	}
+
+	for _, cidr := range cfg.AcceptedRoutes {
+		prefix, err := parsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid accepted route: %w", err)
+		}
+		e.accepted = append(e.accepted, prefix)
+	}
+	e.systemRoutes = make(map[netip.Prefix]*systemRoute)
+
+	return e, nil
+}
+
+// NewSimulatedEngine creates an engine that exchanges packets with devices
+// from newDevice instead of a TUN interface and leaves the host's routing
+// table, IP forwarding and firewall alone, so several engines can run in one
+// process.
+func NewSimulatedEngine(cfg config.VPNConfig, transport Transport, newDevice DeviceFactory) (*Engine, error) {
+	e, err := NewEngine(cfg, transport)
+	if err != nil {
+		return nil, err
+	}
+	e.newDevice = newDevice
+	e.host = false
+	return e, nil
+}
+
+func newTUNDevice(name, address, cidr string, mtu int) (Device, error) {
+	return tun.NewDevice(name, address, cidr, mtu)
 }
 
 func (e *Engine) StartDaemon() error {
 	e.runningMu.Lock()
-	defer e.runningMu.Unlock()
-
-	if e.running {
+	if e.running || e.starting {
+		e.runningMu.Unlock()
 		return fmt.Errorf("VPN engine already running")
 	}
+	e.starting = true
+	e.runningMu.Unlock()
+
+	// Link transport with VPN engine, lease grants arrive through it
+	e.transport.SetVPNEngine(e)
+	e.transport.OnPeerEvent(e.handlePeerEvent)
+	e.transport.OnEndpoint(e.addEndpoint)
+	if e.config.NetworkName != "" {
+		e.transport.StartDiscovery(nkn.DiscoveryTopic(e.config.NetworkName, e.config.NetworkKey))
+	}
+
+	// Waiting for a lease can take a while; status queries and Stop must not
+	// block on it
+	myIP, err := e.allocateAddress()
+
+	e.runningMu.Lock()
+	defer e.runningMu.Unlock()
+	e.starting = false
+	if err != nil {
+		return fmt.Errorf("failed to allocate VPN address: %w", err)
+	}
+	if e.ctx.Err() != nil {
+		return fmt.Errorf("VPN engine stopped while starting")
+	}
+	e.setLocalIP(myIP)
 
 	// Create TUN interface
-	tunDevice, err := tun.NewDevice(e.config.InterfaceName, e.config.CIDR, e.config.MTU)
+	tunDevice, err := e.newDevice(e.config.InterfaceName, myIP.String(), e.config.CIDR, e.config.MTU)
 	if err != nil {
 		return fmt.Errorf("failed to create TUN device: %w", err)
 	}
 	e.tunDevice = tunDevice
+	e.onStop("peer subnet routes", e.withdrawSubnets)
 
-	// Set our IP address based on role
-	_, network, _ := net.ParseCIDR(e.config.CIDR)
-	e.myIP = make(net.IP, len(network.IP))
-	copy(e.myIP, network.IP)
-	
-	if e.isExitNode {
-		e.myIP[len(e.myIP)-1] = 1 // Exit nodes use .1
-	} else {
-		// Clients get dynamic IP based on their NKN address
-		e.myIP[len(e.myIP)-1] = e.calculateClientIP()
+	if err := e.setupKillSwitch(); err != nil {
+		return fmt.Errorf("failed to enable kill switch: %w", err)
+	}
+
+	if e.pool6 != nil {
+		myIP6, err := e.pool6.Allocate(e.transport.GetAddress())
+		if err != nil {
+			return fmt.Errorf("failed to allocate VPN IPv6 address: %w", err)
+		}
+		if err := tunDevice.ConfigureIPv6(myIP6.String(), e.config.CIDR6); err != nil {
+			e.log.Warn("failed to configure IPv6, continuing with IPv4 only", "err", err)
+		} else {
+			e.setLocalIP6(myIP6)
+		}
+	}
+
+	if err := e.setupFullTunnel(); err != nil {
+		return fmt.Errorf("failed to set up full-tunnel routes: %w", err)
+	}
+	if err := e.setupSplitTunnel(); err != nil {
+		return fmt.Errorf("failed to set up split tunneling: %w", err)
 	}
 
-	// Link NKN client with VPN engine
-	e.nknClient.SetVPNEngine(e)
+	if err := e.setupSubnetRouter(); err != nil {
+		return fmt.Errorf("failed to set up subnet routing: %w", err)
+	}
 
 	// Start packet processing
-	go e.processPackets()
+	e.goroutine(e.processPackets)
 
 	// Start peer announcements
-	go e.announcePeer()
+	e.goroutine(e.announcePeer)
+
+	if !e.isExitNode {
+		e.goroutine(e.monitorExitNodes)
+	}
 
 	e.running = true
-	fmt.Printf("NGhost VPN started on interface %s (%s)\n", e.tunDevice.GetName(), e.config.CIDR)
-	fmt.Printf("NKN address: %s\n", e.nknClient.GetAddress())
-	fmt.Printf("VPN IP: %s\n", e.myIP.String())
+	args := []any{"interface", e.tunDevice.GetName(), "cidr", e.config.CIDR,
+		"address", e.transport.GetAddress(), "ip", myIP.String()}
+	if ip6 := e.localIP6(); ip6 != nil {
+		args = append(args, "ip6", ip6.String())
+	}
+	e.log.Info("VPN started", args...)
 
 	return nil
 }
@@ -95,10 +302,10 @@ func (e *Engine) StartExitNode() error {
 
 	// Set up default routing
 	if err := e.setupDefaultRoute(); err != nil {
-		fmt.Printf("Warning: failed to setup default route: %v\n", err)
+		e.log.Warn("failed to set up default route", "err", err)
 	}
 
-	fmt.Println("Configured as exit node - forwarding traffic to internet")
+	e.log.Info("configured as exit node, forwarding traffic to the internet")
 	return nil
 }
 
@@ -106,86 +313,186 @@ func (e *Engine) processPackets() {
 	for {
 		packet, err := e.tunDevice.Read()
 		if err != nil {
+			if e.ctx.Err() != nil {
+				return
+			}
+			tunReadErrors.Inc()
 			continue
 		}
+		tunRead.add(packet)
+		tunReadSize.Observe(float64(len(packet)))
 
 		// Parse destination IP from packet
-		if len(packet) < 20 {
+		destIP := packetDestination(packet)
+		if destIP == nil {
 			continue
 		}
 
-		destIP := net.IP(packet[16:20])
-		
-		// Check if destination is in our VPN network
-		_, vpnNetwork, _ := net.ParseCIDR(e.config.CIDR)
-		if vpnNetwork.Contains(destIP) {
-			// Find peer route
-			destAddr := e.findRoute(destIP)
-			if destAddr != "" {
-				// Forward to NKN peer
-				if err := e.nknClient.SendPacket(destAddr, packet); err != nil {
-					fmt.Printf("Failed to send packet via NKN: %v\n", err)
-				}
+		// Peer addresses and subnets advertised by peers
+		route, found := e.findRoute(destIP)
+		if found && route.Kind == RoutePeer {
+			// Forward to NKN peer
+			if err := e.sendPacket(route.Peer, packet); err != nil {
+				e.logLimit.Log(e.log, slog.LevelWarn, "send", "failed to send packet", "peer", route.Peer, "err", err)
 			}
+		} else if e.inVPN(destIP) {
+			// Unknown peer in our VPN network
+			peerMisses.Inc()
+			continue
 		} else if e.isExitNode {
 			// Forward to internet (handled by system routing)
 			continue
+		} else if !e.tunnelled(route, found) {
+			// Kept out of the tunnel by split tunneling
+			splitMisses.Inc()
+			continue
 		} else {
 			// Find exit node for internet traffic
-			exitNodes := e.nknClient.FindExitNodes()
-			if len(exitNodes) > 0 {
-				// Use first available exit node
-				if err := e.nknClient.SendPacket(exitNodes[0].Address, packet); err != nil {
-					fmt.Printf("Failed to send packet via exit node: %v\n", err)
-				}
+			exitAddr := e.selectExitNode(packet)
+			if exitAddr == "" {
+				exitMisses.Inc()
+				continue
 			}
+			if err := e.sendPacket(exitAddr, packet); err != nil {
+				e.logLimit.Log(e.log, slog.LevelWarn, "send exit", "failed to send packet via exit node", "exit", exitAddr, "err", err)
+				continue
+			}
+			e.counters.get(exitAddr, countExit).add(packet)
 		}
 	}
 }
 
-func (e *Engine) findRoute(ip net.IP) string {
+// sendPacket sends packet to the peer dest and counts it.
+func (e *Engine) sendPacket(dest string, packet []byte) error {
+	if err := e.transport.SendPacket(dest, packet); err != nil {
+		sendFailures.Inc()
+		return err
+	}
+	e.counters.get(dest, countTx).add(packet)
+	return nil
+}
+
+// findRoute returns the best route for ip, using longest-prefix match.
+func (e *Engine) findRoute(ip net.IP) (Route, bool) {
+	addr, ok := addrFromIP(ip)
+	if !ok {
+		return Route{}, false
+	}
+
 	e.routesMu.RLock()
 	defer e.routesMu.RUnlock()
+	return e.routes.lookup(addr)
+}
 
-	for cidr, addr := range e.routes {
-		_, network, err := net.ParseCIDR(cidr)
-		if err != nil {
-			continue
-		}
-		if network.Contains(ip) {
-			return addr
-		}
+func (e *Engine) AddRoute(cidr, nknAddr string) error {
+	return e.AddRouteMetric(cidr, nknAddr, DefaultRouteMetric)
+}
+
+// AddRouteMetric adds a route with an explicit metric. When several peers
+// route the same prefix, the one with the lowest metric is used.
+func (e *Engine) AddRouteMetric(cidr, nknAddr string, metric int) error {
+	prefix, err := parsePrefix(cidr)
+	if err != nil {
+		return err
 	}
-	return ""
+
+	e.routesMu.Lock()
+	defer e.routesMu.Unlock()
+	e.routes.insert(Route{Prefix: prefix, Peer: nknAddr, Metric: metric})
+	return nil
 }
 
-func (e *Engine) AddRoute(cidr, nknAddr string) error {
+// RemoveRoute removes the route for cidr via nknAddr, or every peer route
+// for cidr if nknAddr is empty.
+func (e *Engine) RemoveRoute(cidr, nknAddr string) error {
+	prefix, err := parsePrefix(cidr)
+	if err != nil {
+		return err
+	}
+
 	e.routesMu.Lock()
 	defer e.routesMu.Unlock()
-	e.routes[cidr] = nknAddr
+	e.routes.remove(prefix, nknAddr)
 	return nil
 }
 
+func (e *Engine) Routes() []Route {
+	e.routesMu.RLock()
+	defer e.routesMu.RUnlock()
+	return e.routes.all()
+}
+
+func (e *Engine) Status() Status {
+	e.runningMu.RLock()
+	defer e.runningMu.RUnlock()
+
+	status := Status{
+		Running:    e.running,
+		Interface:  e.config.InterfaceName,
+		CIDR:       e.config.CIDR,
+		ExitNode:   e.isExitNode,
+		NKNAddress: e.transport.GetAddress(),
+		Peers:      len(e.transport.GetPeers()),
+		Routes:     e.routeCount(),
+		ExitNodes:  []string{},
+		Dropped:    e.drops.snapshot(),
+		ActiveExit: e.activeExitNode(),
+	}
+	if e.tunDevice != nil {
+		status.Interface = e.tunDevice.GetName()
+	}
+	if ip := e.localIP(); ip != nil {
+		status.VPNIP = ip.String()
+	}
+	if ip6 := e.localIP6(); ip6 != nil {
+		status.VPNIP6 = ip6.String()
+	}
+	for _, peer := range e.transport.FindExitNodes() {
+		status.ExitNodes = append(status.ExitNodes, peer.Address)
+	}
+	return status
+}
+
+func (e *Engine) routeCount() int {
+	e.routesMu.RLock()
+	defer e.routesMu.RUnlock()
+	return e.routes.len()
+}
+
+// inVPN reports whether ip belongs to the VPN's IPv4 or IPv6 network.
+func (e *Engine) inVPN(ip net.IP) bool {
+	if e.network.Contains(ip) {
+		return true
+	}
+	return e.network6 != nil && e.network6.Contains(ip)
+}
+
 func (e *Engine) InjectPacket(packet []byte) error {
 	if e.tunDevice == nil {
 		return fmt.Errorf("TUN device not initialized")
 	}
-	return e.tunDevice.Write(packet)
+	if err := e.tunDevice.Write(packet); err != nil {
+		tunWriteErrors.Inc()
+		return err
+	}
+	tunWrite.add(packet)
+	tunWriteSize.Observe(float64(len(packet)))
+	return nil
 }
 
 func (e *Engine) announcePeer() {
 	// Wait for interface to be fully configured
-	time.Sleep(2 * time.Second)
-	
+	select {
+	case <-e.ctx.Done():
+		return
+	case <-time.After(2 * time.Second):
+	}
+
 	// Initial announcement
-	if err := e.nknClient.AnnouncePeer(e.myIP.String(), e.isExitNode); err != nil {
-		fmt.Printf("❌ Failed initial peer announcement: %v\n", err)
+	if err := e.announce(); err != nil {
+		e.log.Error("failed initial peer announcement", "err", err)
 	} else {
-		if e.isExitNode {
-			fmt.Printf("📢 Announced as exit node with IP %s\n", e.myIP.String())
-		} else {
-			fmt.Printf("📢 Announced peer with IP %s\n", e.myIP.String())
-		}
+		e.log.Info("announced", "ip", e.localIP().String(), "exit", e.isExitNode)
 	}
 
 	ticker := time.NewTicker(30 * time.Second)
@@ -193,49 +500,85 @@ func (e *Engine) announcePeer() {
 
 	for {
 		select {
+		case <-e.ctx.Done():
+			return
 		case <-ticker.C:
-			if err := e.nknClient.AnnouncePeer(e.myIP.String(), e.isExitNode); err != nil {
-				fmt.Printf("❌ Failed to announce peer: %v\n", err)
+			if err := e.announce(); err != nil {
+				e.log.Error("failed to announce", "err", err)
 			} else {
-				fmt.Printf("📢 Peer announcement sent (exit_node=%v)\n", e.isExitNode)
+				e.log.Debug("announced", "ip", e.localIP().String(), "exit", e.isExitNode)
 			}
 		}
 	}
 }
 
+func (e *Engine) announce() error {
+	var ip6 string
+	if myIP6 := e.localIP6(); myIP6 != nil {
+		ip6 = myIP6.String()
+	}
+	return e.transport.AnnouncePeer(e.localIP().String(), ip6, e.advertisedRoutes(), e.isExitNode)
+}
+
 func (e *Engine) enableIPForwarding() error {
-	return exec.Command("sysctl", "-w", "net.ipv4.ip_forward=1").Run()
+	if !e.host {
+		return nil
+	}
+	if err := e.setSysctl("net.ipv4.ip_forward", "1"); err != nil {
+		return err
+	}
+	if e.pool6 != nil {
+		return e.setSysctl("net.ipv6.conf.all.forwarding", "1")
+	}
+	return nil
 }
 
 func (e *Engine) setupNAT() error {
-	// Get the actual interface name
-	interfaceName := e.config.InterfaceName
-	if e.tunDevice != nil {
-		interfaceName = e.tunDevice.GetName()
+	if !e.host {
+		return nil
 	}
 
-	// Set up iptables rules for NAT
-	commands := [][]string{
-		{"iptables", "-t", "nat", "-A", "POSTROUTING", "-s", e.config.CIDR, "-j", "MASQUERADE"},
-		{"iptables", "-A", "FORWARD", "-i", interfaceName, "-j", "ACCEPT"},
-		{"iptables", "-A", "FORWARD", "-o", interfaceName, "-j", "ACCEPT"},
+	network, err := netip.ParsePrefix(e.config.CIDR)
+	if err != nil {
+		return fmt.Errorf("invalid CIDR: %w", err)
+	}
+	egress, err := firewall.EgressInterface(netip.Addr{})
+	if err != nil {
+		return fmt.Errorf("failed to detect egress interface: %w", err)
 	}
 
-	fmt.Printf("🔧 Setting up NAT for exit node...\n")
-	for _, cmd := range commands {
-		fmt.Printf("🔧 Running: %v\n", cmd)
-		execCmd := exec.Command(cmd[0], cmd[1:]...)
-		output, err := execCmd.CombinedOutput()
+	// Masquerade VPN traffic leaving through the egress interface and let
+	// the kernel forward it there, and only replies back to the TUN device
+	interfaceName := e.interfaceName()
+	rules := firewall.Ruleset{
+		Masquerade: []firewall.Masquerade{{Source: network, OutInterface: egress}},
+		Forward: []firewall.Forward{
+			{InInterface: interfaceName, OutInterface: egress},
+			{InInterface: egress, OutInterface: interfaceName, Established: true},
+		},
+	}
+	if e.pool6 != nil {
+		network6, err := netip.ParsePrefix(e.config.CIDR6)
 		if err != nil {
-			fmt.Printf("⚠️  iptables command failed: %v\n", err)
-			fmt.Printf("⚠️  Output: %s\n", string(output))
-			// Continue with other rules even if one fails
-		} else {
-			fmt.Printf("✅ iptables rule added\n")
+			return fmt.Errorf("invalid IPv6 CIDR: %w", err)
+		}
+		egress6, err := firewall.EgressInterface(netip.IPv6Unspecified())
+		if err != nil {
+			egress6 = egress
+		}
+		rules.Masquerade = append(rules.Masquerade, firewall.Masquerade{Source: network6, OutInterface: egress6})
+		if egress6 != egress {
+			rules.Forward = append(rules.Forward,
+				firewall.Forward{InInterface: interfaceName, OutInterface: egress6},
+				firewall.Forward{InInterface: egress6, OutInterface: interfaceName, Established: true},
+			)
 		}
 	}
-	
-	fmt.Printf("✅ NAT configuration completed\n")
+
+	if err := e.addFirewallRules(rules); err != nil {
+		return err
+	}
+	e.log.Info("NAT configured", "egress", egress)
 	return nil
 }
 
@@ -243,33 +586,35 @@ func (e *Engine) AddPeerRoute(peerIP, nknAddr string) error {
 	return e.addPeerRoute(peerIP, nknAddr)
 }
 
-func (e *Engine) RemovePeerRoute(peerIP string) error {
-	return e.removePeerRoute(peerIP)
-}
-
-// calculateClientIP generates a unique IP for clients based on their NKN address
-func (e *Engine) calculateClientIP() byte {
-	// Use hash of NKN address to generate unique IP suffix
-	nknAddr := e.nknClient.GetAddress()
-	hash := 0
-	for _, b := range []byte(nknAddr) {
-		hash = (hash*31 + int(b)) % 253 // Keep in range 2-254
-	}
-	return byte(hash + 2) // Start from .2 (skip .1 for exit node)
+func (e *Engine) RemovePeerRoute(peerIP, nknAddr string) error {
+	return e.removePeerRoute(peerIP, nknAddr)
 }
 
+// Stop says goodbye to peers, stops all background goroutines, closes the
+// TUN device and reverts every change the engine made to the host. It is
+// safe to call after a failed start and more than once.
 func (e *Engine) Stop() error {
-	e.runningMu.Lock()
-	defer e.runningMu.Unlock()
-
-	if !e.running {
-		return nil
-	}
+	e.stopOnce.Do(func() {
+		e.runningMu.Lock()
+		running := e.running
+		e.running = false
+		e.runningMu.Unlock()
+
+		if running {
+			e.log.Info("stopping VPN engine")
+			e.transport.Goodbye()
+		}
 
-	if e.tunDevice != nil {
-		e.tunDevice.Close()
-	}
+		e.cancel()
+		e.runCleanup()
+		if e.tunDevice != nil {
+			e.tunDevice.Close()
+		}
+		e.wg.Wait()
 
-	e.running = false
+		if running {
+			e.log.Info("VPN engine stopped")
+		}
+	})
 	return nil
-}
\ No newline at end of file
+}
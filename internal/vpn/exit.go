@@ -0,0 +1,169 @@
+package vpn
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"nghost/internal/nkn"
+)
+
+const (
+	ExitPolicyLatency = "latency"
+	ExitPolicyPinned  = "pinned"
+
+	// exitCheckInterval is how often idle flows are expired and the active
+	// exit node is checked.
+	exitCheckInterval = 10 * time.Second
+	// flowIdleTimeout is how long an idle flow keeps its exit node.
+	flowIdleTimeout = 2 * time.Minute
+)
+
+type flowEntry struct {
+	exit     string
+	lastUsed time.Time
+}
+
+// exitSelector pins each flow to the exit node it started on, so
+// connections are not broken by NAT on a different exit when latencies
+// change. Flows only move when their exit node stops responding.
+type exitSelector struct {
+	mu      sync.Mutex
+	flows   map[uint64]*flowEntry
+	current string
+
+	// candidates are the responding exit nodes and preferred the one new
+	// flows start on, refreshed by refreshExitNodes for new flows, peer
+	// events and periodically rather than per packet
+	candidates map[string]nkn.Peer
+	preferred  string
+}
+
+func newExitSelector() *exitSelector {
+	return &exitSelector{flows: make(map[uint64]*flowEntry)}
+}
+
+// selectExitNode returns the NKN address of the exit node for packet, or ""
+// if none is available.
+func (e *Engine) selectExitNode(packet []byte) string {
+	key := flowKey(packet)
+	now := time.Now()
+
+	s := e.exits
+	s.mu.Lock()
+	if flow, ok := s.flows[key]; ok {
+		if _, alive := s.candidates[flow.exit]; alive {
+			flow.lastUsed = now
+			s.mu.Unlock()
+			return flow.exit
+		}
+	}
+	s.mu.Unlock()
+
+	// Packets of known flows only check the cached candidates; a new flow
+	// picks its exit from the current latencies
+	e.refreshExitNodes()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	exit := s.preferred
+	if exit == "" {
+		return ""
+	}
+	s.flows[key] = &flowEntry{exit: exit, lastUsed: now}
+	if exit != s.current {
+		if s.current != "" {
+			e.log.Info("switching exit node", "from", s.current, "to", exit)
+		}
+		s.current = exit
+	}
+	return exit
+}
+
+// refreshExitNodes updates the exit nodes that answer pings. Liveness
+// tracking in the NKN client drops exit nodes as soon as they go stale, and
+// the peer events it emits trigger a refresh.
+func (e *Engine) refreshExitNodes() {
+	candidates := make(map[string]nkn.Peer)
+	for _, peer := range e.transport.FindExitNodes() {
+		candidates[peer.Address] = peer
+	}
+	preferred := ""
+	if len(candidates) > 0 {
+		preferred = e.preferredExitNode(candidates)
+	}
+
+	e.exits.mu.Lock()
+	defer e.exits.mu.Unlock()
+	e.exits.candidates = candidates
+	e.exits.preferred = preferred
+	if e.exits.current != "" {
+		if _, alive := candidates[e.exits.current]; !alive {
+			e.log.Warn("exit node stopped responding, failing over", "exit", e.exits.current)
+			e.exits.current = ""
+		}
+	}
+}
+
+// preferredExitNode applies the configured policy to pick an exit node for a
+// new flow. The pinned policy walks VPNConfig.ExitNodes in order and falls
+// back to the lowest latency exit node if none of them is available.
+func (e *Engine) preferredExitNode(candidates map[string]nkn.Peer) string {
+	if e.config.ExitNodePolicy == ExitPolicyPinned {
+		for _, addr := range e.config.ExitNodes {
+			if _, ok := candidates[addr]; ok {
+				return addr
+			}
+		}
+	}
+
+	peers := make([]nkn.Peer, 0, len(candidates))
+	for _, peer := range candidates {
+		peers = append(peers, peer)
+	}
+	// Unmeasured exit nodes (latency 0) sort after measured ones
+	sort.Slice(peers, func(i, j int) bool {
+		li, lj := peers[i].Latency, peers[j].Latency
+		if (li == 0) != (lj == 0) {
+			return lj == 0
+		}
+		if li != lj {
+			return li < lj
+		}
+		return peers[i].Address < peers[j].Address
+	})
+	return peers[0].Address
+}
+
+// activeExitNode returns the exit node most recently chosen for a new flow.
+func (e *Engine) activeExitNode() string {
+	e.exits.mu.Lock()
+	defer e.exits.mu.Unlock()
+	return e.exits.current
+}
+
+// monitorExitNodes expires idle flows and refreshes the exit nodes, picking
+// up latency changes. RTTs are measured by the NKN client's liveness pings.
+func (e *Engine) monitorExitNodes() {
+	ticker := time.NewTicker(exitCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		e.refreshExitNodes()
+
+		select {
+		case <-e.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		e.exits.mu.Lock()
+		now := time.Now()
+		for key, flow := range e.exits.flows {
+			if now.Sub(flow.lastUsed) > flowIdleTimeout {
+				delete(e.exits.flows, key)
+			}
+		}
+		e.exits.mu.Unlock()
+	}
+}
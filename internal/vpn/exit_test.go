@@ -0,0 +1,39 @@
+package vpn
+
+import (
+	"testing"
+
+	"nghost/internal/config"
+	"nghost/internal/nkn"
+)
+
+func TestExitNodesCached(t *testing.T) {
+	e, transport := newFakeEngine(t, config.VPNConfig{}, "node")
+	transport.exits = []nkn.Peer{
+		{Address: "slow", ExitNode: true, Latency: 80},
+		{Address: "fast", ExitNode: true, Latency: 20},
+	}
+
+	packet := make([]byte, 40)
+	packet[0] = 0x45
+	for i := 0; i < 100; i++ {
+		if exit := e.selectExitNode(packet); exit != "fast" {
+			t.Fatalf("selected %q, want fast", exit)
+		}
+	}
+	if n := transport.exitLookups.Load(); n != 1 {
+		t.Fatalf("%d exit node lookups, want 1", n)
+	}
+
+	// The flow stays on its exit until it stops responding
+	transport.exits[1].Latency = 200
+	e.refreshExitNodes()
+	if exit := e.selectExitNode(packet); exit != "fast" {
+		t.Fatalf("flow moved to %q", exit)
+	}
+	transport.exits = transport.exits[:1]
+	e.refreshExitNodes()
+	if exit := e.selectExitNode(packet); exit != "slow" {
+		t.Fatalf("flow on %q, want failover to slow", exit)
+	}
+}
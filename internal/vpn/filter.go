@@ -0,0 +1,93 @@
+package vpn
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+)
+
+var (
+	ErrMalformedPacket = errors.New("malformed packet")
+	ErrSpoofedSource   = errors.New("source address not owned by sending peer")
+)
+
+// dropStats counts inbound packets rejected before reaching the TUN device.
+type dropStats struct {
+	malformed atomic.Uint64
+	spoofed   atomic.Uint64
+}
+
+type DropCounters struct {
+	Malformed uint64 `json:"malformed"`
+	Spoofed   uint64 `json:"spoofed"`
+}
+
+func (s *dropStats) snapshot() DropCounters {
+	return DropCounters{
+		Malformed: s.malformed.Load(),
+		Spoofed:   s.spoofed.Load(),
+	}
+}
+
+// InjectPacketFrom writes a packet received from the NKN peer src to the TUN
+// device after a reverse path check: the source address must route back to
+// src, either as its announced VPN IP or a subnet it routes. A subnet
+// advertised by several peers is accepted from each of them, not only from
+// the one packets to it are routed to. Exit nodes may
+// additionally send packets from addresses outside the VPN network, which is
+// how internet return traffic arrives.
+func (e *Engine) InjectPacketFrom(src string, packet []byte) error {
+	srcIP := packetSource(packet)
+	if srcIP == nil {
+		e.drops.malformed.Add(1)
+		malformedPackets.Inc()
+		return ErrMalformedPacket
+	}
+
+	if !e.sourceAllowed(src, srcIP) {
+		e.drops.spoofed.Add(1)
+		spoofedPackets.Inc()
+		return ErrSpoofedSource
+	}
+
+	if err := e.InjectPacket(packet); err != nil {
+		unwrittenPackets.Inc()
+		return err
+	}
+	e.counters.get(src, countRx).add(packet)
+	return nil
+}
+
+func (e *Engine) sourceAllowed(src string, srcIP net.IP) bool {
+	// Split tunneling routes name no peer; internet traffic is checked
+	// against the exit nodes below
+	if owner, ok := e.findRoute(srcIP); ok && owner.Kind == RoutePeer {
+		return owner.Peer == src || e.advertises(src, srcIP)
+	}
+
+	if e.inVPN(srcIP) {
+		return false
+	}
+
+	e.exits.mu.Lock()
+	defer e.exits.mu.Unlock()
+	_, exit := e.exits.candidates[src]
+	return exit
+}
+
+// advertises reports whether the peer src advertised a subnet containing ip.
+func (e *Engine) advertises(src string, ip net.IP) bool {
+	addr, ok := addrFromIP(ip)
+	if !ok {
+		return false
+	}
+
+	e.routesMu.RLock()
+	defer e.routesMu.RUnlock()
+	for prefix := range e.subnets[src] {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
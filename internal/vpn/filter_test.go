@@ -0,0 +1,59 @@
+package vpn
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+
+	"nghost/internal/config"
+)
+
+func packetFrom(src string) []byte {
+	packet := make([]byte, 20)
+	packet[0] = 0x45
+	copy(packet[12:16], netip.MustParseAddr(src).AsSlice())
+	return packet
+}
+
+func TestInjectRejectsSpoofedSources(t *testing.T) {
+	e, _ := newFakeEngine(t, config.VPNConfig{AcceptRoutes: true}, "node")
+	if err := e.addPeerRoute("10.100.0.5", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.addPeerRoute("10.100.0.6", "b"); err != nil {
+		t.Fatal(err)
+	}
+	// Both peers route the same LAN; packets to it take only one of them
+	if err := e.SetPeerSubnets("a", []string{"192.168.10.0/24"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SetPeerSubnets("b", []string{"192.168.10.0/24"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		peer, source string
+		spoofed      bool
+	}{
+		{"a", "10.100.0.5", false},
+		{"b", "10.100.0.6", false},
+		{"a", "10.100.0.6", true},
+		{"b", "10.100.0.5", true},
+		{"c", "10.100.0.5", true},
+		{"a", "10.100.0.9", true},
+		{"a", "192.168.10.20", false},
+		{"b", "192.168.10.20", false},
+		{"c", "192.168.10.20", true},
+		// Internet addresses only come from exit nodes
+		{"a", "203.0.113.1", true},
+	}
+	for _, test := range tests {
+		err := e.InjectPacketFrom(test.peer, packetFrom(test.source))
+		if spoofed := errors.Is(err, ErrSpoofedSource); spoofed != test.spoofed {
+			t.Errorf("%s from %s: got %v, want spoofed %v", test.source, test.peer, err, test.spoofed)
+		}
+	}
+	if n := e.drops.snapshot().Spoofed; n != 6 {
+		t.Fatalf("%d spoofed packets counted, want 6", n)
+	}
+}
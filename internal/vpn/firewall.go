@@ -0,0 +1,51 @@
+package vpn
+
+import "nghost/internal/firewall"
+
+// addFirewallRules adds rules to the NGhost-owned firewall table and applies
+// the whole ruleset again, so rules never pile up and a table left behind by
+// a crashed run is replaced. The table is removed when the engine stops.
+func (e *Engine) addFirewallRules(rules firewall.Ruleset) error {
+	e.firewallMu.Lock()
+	defer e.firewallMu.Unlock()
+
+	if e.firewall == nil {
+		fw, err := firewall.New()
+		if err != nil {
+			return err
+		}
+		e.firewall = fw
+		e.onStop("firewall rules", fw.Remove)
+		e.log.Info("using firewall backend", "backend", fw.Name())
+	}
+
+	next := e.firewallRules.Merge(rules)
+	if err := e.firewall.Apply(next); err != nil {
+		return err
+	}
+	e.firewallRules = next
+	return nil
+}
+
+// setupKillSwitch rejects traffic leaving the host outside the tunnel. The
+// rules stay while the tunnel is down, e.g. while no exit node is reachable
+// or NKN reconnects, and are only removed when the engine stops.
+func (e *Engine) setupKillSwitch() error {
+	if !e.config.KillSwitch || !e.host {
+		return nil
+	}
+	if e.isExitNode {
+		// The tunnel ends here, the own traffic of an exit node has nowhere
+		// else to go
+		e.log.Warn("kill switch ignored on exit node")
+		return nil
+	}
+
+	interfaceName := e.interfaceName()
+	rules := firewall.Ruleset{KillSwitch: &firewall.KillSwitch{Interfaces: []string{interfaceName}}}
+	if err := e.addFirewallRules(rules); err != nil {
+		return err
+	}
+	e.log.Info("kill switch enabled", "interface", interfaceName)
+	return nil
+}
@@ -0,0 +1,67 @@
+package vpn
+
+import (
+	"fmt"
+	"net/netip"
+	"runtime"
+
+	"nghost/internal/netlink"
+)
+
+// splitDefaultRoutes together cover the whole address space. Being more
+// specific than a default route they take precedence over it without
+// replacing it, so the original default route stays in place.
+var (
+	splitDefaultRoutes = []netip.Prefix{
+		netip.MustParsePrefix("0.0.0.0/1"),
+		netip.MustParsePrefix("128.0.0.0/1"),
+	}
+	splitDefaultRoutes6 = []netip.Prefix{
+		netip.MustParsePrefix("::/1"),
+		netip.MustParsePrefix("8000::/1"),
+	}
+)
+
+// setupFullTunnel sends all internet traffic into the TUN device, where it
+// is forwarded to an exit node. The addresses of NKN seeds and nodes are
+// pinned to the original default gateway first, so the transport itself
+// never runs through the tunnel.
+func (e *Engine) setupFullTunnel() error {
+	if !e.config.FullTunnel || !e.host {
+		return nil
+	}
+	if e.isExitNode {
+		e.log.Warn("full-tunnel mode ignored on exit node")
+		return nil
+	}
+	if len(e.config.SplitTunnel.Include) > 0 {
+		e.log.Warn("full-tunnel mode ignored, the split tunnel include list selects the tunnelled destinations")
+		return nil
+	}
+	if runtime.GOOS != "linux" {
+		return fmt.Errorf("full-tunnel mode is only supported on Linux")
+	}
+
+	if err := e.setupBypass(); err != nil {
+		return err
+	}
+
+	prefixes := splitDefaultRoutes
+	if e.localIP6() != nil {
+		prefixes = append(append([]netip.Prefix(nil), prefixes...), splitDefaultRoutes6...)
+	}
+	interfaceName := e.interfaceName()
+	for _, dst := range prefixes {
+		route := netlink.Route{Dst: dst, Link: interfaceName}
+		if err := netlink.AddRoute(route); err != nil {
+			return err
+		}
+		e.onStop("route "+dst.String(), func() error {
+			return netlink.DeleteRoute(route)
+		})
+	}
+
+	gateway := e.bypass.gateway
+	e.log.Info("full tunnel enabled", "gateway", gateway.Gateway, "link", gateway.Link)
+	return nil
+}
@@ -0,0 +1,94 @@
+package vpn
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// undoAction reverts one change the engine made to the host.
+type undoAction struct {
+	name string
+	undo func() error
+}
+
+// onStop registers undo to revert a change to the host when the engine
+// stops. Actions run in reverse order of registration, so later changes that
+// depend on earlier ones are reverted first.
+func (e *Engine) onStop(name string, undo func() error) {
+	e.cleanupMu.Lock()
+	defer e.cleanupMu.Unlock()
+	e.cleanup = append(e.cleanup, undoAction{name: name, undo: undo})
+}
+
+// runCleanup reverts all registered changes. Failures are reported and do not
+// stop the remaining actions.
+func (e *Engine) runCleanup() {
+	e.cleanupMu.Lock()
+	actions := e.cleanup
+	e.cleanup = nil
+	e.cleanupMu.Unlock()
+
+	for i := len(actions) - 1; i >= 0; i-- {
+		if err := actions[i].undo(); err != nil {
+			e.log.Warn("failed to undo host change", "change", actions[i].name, "err", err)
+		}
+	}
+}
+
+// goroutine runs fn in the background; Stop waits for it to return. fn must
+// return once e.ctx is done.
+func (e *Engine) goroutine(fn func()) {
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		fn()
+	}()
+}
+
+// setSysctl sets key to value and restores the previous value on stop.
+func (e *Engine) setSysctl(key, value string) error {
+	previous, err := readSysctl(key)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", key, err)
+	}
+	if previous == value {
+		return nil
+	}
+
+	if err := writeSysctl(key, value); err != nil {
+		return fmt.Errorf("failed to set %s: %w", key, err)
+	}
+	e.onStop("sysctl "+key, func() error {
+		return writeSysctl(key, previous)
+	})
+	return nil
+}
+
+// readSysctl returns the value of key. On Linux it reads /proc/sys directly
+// instead of running sysctl.
+func readSysctl(key string) (string, error) {
+	if runtime.GOOS == "linux" {
+		data, err := os.ReadFile(sysctlPath(key))
+		return strings.TrimSpace(string(data)), err
+	}
+	output, err := exec.Command("sysctl", "-n", key).Output()
+	return strings.TrimSpace(string(output)), err
+}
+
+func writeSysctl(key, value string) error {
+	if runtime.GOOS == "linux" {
+		return os.WriteFile(sysctlPath(key), []byte(value+"\n"), 0644)
+	}
+	if output, err := exec.Command("sysctl", "-w", key+"="+value).CombinedOutput(); err != nil {
+		return fmt.Errorf("%w (%s)", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func sysctlPath(key string) string {
+	return filepath.Join("/proc/sys", strings.ReplaceAll(key, ".", "/"))
+}
@@ -0,0 +1,56 @@
+package vpn
+
+import "nghost/internal/nkn"
+
+// handlePeerEvent keeps the routing table in sync with peer liveness: routes
+// of offline peers are withdrawn and restored when the peer answers again,
+// and removed peers give up their address leases.
+func (e *Engine) handlePeerEvent(event nkn.PeerEvent) {
+	peer := event.Peer
+	if peer.ExitNode && !e.isExitNode {
+		defer e.refreshExitNodes()
+	}
+
+	switch event.State {
+	case nkn.PeerOnline:
+		if event.Previous == nkn.PeerOffline {
+			e.restorePeerRoutes(peer)
+		}
+	case nkn.PeerOffline, nkn.PeerRemoved:
+		if event.Previous != nkn.PeerOffline {
+			e.withdrawPeerRoutes(peer)
+		}
+		if event.State == nkn.PeerRemoved {
+			e.counters.forget(peer.Address)
+			e.pool.Release(peer.Address)
+			if e.pool6 != nil {
+				e.pool6.Release(peer.Address)
+			}
+		}
+	}
+}
+
+func (e *Engine) withdrawPeerRoutes(peer nkn.Peer) {
+	if err := e.SetPeerSubnets(peer.Address, nil); err != nil {
+		e.log.Warn("failed to withdraw subnets", "peer", peer.Address, "err", err)
+	}
+	for _, ip := range []string{peer.IPAddress, peer.IPv6Address} {
+		if ip != "" {
+			e.removePeerRoute(ip, peer.Address)
+		}
+	}
+}
+
+func (e *Engine) restorePeerRoutes(peer nkn.Peer) {
+	for _, ip := range []string{peer.IPAddress, peer.IPv6Address} {
+		if ip == "" {
+			continue
+		}
+		if err := e.addPeerRoute(ip, peer.Address); err != nil {
+			e.log.Warn("failed to restore route", "ip", ip, "peer", peer.Address, "err", err)
+		}
+	}
+	if err := e.SetPeerSubnets(peer.Address, peer.Routes); err != nil {
+		e.log.Warn("failed to restore subnets", "peer", peer.Address, "err", err)
+	}
+}
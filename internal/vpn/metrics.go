@@ -0,0 +1,130 @@
+package vpn
+
+import (
+	"sync"
+
+	"nghost/internal/metrics"
+)
+
+var (
+	tunPackets = metrics.NewCounterVec("nghost_tun_packets_total",
+		"Packets read from (direction=read) and written to (direction=write) the TUN device.", "direction")
+	tunBytes = metrics.NewCounterVec("nghost_tun_bytes_total",
+		"Bytes read from and written to the TUN device.", "direction")
+	tunErrors = metrics.NewCounterVec("nghost_tun_errors_total",
+		"Failed reads and writes on the TUN device.", "direction")
+	tunPacketSize = metrics.NewHistogramVec("nghost_tun_packet_size_bytes",
+		"Size of packets read from and written to the TUN device.", metrics.SizeBuckets, "direction")
+
+	peerPackets = metrics.NewCounterVec("nghost_peer_packets_total",
+		"Packets sent to (direction=tx) and received from (direction=rx) each peer.", "peer", "direction")
+	peerBytes = metrics.NewCounterVec("nghost_peer_bytes_total",
+		"Bytes sent to and received from each peer.", "peer", "direction")
+
+	sendFailures = metrics.NewCounter("nghost_send_failures_total",
+		"Packets the transport failed to send to a peer.")
+	injectFailures = metrics.NewCounterVec("nghost_inject_failures_total",
+		"Packets from peers that were not written to the TUN device, by reason (malformed, spoofed, write).", "reason")
+	routeMisses = metrics.NewCounterVec("nghost_route_misses_total",
+		"Packets from the TUN device without a route: no peer for a VPN address (reason=peer) or no exit node for other traffic (reason=exit) or excluded by split tunneling (reason=split).", "reason")
+
+	exitPackets = metrics.NewCounterVec("nghost_exit_packets_total",
+		"Internet packets sent through each exit node.", "exit")
+	exitBytes = metrics.NewCounterVec("nghost_exit_bytes_total",
+		"Internet bytes sent through each exit node.", "exit")
+)
+
+// Series with fixed labels, looked up once instead of per packet
+var (
+	tunRead  = newPacketCounter(tunPackets.With("read"), tunBytes.With("read"))
+	tunWrite = newPacketCounter(tunPackets.With("write"), tunBytes.With("write"))
+
+	tunReadErrors  = tunErrors.With("read")
+	tunWriteErrors = tunErrors.With("write")
+	tunReadSize    = tunPacketSize.With("read")
+	tunWriteSize   = tunPacketSize.With("write")
+
+	malformedPackets = injectFailures.With("malformed")
+	spoofedPackets   = injectFailures.With("spoofed")
+	unwrittenPackets = injectFailures.With("write")
+
+	peerMisses  = routeMisses.With("peer")
+	exitMisses  = routeMisses.With("exit")
+	splitMisses = routeMisses.With("split")
+)
+
+// packetCounter counts the packets and bytes of one series.
+type packetCounter struct {
+	packets *metrics.Counter
+	bytes   *metrics.Counter
+}
+
+func newPacketCounter(packets, bytes *metrics.Counter) *packetCounter {
+	return &packetCounter{packets: packets, bytes: bytes}
+}
+
+func (c *packetCounter) add(packet []byte) {
+	c.packets.Inc()
+	c.bytes.Add(uint64(len(packet)))
+}
+
+// Directions of the per-peer counters; exit counts traffic through an exit
+// node in addition to tx.
+const (
+	countTx   = "tx"
+	countRx   = "rx"
+	countExit = "exit"
+)
+
+type peerCounterKey struct {
+	peer      string
+	direction string
+}
+
+// peerCounters caches the per-peer series, so counting a packet is a map
+// lookup rather than formatting its labels.
+type peerCounters struct {
+	mu       sync.RWMutex
+	counters map[peerCounterKey]*packetCounter
+}
+
+func (p *peerCounters) get(peer, direction string) *packetCounter {
+	key := peerCounterKey{peer, direction}
+	p.mu.RLock()
+	c, ok := p.counters[key]
+	p.mu.RUnlock()
+	if ok {
+		return c
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if c, ok := p.counters[key]; ok {
+		return c
+	}
+	if direction == countExit {
+		c = newPacketCounter(exitPackets.With(peer), exitBytes.With(peer))
+	} else {
+		c = newPacketCounter(peerPackets.With(peer, direction), peerBytes.With(peer, direction))
+	}
+	if p.counters == nil {
+		p.counters = make(map[peerCounterKey]*packetCounter)
+	}
+	p.counters[key] = c
+	return c
+}
+
+// forget drops the series of a removed peer, so peers coming and going do
+// not grow the metrics endlessly.
+func (p *peerCounters) forget(peer string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, direction := range []string{countTx, countRx} {
+		delete(p.counters, peerCounterKey{peer, direction})
+		peerPackets.Delete(peer, direction)
+		peerBytes.Delete(peer, direction)
+	}
+	delete(p.counters, peerCounterKey{peer, countExit})
+	exitPackets.Delete(peer)
+	exitBytes.Delete(peer)
+}
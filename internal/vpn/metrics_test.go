@@ -0,0 +1,24 @@
+package vpn
+
+import "testing"
+
+func TestPeerCountersCached(t *testing.T) {
+	var counters peerCounters
+	tx := counters.get("peer", countTx)
+	if counters.get("peer", countTx) != tx {
+		t.Fatal("counter looked up again")
+	}
+	tx.add(make([]byte, 100))
+	if got := peerBytes.With("peer", countTx).Value(); got != 100 {
+		t.Fatalf("peer bytes %d, want 100", got)
+	}
+
+	counters.forget("peer")
+	if got := peerBytes.With("peer", countTx).Value(); got != 0 {
+		t.Fatalf("peer bytes %d after forget, want 0", got)
+	}
+	if counters.get("peer", countTx) == tx {
+		t.Fatal("forgotten counter still cached")
+	}
+	counters.forget("peer")
+}
@@ -0,0 +1,92 @@
+package vpn
+
+import (
+	"hash/fnv"
+	"net"
+)
+
+const (
+	ipv4HeaderLen = 20
+	ipv6HeaderLen = 40
+
+	protoTCP = 6
+	protoUDP = 17
+)
+
+// packetVersion returns the IP version nibble of a packet, or 0 if the
+// packet is too short to hold a header of that version.
+func packetVersion(packet []byte) int {
+	if len(packet) == 0 {
+		return 0
+	}
+	switch version := int(packet[0] >> 4); version {
+	case 4:
+		if len(packet) >= ipv4HeaderLen {
+			return 4
+		}
+	case 6:
+		if len(packet) >= ipv6HeaderLen {
+			return 6
+		}
+	}
+	return 0
+}
+
+func packetSource(packet []byte) net.IP {
+	switch packetVersion(packet) {
+	case 4:
+		return net.IP(packet[12:16])
+	case 6:
+		return net.IP(packet[8:24])
+	}
+	return nil
+}
+
+func packetDestination(packet []byte) net.IP {
+	switch packetVersion(packet) {
+	case 4:
+		return net.IP(packet[16:20])
+	case 6:
+		return net.IP(packet[24:40])
+	}
+	return nil
+}
+
+// hostCIDR returns the single-host prefix for ip ("/32" or "/128").
+func hostCIDR(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		return v4.String() + "/32"
+	}
+	return ip.String() + "/128"
+}
+
+// flowKey hashes the addresses, protocol and (for TCP and UDP) ports of a
+// packet, so all packets of a connection map to the same key.
+func flowKey(packet []byte) uint64 {
+	h := fnv.New64a()
+	var proto byte
+	var transport []byte
+
+	switch packetVersion(packet) {
+	case 4:
+		h.Write(packet[12:20])
+		proto = packet[9]
+		if ihl := int(packet[0]&0x0f) * 4; len(packet) >= ihl+4 {
+			transport = packet[ihl : ihl+4]
+		}
+	case 6:
+		h.Write(packet[8:40])
+		proto = packet[6]
+		if len(packet) >= ipv6HeaderLen+4 {
+			transport = packet[ipv6HeaderLen : ipv6HeaderLen+4]
+		}
+	default:
+		return 0
+	}
+
+	h.Write([]byte{proto})
+	if proto == protoTCP || proto == protoUDP {
+		h.Write(transport)
+	}
+	return h.Sum64()
+}
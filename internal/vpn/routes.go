@@ -1,14 +1,30 @@
 package vpn
 
 import (
+	"errors"
 	"fmt"
 	"net"
+	"net/netip"
 	"os/exec"
 	"runtime"
 	"strings"
+
+	"nghost/internal/netlink"
 )
 
+// interfaceName returns the actual TUN interface name, which may differ from
+// the configured one (e.g. utun devices on macOS).
+func (e *Engine) interfaceName() string {
+	if e.tunDevice != nil {
+		return e.tunDevice.GetName()
+	}
+	return e.config.InterfaceName
+}
+
 func (e *Engine) setupDefaultRoute() error {
+	if !e.host {
+		return nil
+	}
 	switch runtime.GOOS {
 	case "linux":
 		return e.setupDefaultRouteLinux()
@@ -21,27 +37,25 @@ func (e *Engine) setupDefaultRoute() error {
 
 func (e *Engine) setupDefaultRouteLinux() error {
 	// Add route for VPN traffic through our interface
-	_, network, _ := net.ParseCIDR(e.config.CIDR)
-	
-	// Use the actual interface name, not the configured one
-	interfaceName := e.config.InterfaceName
-	if e.tunDevice != nil {
-		interfaceName = e.tunDevice.GetName()
-	}
-	
-	cmd := exec.Command("ip", "route", "add", network.String(), "dev", interfaceName)
-	output, err := cmd.CombinedOutput()
+	network, err := netip.ParsePrefix(e.config.CIDR)
 	if err != nil {
-		// Check if route already exists
-		if strings.Contains(string(output), "File exists") {
-			fmt.Printf("ℹ️  Route already exists for %s\n", network.String())
+		return fmt.Errorf("invalid CIDR: %w", err)
+	}
+
+	// Use the actual interface name, not the configured one
+	route := netlink.Route{Dst: network.Masked(), Link: e.interfaceName()}
+	if err := netlink.AddRoute(route); err != nil {
+		if errors.Is(err, netlink.ErrExists) {
+			e.log.Debug("route already exists", "route", route)
 			return nil
 		}
-		fmt.Printf("❌ Route command failed: %s\n", string(output))
 		return err
 	}
-	
-	fmt.Printf("✅ Added route: %s via %s\n", network.String(), interfaceName)
+
+	e.onStop("route "+route.Dst.String(), func() error {
+		return netlink.DeleteRoute(route)
+	})
+	e.log.Info("added route", "route", route)
 	return nil
 }
 
@@ -49,77 +63,128 @@ func (e *Engine) setupDefaultRouteDarwin() error {
 	// Add route for VPN traffic through our interface
 	_, network, _ := net.ParseCIDR(e.config.CIDR)
 	cmd := exec.Command("route", "add", "-net", network.String(), "-interface", e.config.InterfaceName)
-	return cmd.Run()
+	if err := cmd.Run(); err != nil {
+		return err
+	}
+	e.onStop("route "+network.String(), func() error {
+		return exec.Command("route", "delete", "-net", network.String()).Run()
+	})
+	return nil
 }
 
-func (e *Engine) getDefaultGateway() (string, error) {
+// getDefaultGateway returns the default route for the address family of addr
+// (IPv4 if addr is unset).
+func (e *Engine) getDefaultGateway(addr netip.Addr) (netlink.Route, error) {
 	switch runtime.GOOS {
 	case "linux":
-		return e.getDefaultGatewayLinux()
+		return e.getDefaultGatewayLinux(addr)
 	case "darwin":
-		return e.getDefaultGatewayDarwin()
+		return e.getDefaultGatewayDarwin(addr)
 	default:
-		return "", fmt.Errorf("unsupported platform: %s", runtime.GOOS)
+		return netlink.Route{}, fmt.Errorf("unsupported platform: %s", runtime.GOOS)
 	}
 }
 
-func (e *Engine) getDefaultGatewayLinux() (string, error) {
-	cmd := exec.Command("ip", "route", "show", "default")
-	output, err := cmd.Output()
+func (e *Engine) getDefaultGatewayLinux(addr netip.Addr) (netlink.Route, error) {
+	route, err := netlink.DefaultGateway(addr)
 	if err != nil {
-		return "", err
-	}
-
-	lines := strings.Split(string(output), "\n")
-	for _, line := range lines {
-		if strings.Contains(line, "default via") {
-			parts := strings.Fields(line)
-			if len(parts) >= 3 {
-				return parts[2], nil
-			}
+		if errors.Is(err, netlink.ErrNotFound) {
+			return netlink.Route{}, fmt.Errorf("no default gateway found")
 		}
+		return netlink.Route{}, err
 	}
-	return "", fmt.Errorf("no default gateway found")
+	return route, nil
 }
 
-func (e *Engine) getDefaultGatewayDarwin() (string, error) {
-	cmd := exec.Command("route", "-n", "get", "default")
+func (e *Engine) getDefaultGatewayDarwin(addr netip.Addr) (netlink.Route, error) {
+	args := []string{"-n", "get", "default"}
+	route := netlink.Route{Dst: netip.PrefixFrom(netip.IPv4Unspecified(), 0)}
+	if addr.Is6() && !addr.Is4In6() {
+		args = []string{"-n", "get", "-inet6", "default"}
+		route.Dst = netip.PrefixFrom(netip.IPv6Unspecified(), 0)
+	}
+	cmd := exec.Command("route", args...)
 	output, err := cmd.Output()
 	if err != nil {
-		return "", err
+		return netlink.Route{}, err
 	}
 
 	lines := strings.Split(string(output), "\n")
 	for _, line := range lines {
-		if strings.Contains(line, "gateway:") {
-			parts := strings.Fields(line)
-			if len(parts) >= 2 {
-				return parts[1], nil
-			}
+		parts := strings.Fields(line)
+		if len(parts) < 2 {
+			continue
+		}
+		switch parts[0] {
+		case "gateway:":
+			// Link-local gateways carry a zone (fe80::1%en0)
+			gateway, _, _ := strings.Cut(parts[1], "%")
+			route.Gateway, _ = netip.ParseAddr(gateway)
+		case "interface:":
+			route.Link = parts[1]
 		}
 	}
-	return "", fmt.Errorf("no default gateway found")
+	if !route.Gateway.IsValid() {
+		return netlink.Route{}, fmt.Errorf("no default gateway found")
+	}
+	return route, nil
 }
 
 func (e *Engine) addPeerRoute(peerIP, nknAddr string) error {
+	ip := net.ParseIP(peerIP)
+	if ip == nil {
+		return fmt.Errorf("invalid peer IP %q", peerIP)
+	}
+
+	pool := e.poolFor(ip)
+	if pool == nil {
+		return fmt.Errorf("address family of %s is not enabled", peerIP)
+	}
+
+	// Drop the route of a previous address if the peer moved
+	previous := pool.Address(nknAddr)
+	if err := e.claimPeerAddress(nknAddr, ip); err != nil {
+		return err
+	}
+
 	e.routesMu.Lock()
 	defer e.routesMu.Unlock()
 
-	// Create /32 route for the specific peer IP
-	cidr := peerIP + "/32"
-	e.routes[cidr] = nknAddr
+	if previous != nil && !previous.Equal(ip) {
+		if prefix, err := parsePrefix(hostCIDR(previous)); err == nil {
+			e.routes.remove(prefix, nknAddr)
+		}
+	}
+
+	// Create a host route (/32 or /128) for the specific peer IP
+	cidr := hostCIDR(ip)
+	prefix, err := parsePrefix(cidr)
+	if err != nil {
+		return err
+	}
+	e.routes.insert(Route{Prefix: prefix, Peer: nknAddr, Metric: HostRouteMetric})
 
-	fmt.Printf("Added route: %s -> %s\n", cidr, nknAddr[:16]+"...")
+	e.log.Debug("added peer route", "prefix", cidr, "peer", nknAddr)
 	return nil
 }
 
-func (e *Engine) removePeerRoute(peerIP string) error {
+func (e *Engine) removePeerRoute(peerIP, nknAddr string) error {
 	e.routesMu.Lock()
 	defer e.routesMu.Unlock()
 
-	cidr := peerIP + "/32"
-	delete(e.routes, cidr)
+	ip := net.ParseIP(peerIP)
+	if ip == nil {
+		return fmt.Errorf("invalid peer IP %q", peerIP)
+	}
+	cidr := hostCIDR(ip)
+	prefix, err := parsePrefix(cidr)
+	if err != nil {
+		return err
+	}
+	if !e.routes.remove(prefix, nknAddr) {
+		return nil
+	}
 
-	fmt.Printf("Removed route: %s\n", cidr)
+	e.log.Debug("removed peer route", "prefix", cidr)
 	return nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,294 @@
+package vpn
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"nghost/internal/firewall"
+)
+
+const (
+	defaultSplitRefresh = 5 * time.Minute
+	splitResolveTimeout = 10 * time.Second
+)
+
+// splitTunnel holds the destinations of the include and exclude lists and
+// the prefixes currently installed for them.
+type splitTunnel struct {
+	include []splitEntry
+	exclude []splitEntry
+
+	mu     sync.Mutex
+	routes map[netip.Prefix]RouteKind // RouteExit or RouteBypass
+}
+
+// splitEntry is a CIDR or, if prefix is unset, a domain name. resolved holds
+// the addresses of a domain from its last successful lookup.
+type splitEntry struct {
+	name     string
+	prefix   netip.Prefix
+	resolved []netip.Prefix
+}
+
+// setupSplitTunnel installs the split tunneling destinations in the engine
+// routing table and the OS routing table: included ones are routed into the
+// TUN device, excluded ones through the original default gateway. CIDRs are
+// installed right away, domain names are resolved in the background and
+// again periodically.
+func (e *Engine) setupSplitTunnel() error {
+	cfg := e.config.SplitTunnel
+	if len(cfg.Include) == 0 && len(cfg.Exclude) == 0 {
+		return nil
+	}
+	if e.isExitNode {
+		e.log.Warn("split tunneling ignored on exit node")
+		return nil
+	}
+
+	t := &splitTunnel{routes: make(map[netip.Prefix]RouteKind)}
+	var err error
+	if t.include, err = e.parseSplitEntries(cfg.Include); err != nil {
+		return err
+	}
+	if t.exclude, err = e.parseSplitEntries(cfg.Exclude); err != nil {
+		return err
+	}
+	e.split = t
+
+	// Included prefixes may cover transport endpoints, so those are pinned
+	// as well
+	if e.host && runtime.GOOS == "linux" {
+		if err := e.setupBypass(); err != nil {
+			return err
+		}
+	} else if e.host && len(t.exclude) > 0 {
+		e.log.Warn("excluded destinations are only routed around the tunnel on Linux")
+	}
+
+	e.onStop("split tunnel routes", func() error {
+		e.applySplitRoutes(nil)
+		return nil
+	})
+	e.applySplitRoutes(t.wanted())
+
+	interval := defaultSplitRefresh
+	if cfg.RefreshInterval > 0 {
+		interval = time.Duration(cfg.RefreshInterval) * time.Second
+	}
+	if t.hasDomains() {
+		e.goroutine(func() { e.resolveSplitDomains(interval) })
+	}
+
+	e.log.Info("split tunneling enabled", "include", len(t.include), "exclude", len(t.exclude))
+	return nil
+}
+
+// parseSplitEntries validates CIDRs and IP addresses and keeps everything
+// else as a domain name.
+func (e *Engine) parseSplitEntries(names []string) ([]splitEntry, error) {
+	entries := make([]splitEntry, 0, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if addr, err := netip.ParseAddr(name); err == nil {
+			name = netip.PrefixFrom(addr, addr.BitLen()).String()
+		}
+		if !strings.Contains(name, "/") {
+			if name == "" || strings.ContainsAny(name, " :") {
+				return nil, fmt.Errorf("invalid split tunnel destination %q", name)
+			}
+			entries = append(entries, splitEntry{name: strings.TrimSuffix(name, ".")})
+			continue
+		}
+
+		prefix, err := parsePrefix(name)
+		if err == nil && prefix.Bits() == 0 {
+			err = fmt.Errorf("%s covers all destinations, use full-tunnel mode instead", prefix)
+		}
+		if err == nil {
+			prefix, err = e.validateSubnet(name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid split tunnel destination: %w", err)
+		}
+		if prefix.Addr().Is6() && e.localIP6() == nil {
+			e.log.Warn("ignoring IPv6 split tunnel destination, IPv6 is not enabled", "prefix", prefix)
+			continue
+		}
+		entries = append(entries, splitEntry{name: name, prefix: prefix})
+	}
+	return entries, nil
+}
+
+func (t *splitTunnel) hasDomains() bool {
+	for _, entries := range [][]splitEntry{t.include, t.exclude} {
+		for _, entry := range entries {
+			if !entry.prefix.IsValid() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (e *Engine) resolveSplitDomains(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		e.refreshSplitTunnel()
+		select {
+		case <-e.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// refreshSplitTunnel resolves the domain names concurrently and installs the
+// resulting set of prefixes. A domain that fails to resolve keeps its
+// previous addresses.
+func (e *Engine) refreshSplitTunnel() {
+	t := e.split
+	var wg sync.WaitGroup
+	for _, entries := range [][]splitEntry{t.include, t.exclude} {
+		for i := range entries {
+			if entries[i].prefix.IsValid() {
+				continue
+			}
+			wg.Add(1)
+			go func(entry *splitEntry) {
+				defer wg.Done()
+				e.resolveSplitEntry(entry)
+			}(&entries[i])
+		}
+	}
+	wg.Wait()
+	e.applySplitRoutes(t.wanted())
+}
+
+// wanted returns the prefixes of all entries, using the last addresses of
+// domain names. Exclusions win over inclusions of the same prefix.
+func (t *splitTunnel) wanted() map[netip.Prefix]RouteKind {
+	wanted := make(map[netip.Prefix]RouteKind)
+	for _, entry := range t.include {
+		for _, prefix := range entry.prefixes() {
+			wanted[prefix] = RouteExit
+		}
+	}
+	for _, entry := range t.exclude {
+		for _, prefix := range entry.prefixes() {
+			wanted[prefix] = RouteBypass
+		}
+	}
+	return wanted
+}
+
+func (entry *splitEntry) prefixes() []netip.Prefix {
+	if entry.prefix.IsValid() {
+		return []netip.Prefix{entry.prefix}
+	}
+	return entry.resolved
+}
+
+// resolveSplitEntry looks up the addresses of a domain entry.
+func (e *Engine) resolveSplitEntry(entry *splitEntry) {
+	ctx, cancel := context.WithTimeout(e.ctx, splitResolveTimeout)
+	defer cancel()
+	// The lookup carries the firewall mark so the kill switch lets it out
+	addrs, err := firewall.Dialer().Resolver.LookupNetIP(ctx, "ip", entry.name)
+	if err != nil {
+		if e.ctx.Err() == nil {
+			e.log.Warn("failed to resolve split tunnel domain", "domain", entry.name, "err", err)
+		}
+		return
+	}
+
+	var prefixes []netip.Prefix
+	for _, addr := range addrs {
+		addr = addr.Unmap()
+		if addr.Is6() && e.localIP6() == nil {
+			continue
+		}
+		if addr.IsLoopback() || addr.IsLinkLocalUnicast() || addr.IsUnspecified() || addr.IsMulticast() {
+			continue
+		}
+		if e.inVPN(addr.AsSlice()) {
+			continue
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	entry.resolved = prefixes
+}
+
+// applySplitRoutes makes the installed split tunneling prefixes match wanted.
+// System routes are counted per user, so included prefixes that peers route
+// as well, or that existed before, are left alone.
+func (e *Engine) applySplitRoutes(wanted map[netip.Prefix]RouteKind) {
+	t := e.split
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// A refresh racing with shutdown must not reinstall the routes the
+	// cleanup removed
+	if wanted != nil && e.ctx.Err() != nil {
+		return
+	}
+
+	for prefix, kind := range t.routes {
+		if via, ok := wanted[prefix]; ok && via == kind {
+			continue
+		}
+		e.routesMu.Lock()
+		e.routes.removeKind(prefix, kind)
+		e.routesMu.Unlock()
+
+		if kind == RouteExit {
+			if err := e.removeSystemRoute(prefix); err != nil {
+				e.log.Warn("failed to remove system route", "prefix", prefix, "err", err)
+			}
+		} else if e.bypass != nil {
+			e.removeBypassRoute(prefix)
+		}
+		delete(t.routes, prefix)
+		e.log.Debug("removed split tunnel route", "prefix", prefix, "via", kind)
+	}
+
+	for prefix, kind := range wanted {
+		if via, ok := t.routes[prefix]; ok && via == kind {
+			continue
+		}
+		e.routesMu.Lock()
+		e.routes.insert(Route{Prefix: prefix, Kind: kind, Metric: DefaultRouteMetric})
+		e.routesMu.Unlock()
+
+		if kind == RouteExit {
+			if err := e.addSystemRoute(prefix); err != nil {
+				e.log.Warn("failed to add system route", "prefix", prefix, "err", err)
+			}
+		} else if e.bypass != nil {
+			e.addBypassRoute(prefix)
+		}
+		t.routes[prefix] = kind
+		e.log.Debug("added split tunnel route", "prefix", prefix, "via", kind)
+	}
+}
+
+// tunnelled reports whether internet traffic whose best route is route goes
+// to an exit node: if it is included, and otherwise unless it is excluded or
+// the include list names other destinations only.
+func (e *Engine) tunnelled(route Route, found bool) bool {
+	if found {
+		switch route.Kind {
+		case RouteExit:
+			return true
+		case RouteBypass:
+			return false
+		}
+	}
+	return e.split == nil || len(e.split.include) == 0
+}
@@ -0,0 +1,28 @@
+package vpn
+
+import (
+	"net/netip"
+	"testing"
+
+	"nghost/internal/config"
+)
+
+func TestSplitTunnelInstallsCIDRsBeforeResolving(t *testing.T) {
+	e, _ := newFakeEngine(t, config.VPNConfig{
+		SplitTunnel: config.SplitTunnelConfig{Include: []string{"192.0.2.0/24", "nghost.invalid"}},
+	}, "node")
+	t.Cleanup(func() {
+		e.cancel()
+		e.wg.Wait()
+	})
+
+	if err := e.setupSplitTunnel(); err != nil {
+		t.Fatal(err)
+	}
+	e.routesMu.RLock()
+	route, ok := e.routes.lookup(netip.MustParseAddr("192.0.2.1"))
+	e.routesMu.RUnlock()
+	if !ok || route.Kind != RouteExit {
+		t.Fatalf("got %+v, %v, want an exit route", route, ok)
+	}
+}
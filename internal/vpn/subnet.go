@@ -0,0 +1,364 @@
+package vpn
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"os/exec"
+	"runtime"
+
+	"nghost/internal/firewall"
+	"nghost/internal/netlink"
+)
+
+// advertisedRoutes returns the subnets this node routes for the VPN.
+func (e *Engine) advertisedRoutes() []string {
+	return e.config.AdvertiseRoutes
+}
+
+// validateSubnet rejects routes a peer must not advertise: default routes
+// belong to exit nodes and the VPN's own networks are routed per peer.
+func (e *Engine) validateSubnet(cidr string) (netip.Prefix, error) {
+	prefix, err := parsePrefix(cidr)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	if prefix.Bits() == 0 {
+		return netip.Prefix{}, fmt.Errorf("default route %s can only be served by exit nodes", prefix)
+	}
+	for _, network := range []string{e.config.CIDR, e.config.CIDR6} {
+		if network == "" {
+			continue
+		}
+		vpnPrefix, err := parsePrefix(network)
+		if err == nil && vpnPrefix.Overlaps(prefix) {
+			return netip.Prefix{}, fmt.Errorf("route %s overlaps the VPN network %s", prefix, vpnPrefix)
+		}
+	}
+	return prefix, nil
+}
+
+// Peer subnets broader than these act like a default route, e.g. 0.0.0.0/1
+// and 128.0.0.0/1 together.
+const (
+	minPeerSubnetBits  = 8
+	minPeerSubnetBits6 = 16
+)
+
+// SetPeerSubnets installs the subnets a peer advertises in the engine routing
+// table and the OS routing table, and withdraws ones it no longer advertises.
+// Subnets are only accepted if the configuration opts in.
+func (e *Engine) SetPeerSubnets(nknAddr string, cidrs []string) error {
+	if !e.config.AcceptRoutes && len(cidrs) > 0 {
+		e.log.Debug("ignoring routes, acceptRoutes is off", "peer", nknAddr, "routes", cidrs)
+		cidrs = nil
+	}
+
+	e.routesMu.RLock()
+	current := e.subnets[nknAddr]
+	e.routesMu.RUnlock()
+
+	wanted := make(map[netip.Prefix]bool)
+	for _, cidr := range cidrs {
+		prefix, err := e.validateSubnet(cidr)
+		if err == nil && !current[prefix] {
+			err = e.validatePeerSubnet(prefix)
+		}
+		if err != nil {
+			e.logLimit.Log(e.log, slog.LevelWarn, "route", "ignoring route", "peer", nknAddr, "route", cidr, "err", err)
+			continue
+		}
+		wanted[prefix] = true
+	}
+
+	e.routesMu.Lock()
+	current = e.subnets[nknAddr]
+	var added, removed []netip.Prefix
+	for prefix := range wanted {
+		if !current[prefix] {
+			e.routes.insert(Route{Prefix: prefix, Peer: nknAddr, Metric: DefaultRouteMetric})
+			added = append(added, prefix)
+		}
+	}
+	for prefix := range current {
+		if !wanted[prefix] {
+			e.routes.remove(prefix, nknAddr)
+			removed = append(removed, prefix)
+		}
+	}
+	if len(wanted) > 0 {
+		e.subnets[nknAddr] = wanted
+	} else {
+		delete(e.subnets, nknAddr)
+	}
+	e.routesMu.Unlock()
+
+	for _, prefix := range added {
+		e.log.Info("added subnet route", "prefix", prefix, "peer", nknAddr)
+		if err := e.addSystemRoute(prefix); err != nil {
+			e.log.Warn("failed to add system route", "prefix", prefix, "err", err)
+		}
+	}
+	for _, prefix := range removed {
+		e.log.Info("removed subnet route", "prefix", prefix, "peer", nknAddr)
+		if err := e.removeSystemRoute(prefix); err != nil {
+			e.log.Warn("failed to remove system route", "prefix", prefix, "err", err)
+		}
+	}
+	return nil
+}
+
+// validatePeerSubnet rejects subnets of other peers that are outside the
+// accepted prefixes or would take traffic away from the host's own networks:
+// its directly connected networks, its default gateway and the addresses the
+// transport talks to.
+func (e *Engine) validatePeerSubnet(prefix netip.Prefix) error {
+	minBits := minPeerSubnetBits
+	if prefix.Addr().Is6() {
+		minBits = minPeerSubnetBits6
+	}
+	if prefix.Bits() < minBits {
+		return fmt.Errorf("route %s is broader than /%d, internet traffic is the job of exit nodes", prefix, minBits)
+	}
+
+	if len(e.accepted) > 0 {
+		accepted := false
+		for _, allowed := range e.accepted {
+			if allowed.Bits() <= prefix.Bits() && allowed.Contains(prefix.Addr()) {
+				accepted = true
+				break
+			}
+		}
+		if !accepted {
+			return fmt.Errorf("route %s is outside the accepted routes", prefix)
+		}
+	}
+
+	if !e.host {
+		return nil
+	}
+	for _, addr := range e.transportEndpoints() {
+		if prefix.Contains(addr) {
+			return fmt.Errorf("route %s contains transport endpoint %s", prefix, addr)
+		}
+	}
+	if gateway, err := e.getDefaultGateway(prefix.Addr()); err == nil && prefix.Contains(gateway.Gateway) {
+		return fmt.Errorf("route %s contains the default gateway %s", prefix, gateway.Gateway)
+	}
+	connected, err := e.connectedNetworks()
+	if err != nil {
+		return fmt.Errorf("failed to check route %s against local networks: %w", prefix, err)
+	}
+	for _, network := range connected {
+		if network.Overlaps(prefix) {
+			return fmt.Errorf("route %s overlaps the local network %s", prefix, network)
+		}
+	}
+	return nil
+}
+
+// connectedNetworks returns the networks of the addresses assigned to the
+// host's interfaces, except the TUN device and loopback.
+func (e *Engine) connectedNetworks() ([]netip.Prefix, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	var networks []netip.Prefix
+	for _, iface := range ifaces {
+		if iface.Name == e.interfaceName() || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			prefix, err := parsePrefix(ipNet.String())
+			if err != nil || prefix.Addr().IsLinkLocalUnicast() {
+				continue
+			}
+			networks = append(networks, prefix)
+		}
+	}
+	return networks, nil
+}
+
+// withdrawSubnets removes the subnets of all peers, including their OS
+// routes.
+func (e *Engine) withdrawSubnets() error {
+	e.routesMu.RLock()
+	peers := make([]string, 0, len(e.subnets))
+	for addr := range e.subnets {
+		peers = append(peers, addr)
+	}
+	e.routesMu.RUnlock()
+
+	for _, addr := range peers {
+		e.SetPeerSubnets(addr, nil)
+	}
+	return nil
+}
+
+// systemRoute counts the users of a route into the TUN device. A route that
+// could not be added, e.g. because one existed before, is counted as well
+// but never deleted.
+type systemRoute struct {
+	refs  int
+	owned bool
+}
+
+// addSystemRoute routes prefix into the TUN device. Routes are counted per
+// caller; a route to prefix that existed before is left alone and reported
+// as an error.
+func (e *Engine) addSystemRoute(prefix netip.Prefix) error {
+	if !e.host {
+		return nil
+	}
+	e.systemRoutesMu.Lock()
+	defer e.systemRoutesMu.Unlock()
+	if route, ok := e.systemRoutes[prefix]; ok {
+		route.refs++
+		return nil
+	}
+
+	route := &systemRoute{refs: 1}
+	e.systemRoutes[prefix] = route
+
+	var cmd []string
+	switch runtime.GOOS {
+	case "linux":
+		err := netlink.AddRoute(netlink.Route{Dst: prefix, Link: e.interfaceName()})
+		if errors.Is(err, netlink.ErrExists) {
+			return fmt.Errorf("a route to %s already exists", prefix)
+		}
+		if err != nil {
+			return err
+		}
+		route.owned = true
+		return nil
+	case "darwin":
+		family := "-inet"
+		if prefix.Addr().Is6() {
+			family = "-inet6"
+		}
+		cmd = []string{"route", "-n", "add", family, "-net", prefix.String(), "-interface", e.interfaceName()}
+	default:
+		return fmt.Errorf("unsupported platform: %s", runtime.GOOS)
+	}
+
+	if output, err := exec.Command(cmd[0], cmd[1:]...).CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %w (%s)", cmd, err, output)
+	}
+	route.owned = true
+	return nil
+}
+
+// removeSystemRoute drops a reference to a route added by addSystemRoute and
+// removes the route with the last one, if it was ours.
+func (e *Engine) removeSystemRoute(prefix netip.Prefix) error {
+	if !e.host {
+		return nil
+	}
+	e.systemRoutesMu.Lock()
+	defer e.systemRoutesMu.Unlock()
+	route, ok := e.systemRoutes[prefix]
+	if !ok {
+		return nil
+	}
+	if route.refs--; route.refs > 0 {
+		return nil
+	}
+	delete(e.systemRoutes, prefix)
+	if !route.owned {
+		return nil
+	}
+
+	var cmd []string
+	switch runtime.GOOS {
+	case "linux":
+		err := netlink.DeleteRoute(netlink.Route{Dst: prefix, Link: e.interfaceName()})
+		if errors.Is(err, netlink.ErrNotFound) {
+			return nil
+		}
+		return err
+	case "darwin":
+		family := "-inet"
+		if prefix.Addr().Is6() {
+			family = "-inet6"
+		}
+		cmd = []string{"route", "-n", "delete", family, "-net", prefix.String()}
+	default:
+		return fmt.Errorf("unsupported platform: %s", runtime.GOOS)
+	}
+
+	if output, err := exec.Command(cmd[0], cmd[1:]...).CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %w (%s)", cmd, err, output)
+	}
+	return nil
+}
+
+// setupSubnetRouter lets VPN peers reach the advertised subnets: packets
+// from the TUN device are forwarded by the kernel and masqueraded behind this
+// node's LAN address, so hosts on the LAN need no route back to the VPN.
+// Only replies are forwarded from the LAN into the TUN device.
+func (e *Engine) setupSubnetRouter() error {
+	routes := e.advertisedRoutes()
+	if len(routes) == 0 {
+		return nil
+	}
+
+	for _, cidr := range routes {
+		if _, err := e.validateSubnet(cidr); err != nil {
+			return err
+		}
+	}
+
+	if err := e.enableIPForwarding(); err != nil {
+		return fmt.Errorf("failed to enable IP forwarding: %w", err)
+	}
+
+	if !e.host {
+		return nil
+	}
+	if runtime.GOOS != "linux" {
+		e.log.Warn("NAT for advertised routes is only configured automatically on Linux")
+		return nil
+	}
+
+	interfaceName := e.interfaceName()
+	var rules firewall.Ruleset
+	for _, cidr := range routes {
+		prefix, _ := parsePrefix(cidr)
+		source := e.config.CIDR
+		if prefix.Addr().Is6() {
+			if e.config.CIDR6 == "" {
+				continue
+			}
+			source = e.config.CIDR6
+		}
+		network, err := netip.ParsePrefix(source)
+		if err != nil {
+			return fmt.Errorf("invalid CIDR: %w", err)
+		}
+
+		rules.Masquerade = append(rules.Masquerade, firewall.Masquerade{Source: network, Destination: prefix})
+		rules.Forward = append(rules.Forward,
+			firewall.Forward{InInterface: interfaceName, Destination: prefix},
+			firewall.Forward{OutInterface: interfaceName, Source: prefix, Established: true},
+		)
+	}
+	if err := e.addFirewallRules(rules); err != nil {
+		return err
+	}
+	for _, prefix := range rules.Masquerade {
+		e.log.Info("routing subnet for VPN peers", "prefix", prefix.Destination)
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+package vpn
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+)
+
+const (
+	// HostRouteMetric is used for the /32 and /128 routes of peer addresses.
+	HostRouteMetric = 0
+	// DefaultRouteMetric is used for routes added without an explicit metric.
+	DefaultRouteMetric = 100
+)
+
+// RouteKind tells where a route sends traffic.
+type RouteKind uint8
+
+const (
+	// RoutePeer sends traffic to the NKN peer of the route.
+	RoutePeer RouteKind = iota
+	// RouteExit sends internet traffic to the selected exit node (split
+	// tunneling include list).
+	RouteExit
+	// RouteBypass keeps traffic out of the tunnel (split tunneling exclude
+	// list).
+	RouteBypass
+)
+
+var routeKindNames = []string{"peer", "exit", "bypass"}
+
+func (k RouteKind) String() string {
+	if int(k) < len(routeKindNames) {
+		return routeKindNames[k]
+	}
+	return fmt.Sprintf("kind(%d)", uint8(k))
+}
+
+func (k RouteKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+func (k *RouteKind) UnmarshalText(text []byte) error {
+	for i, name := range routeKindNames {
+		if string(text) == name {
+			*k = RouteKind(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown route kind %q", text)
+}
+
+// Route maps a destination prefix to the NKN peer that handles it, or for
+// split tunneling routes, which name no peer, to the exit node or the
+// bypass. Among routes for the same prefix the lowest metric wins.
+type Route struct {
+	Prefix netip.Prefix `json:"prefix"`
+	Kind   RouteKind    `json:"kind"`
+	Peer   string       `json:"peer,omitempty"`
+	Metric int          `json:"metric"`
+}
+
+// routeTable is a binary trie of pre-parsed prefixes, one per address
+// family. Lookups walk at most one node per prefix bit and return the longest
+// matching prefix, so overlapping routes resolve deterministically. It is not
+// safe for concurrent use; the engine guards it with routesMu.
+type routeTable struct {
+	root4 *trieNode
+	root6 *trieNode
+	count int
+}
+
+type trieNode struct {
+	child  [2]*trieNode
+	routes []Route // sorted by metric, then peer
+}
+
+func newRouteTable() *routeTable {
+	return &routeTable{root4: &trieNode{}, root6: &trieNode{}}
+}
+
+func parsePrefix(cidr string) (netip.Prefix, error) {
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid route %q: %w", cidr, err)
+	}
+	return prefix.Masked(), nil
+}
+
+func (t *routeTable) root(addr netip.Addr) *trieNode {
+	if addr.Is4() {
+		return t.root4
+	}
+	return t.root6
+}
+
+// insert adds r, replacing an existing route for the same prefix, kind and
+// peer.
+func (t *routeTable) insert(r Route) {
+	r.Prefix = unmapPrefix(r.Prefix).Masked()
+	node := t.root(r.Prefix.Addr())
+	addr := r.Prefix.Addr().AsSlice()
+	for i := 0; i < r.Prefix.Bits(); i++ {
+		b := bit(addr, i)
+		if node.child[b] == nil {
+			node.child[b] = &trieNode{}
+		}
+		node = node.child[b]
+	}
+
+	for i := range node.routes {
+		if node.routes[i].Kind == r.Kind && node.routes[i].Peer == r.Peer {
+			node.routes[i] = r
+			node.sort()
+			return
+		}
+	}
+	node.routes = append(node.routes, r)
+	node.sort()
+	t.count++
+}
+
+// remove deletes the peer routes for prefix via peer, or all peer routes for
+// prefix if peer is empty. It reports whether anything was removed.
+func (t *routeTable) remove(prefix netip.Prefix, peer string) bool {
+	return t.removeIf(prefix, func(r Route) bool {
+		return r.Kind == RoutePeer && (peer == "" || r.Peer == peer)
+	})
+}
+
+// removeKind deletes the routes of kind for prefix.
+func (t *routeTable) removeKind(prefix netip.Prefix, kind RouteKind) bool {
+	return t.removeIf(prefix, func(r Route) bool { return r.Kind == kind })
+}
+
+func (t *routeTable) removeIf(prefix netip.Prefix, match func(Route) bool) bool {
+	prefix = unmapPrefix(prefix).Masked()
+	node := t.root(prefix.Addr())
+	addr := prefix.Addr().AsSlice()
+	for i := 0; i < prefix.Bits() && node != nil; i++ {
+		node = node.child[bit(addr, i)]
+	}
+	if node == nil {
+		return false
+	}
+
+	kept := node.routes[:0]
+	for _, r := range node.routes {
+		if match(r) {
+			t.count--
+			continue
+		}
+		kept = append(kept, r)
+	}
+	removed := len(kept) != len(node.routes)
+	node.routes = kept
+	return removed
+}
+
+// lookup returns the best route for addr: the longest matching prefix, and
+// the lowest metric among routes for that prefix.
+func (t *routeTable) lookup(addr netip.Addr) (Route, bool) {
+	addr = addr.Unmap()
+	node := t.root(addr)
+	bytes := addr.AsSlice()
+
+	var best *trieNode
+	for i := 0; node != nil; i++ {
+		if len(node.routes) > 0 {
+			best = node
+		}
+		if i == addr.BitLen() {
+			break
+		}
+		node = node.child[bit(bytes, i)]
+	}
+	if best == nil {
+		return Route{}, false
+	}
+	return best.routes[0], true
+}
+
+func (t *routeTable) all() []Route {
+	routes := make([]Route, 0, t.count)
+	var walk func(n *trieNode)
+	walk = func(n *trieNode) {
+		if n == nil {
+			return
+		}
+		routes = append(routes, n.routes...)
+		walk(n.child[0])
+		walk(n.child[1])
+	}
+	walk(t.root4)
+	walk(t.root6)
+	return routes
+}
+
+func (t *routeTable) len() int {
+	return t.count
+}
+
+// sort orders routes by metric, then peer. Split tunneling routes name no
+// peer and so win ties.
+func (n *trieNode) sort() {
+	sort.SliceStable(n.routes, func(i, j int) bool {
+		if n.routes[i].Metric != n.routes[j].Metric {
+			return n.routes[i].Metric < n.routes[j].Metric
+		}
+		return n.routes[i].Peer < n.routes[j].Peer
+	})
+}
+
+func bit(addr []byte, i int) int {
+	return int(addr[i/8]>>(7-uint(i%8))) & 1
+}
+
+// unmapPrefix turns IPv4-mapped IPv6 prefixes into plain IPv4 prefixes.
+func unmapPrefix(p netip.Prefix) netip.Prefix {
+	if p.Addr().Is4In6() {
+		bits := p.Bits() - 96
+		if bits < 0 {
+			bits = 0
+		}
+		return netip.PrefixFrom(p.Addr().Unmap(), bits)
+	}
+	return p
+}
+
+func addrFromIP(ip net.IP) (netip.Addr, bool) {
+	addr, ok := netip.AddrFromSlice(ip)
+	return addr.Unmap(), ok
+}
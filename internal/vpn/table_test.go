@@ -0,0 +1,167 @@
+package vpn
+
+import (
+	"encoding/json"
+	"net/netip"
+	"testing"
+)
+
+func TestRouteTableLongestPrefixMatch(t *testing.T) {
+	table := newRouteTable()
+	table.insert(Route{Prefix: netip.MustParsePrefix("10.0.0.0/8"), Peer: "wide", Metric: DefaultRouteMetric})
+	table.insert(Route{Prefix: netip.MustParsePrefix("10.1.0.0/16"), Peer: "narrow", Metric: DefaultRouteMetric})
+	table.insert(Route{Prefix: netip.MustParsePrefix("10.1.2.3/32"), Peer: "host", Metric: HostRouteMetric})
+	table.insert(Route{Prefix: netip.MustParsePrefix("fd00::/8"), Peer: "v6", Metric: DefaultRouteMetric})
+
+	tests := []struct {
+		addr string
+		peer string
+	}{
+		{"10.2.0.1", "wide"},
+		{"10.1.0.1", "narrow"},
+		{"10.1.2.3", "host"},
+		{"::ffff:10.1.2.3", "host"},
+		{"fd00::1", "v6"},
+		{"192.168.0.1", ""},
+		{"fe80::1", ""},
+	}
+	for _, tt := range tests {
+		route, ok := table.lookup(netip.MustParseAddr(tt.addr))
+		if ok != (tt.peer != "") || route.Peer != tt.peer {
+			t.Errorf("lookup(%s) = %q, %v, want %q", tt.addr, route.Peer, ok, tt.peer)
+		}
+	}
+	if n := table.len(); n != 4 {
+		t.Fatalf("len %d, want 4", n)
+	}
+}
+
+func TestRouteTableMetric(t *testing.T) {
+	table := newRouteTable()
+	prefix := netip.MustParsePrefix("192.168.1.0/24")
+	table.insert(Route{Prefix: prefix, Peer: "b", Metric: 200})
+	table.insert(Route{Prefix: prefix, Peer: "a", Metric: 100})
+	table.insert(Route{Prefix: prefix, Peer: "c", Metric: 100})
+
+	addr := netip.MustParseAddr("192.168.1.1")
+	if route, _ := table.lookup(addr); route.Peer != "a" {
+		t.Fatalf("best route via %q, want a (lowest metric, then peer)", route.Peer)
+	}
+
+	// Re-inserting a peer's route updates it in place
+	table.insert(Route{Prefix: prefix, Peer: "b", Metric: 10})
+	if route, _ := table.lookup(addr); route.Peer != "b" {
+		t.Fatalf("best route via %q, want b after metric update", route.Peer)
+	}
+	if n := table.len(); n != 3 {
+		t.Fatalf("len %d, want 3", n)
+	}
+}
+
+func TestRouteTableRemove(t *testing.T) {
+	table := newRouteTable()
+	prefix := netip.MustParsePrefix("192.168.1.0/24")
+	table.insert(Route{Prefix: prefix, Peer: "a", Metric: 100})
+	table.insert(Route{Prefix: prefix, Peer: "b", Metric: 200})
+	table.insert(Route{Prefix: netip.MustParsePrefix("192.168.0.0/16"), Peer: "c", Metric: 100})
+
+	if table.remove(prefix, "missing") {
+		t.Fatal("removed a route of an unknown peer")
+	}
+	if !table.remove(prefix, "a") {
+		t.Fatal("route via a not removed")
+	}
+	addr := netip.MustParseAddr("192.168.1.1")
+	if route, _ := table.lookup(addr); route.Peer != "b" {
+		t.Fatalf("best route via %q, want b", route.Peer)
+	}
+
+	// An empty peer removes every route for the prefix, exposing the
+	// shorter one
+	table.insert(Route{Prefix: prefix, Peer: "a", Metric: 100})
+	if !table.remove(prefix, "") {
+		t.Fatal("routes not removed")
+	}
+	if route, _ := table.lookup(addr); route.Peer != "c" {
+		t.Fatalf("best route via %q, want c", route.Peer)
+	}
+	if table.remove(netip.MustParsePrefix("172.16.0.0/12"), "") {
+		t.Fatal("removed a prefix that was never inserted")
+	}
+	if n := table.len(); n != 1 {
+		t.Fatalf("len %d, want 1", n)
+	}
+}
+
+func TestRouteTableMasksPrefixes(t *testing.T) {
+	table := newRouteTable()
+	table.insert(Route{Prefix: netip.MustParsePrefix("10.1.2.3/16"), Peer: "a"})
+	table.insert(Route{Prefix: netip.MustParsePrefix("::ffff:172.16.0.0/108"), Peer: "b"})
+
+	routes := table.all()
+	if len(routes) != 2 {
+		t.Fatalf("got %d routes, want 2", len(routes))
+	}
+	want := map[string]string{"10.1.0.0/16": "a", "172.16.0.0/12": "b"}
+	for _, route := range routes {
+		if want[route.Prefix.String()] != route.Peer {
+			t.Errorf("unexpected route %s via %s", route.Prefix, route.Peer)
+		}
+	}
+	if !table.remove(netip.MustParsePrefix("10.1.255.255/16"), "a") {
+		t.Fatal("unmasked prefix not removed")
+	}
+}
+
+func TestRouteTableDefaultRoute(t *testing.T) {
+	table := newRouteTable()
+	table.insert(Route{Prefix: netip.MustParsePrefix("0.0.0.0/0"), Peer: "exit", Metric: DefaultRouteMetric})
+	if route, ok := table.lookup(netip.MustParseAddr("8.8.8.8")); !ok || route.Peer != "exit" {
+		t.Fatalf("lookup = %q, %v, want exit", route.Peer, ok)
+	}
+	if _, ok := table.lookup(netip.MustParseAddr("2001:db8::1")); ok {
+		t.Fatal("IPv4 default route matched an IPv6 address")
+	}
+}
+
+func TestRouteTableKinds(t *testing.T) {
+	table := newRouteTable()
+	prefix := netip.MustParsePrefix("192.168.1.0/24")
+	table.insert(Route{Prefix: prefix, Peer: "router", Metric: DefaultRouteMetric})
+	table.insert(Route{Prefix: prefix, Kind: RouteExit, Metric: DefaultRouteMetric})
+
+	// Split tunneling routes win ties and are not removed with peer routes
+	addr := netip.MustParseAddr("192.168.1.9")
+	if route, _ := table.lookup(addr); route.Kind != RouteExit {
+		t.Fatalf("best route %+v, want the exit route", route)
+	}
+	table.remove(prefix, "")
+	if route, ok := table.lookup(addr); !ok || route.Kind != RouteExit {
+		t.Fatalf("exit route removed with peer routes: %+v, %v", route, ok)
+	}
+
+	table.insert(Route{Prefix: prefix, Peer: "router", Metric: DefaultRouteMetric})
+	if !table.removeKind(prefix, RouteExit) {
+		t.Fatal("exit route not removed")
+	}
+	if route, _ := table.lookup(addr); route.Kind != RoutePeer || route.Peer != "router" {
+		t.Fatalf("best route %+v, want the peer route", route)
+	}
+}
+
+func TestRouteJSON(t *testing.T) {
+	data, err := json.Marshal(Route{Prefix: netip.MustParsePrefix("10.0.0.0/8"), Kind: RouteBypass, Metric: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"prefix":"10.0.0.0/8","kind":"bypass","metric":1}` {
+		t.Fatalf("got %s", data)
+	}
+	var route Route
+	if err := json.Unmarshal(data, &route); err != nil || route.Kind != RouteBypass {
+		t.Fatalf("decoded %+v, %v", route, err)
+	}
+	if err := json.Unmarshal([]byte(`{"kind":"tunnel"}`), &route); err == nil {
+		t.Fatal("unknown route kind accepted")
+	}
+}
@@ -1,30 +1,56 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
 	"text/tabwriter"
-	"time"
 
 	"nghost/internal/config"
+	"nghost/internal/control"
+	"nghost/internal/identity"
+	"nghost/internal/logging"
+	"nghost/internal/metrics"
 	"nghost/internal/nkn"
+	"nghost/internal/simnet"
 	"nghost/internal/vpn"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "identity" {
+		if err := identityCmd(os.Args[2:]); err != nil {
+			log.Fatalf("Identity command failed: %v", err)
+		}
+		return
+	}
+
 	var (
-		configPath = flag.String("config", "config.json", "Path to configuration file")
-		exitNode   = flag.Bool("exit-node", false, "Run as exit node")
-		daemon     = flag.Bool("daemon", false, "Run as daemon (headless)")
-		listPeers   = flag.Bool("list-peers", false, "List discovered peers")
-		addPeer     = flag.String("add-peer", "", "Add peer by NKN address")
-		exportPeers = flag.String("export-peers", "", "Export peers to JSON file")
-		status      = flag.Bool("status", false, "Show network interface status")
+		configPath    = flag.String("config", "config.json", "Path to configuration file")
+		exitNode      = flag.Bool("exit-node", false, "Run as exit node")
+		fullTunnel    = flag.Bool("full-tunnel", false, "Route all internet traffic through exit nodes (overrides vpn.fullTunnel)")
+		daemon        = flag.Bool("daemon", false, "Run as daemon (headless)")
+		listPeers     = flag.Bool("list-peers", false, "List discovered peers")
+		addPeer       = flag.String("add-peer", "", "Add peer by NKN address")
+		exportPeers   = flag.String("export-peers", "", "Export peers to JSON file")
+		importPeers   = flag.String("import-peers", "", "Import peers from JSON file")
+		status        = flag.Bool("status", false, "Show engine and network interface status")
+		routes        = flag.Bool("routes", false, "List routes of the running daemon")
 		testDiscovery = flag.Bool("test-discovery", false, "Test exit node discovery")
-		connectPeer = flag.String("connect", "", "Connect to peer and start VPN")
+		connectPeer   = flag.String("connect", "", "Connect to peer and start VPN")
+		benchmark     = flag.Bool("bench-transport", false, "Compare latency and throughput of the NKN transports")
+		simulation    = flag.Bool("simulate", false, "Run end-to-end scenarios on simulated in-process nodes")
+		simNodes      = flag.Int("sim-nodes", 4, "Number of nodes in the simulated delivery scenario")
+		simLoss       = flag.Float64("sim-loss", 0, "Simulated message loss (0-1)")
+		simLatency    = flag.Duration("sim-latency", 0, "Simulated one-way latency")
+		simJitter     = flag.Duration("sim-jitter", 0, "Simulated random extra latency")
+		simReorder    = flag.Float64("sim-reorder", 0, "Simulated share of reordered messages (0-1)")
+		logLevel      = flag.String("log-level", "", "Log level: debug, info, warn or error (overrides log.level)")
+		logFormat     = flag.String("log-format", "", "Log format: text or json (overrides log.format)")
 	)
 	flag.Parse()
 
@@ -50,7 +76,22 @@ func main() {
 		return
 	}
 
+	if *importPeers != "" {
+		if err := importPeersCmd(*configPath, *importPeers); err != nil {
+			log.Fatalf("Failed to import peers: %v", err)
+		}
+		return
+	}
+
+	if *routes {
+		if err := listRoutes(*configPath); err != nil {
+			log.Fatalf("Failed to list routes: %v", err)
+		}
+		return
+	}
+
 	if *status {
+		showEngineStatus(*configPath)
 		showNetworkStatus()
 		return
 	}
@@ -62,48 +103,129 @@ func main() {
 		return
 	}
 
+	if *benchmark {
+		if err := benchTransport(*configPath); err != nil {
+			log.Fatalf("Transport benchmark failed: %v", err)
+		}
+		return
+	}
+
+	if *simulation {
+		// The engines of all nodes log to the same output; keep it to
+		// problems unless asked for more
+		level := *logLevel
+		if level == "" {
+			level = "warn"
+		}
+		if err := logging.Configure(config.LogConfig{Level: level, Format: *logFormat}, nil); err != nil {
+			log.Fatalf("Invalid logging options: %v", err)
+		}
+		cond := simnet.Conditions{Loss: *simLoss, Latency: *simLatency, Jitter: *simJitter, Reorder: *simReorder}
+		if err := simulate(*simNodes, cond); err != nil {
+			log.Fatalf("Simulation failed: %v", err)
+		}
+		return
+	}
+
 	cfg, err := config.Load(*configPath)
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 
+	if *logLevel != "" {
+		cfg.Log.Level = *logLevel
+	}
+	if *logFormat != "" {
+		cfg.Log.Format = *logFormat
+	}
+	if err := logging.Configure(cfg.Log, nil); err != nil {
+		log.Fatalf("Invalid log configuration: %v", err)
+	}
+	logger := logging.Logger(logging.Main)
+
+	if *fullTunnel {
+		cfg.VPN.FullTunnel = true
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if cfg.NKN.Transport == nkn.TransportUDP && len(cfg.VPN.AllowedPeers) > 0 {
+		log.Fatalf("vpn.allowedPeers lists NKN public keys and cannot be used with the UDP transport")
+	}
+
 	nknClient, err := nkn.NewClient(cfg.NKN)
 	if err != nil {
 		log.Fatalf("Failed to create NKN client: %v", err)
 	}
-	defer nknClient.Close()
 
 	vpnEngine, err := vpn.NewEngine(cfg.VPN, nknClient)
 	if err != nil {
+		nknClient.Close()
 		log.Fatalf("Failed to create VPN engine: %v", err)
 	}
 
+	// shutdown tears down in reverse order of startup; the engine says
+	// goodbye through the client, so the client goes last
+	shutdown := func() {
+		vpnEngine.Stop()
+		nknClient.Close()
+	}
+	fatal := func(msg string, err error) {
+		shutdown()
+		logger.Error(msg, "err", err)
+		os.Exit(1)
+	}
+
 	// Add peer connection if specified
 	if *connectPeer != "" {
-		fmt.Printf("🔗 Connecting to peer: %s\n", (*connectPeer)[:16]+"...")
+		if err := nknClient.ValidateAddress(*connectPeer); err != nil {
+			fatal("invalid peer address", err)
+		}
+		logger.Info("connecting to peer", "peer", *connectPeer)
 		nknClient.AddPeer(*connectPeer)
 	}
 
 	if *exitNode {
-		fmt.Println("Starting NGhost as exit node...")
+		logger.Info("starting NGhost as exit node")
 		if err := vpnEngine.StartExitNode(); err != nil {
-			log.Fatalf("Failed to start exit node: %v", err)
+			fatal("failed to start exit node", err)
 		}
 	} else if *daemon {
-		fmt.Println("Starting NGhost daemon...")
+		logger.Info("starting NGhost daemon")
 		if err := vpnEngine.StartDaemon(); err != nil {
-			log.Fatalf("Failed to start daemon: %v", err)
+			fatal("failed to start daemon", err)
 		}
 	} else {
-		fmt.Println("Starting NGhost GUI...")
 		// TODO: Launch GUI application
-		fmt.Println("GUI not implemented yet, running in daemon mode")
+		logger.Info("GUI not implemented yet, running in daemon mode")
 		if err := vpnEngine.StartDaemon(); err != nil {
-			log.Fatalf("Failed to start daemon: %v", err)
+			fatal("failed to start daemon", err)
+		}
+	}
+
+	controlServer, err := startControlServer(cfg.Control.SocketPath, vpnEngine, nknClient)
+	if err != nil {
+		fatal("failed to start control socket", err)
+	}
+
+	metricsServer := metrics.NewServer(cfg.Metrics.Listen)
+	if cfg.Metrics.Listen != "" {
+		if err := metricsServer.Start(); err != nil {
+			controlServer.Close()
+			fatal("failed to start metrics endpoint", err)
 		}
 	}
 
-	select {} // Keep running
+	<-ctx.Done()
+	// A second signal kills the process right away
+	stop()
+
+	logger.Info("shutting down")
+	controlServer.Close()
+	metricsServer.Close()
+	shutdown()
+	logger.Info("bye")
 }
 
 // Helper functions for peer management commands
@@ -119,30 +241,60 @@ func exportPeersCmd(configPath, outputPath string) error {
 	return exportPeers(configPath, outputPath)
 }
 
-func listPeers(configPath string) error {
+func importPeersCmd(configPath, inputPath string) error {
+	return importPeers(configPath, inputPath)
+}
+
+// openPeerStore returns the peer store shared with the running daemon.
+func openPeerStore(configPath string) (*config.Config, *nkn.PeerStore, error) {
 	cfg, err := config.Load(configPath)
 	if err != nil {
-		return fmt.Errorf("failed to load config: %w", err)
+		return nil, nil, fmt.Errorf("failed to load config: %w", err)
 	}
+	return cfg, nkn.NewPeerStore(cfg.NKN.PeersFile), nil
+}
 
-	nknClient, err := nkn.NewClient(cfg.NKN)
+// loadPeers asks the running daemon for its peers and falls back to the peer
+// store when no daemon is running.
+func loadPeers(cfg *config.Config, store *nkn.PeerStore) (map[string]*nkn.Peer, bool, error) {
+	var peers map[string]*nkn.Peer
+	running, err := callDaemon(cfg, control.MethodPeers, nil, &peers)
 	if err != nil {
-		return fmt.Errorf("failed to create NKN client: %w", err)
+		return nil, false, err
+	}
+	if running {
+		return peers, true, nil
 	}
-	defer func() {
-		// Ensure clean shutdown
-		if cerr := nknClient.Close(); cerr != nil {
-			fmt.Printf("Warning: error closing NKN client: %v\n", cerr)
-		}
-	}()
 
-	fmt.Println("NGhost Peer Status")
-	fmt.Printf("Your NKN Address: %s\n\n", nknClient.GetAddress())
+	peers, err = store.Load()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to load peers: %w", err)
+	}
+	return peers, false, nil
+}
+
+func listPeers(configPath string) error {
+	cfg, store, err := openPeerStore(configPath)
+	if err != nil {
+		return err
+	}
+
+	peers, running, err := loadPeers(cfg, store)
+	if err != nil {
+		return err
+	}
 
-	// Give client a moment to connect
-	time.Sleep(2 * time.Second)
+	fmt.Println("NGhost Peer Status")
+	if !running {
+		fmt.Println("(daemon not running - showing stored peers)")
+	}
+	if id, err := identity.NewStore(cfg.NKN.IdentityFile).Load(); err == nil {
+		address, _ := id.Address()
+		fmt.Printf("Your NKN Address: %s\n\n", address)
+	} else {
+		fmt.Println()
+	}
 
-	peers := nknClient.GetPeers()
 	if len(peers) == 0 {
 		fmt.Println("No peers discovered yet.")
 		fmt.Println("\n💡 To add peers manually:")
@@ -154,14 +306,18 @@ func listPeers(configPath string) error {
 	fmt.Fprintln(w, "ADDRESS\tIP\tSTATUS\tEXIT NODE\tLAST SEEN")
 	fmt.Fprintln(w, "-------\t--\t------\t---------\t---------")
 
+	exitNodes := 0
 	for _, peer := range peers {
-		status := "offline"
-		if peer.Online {
-			status = "online"
+		status := string(peer.State)
+		if status == "" {
+			status = string(nkn.PeerOffline)
 		}
 		exitNode := "no"
 		if peer.ExitNode {
 			exitNode = "yes"
+			if peer.State == nkn.PeerOnline {
+				exitNodes++
+			}
 		}
 		lastSeen := peer.LastSeen.Format("15:04:05")
 		if peer.LastSeen.IsZero() {
@@ -169,7 +325,7 @@ func listPeers(configPath string) error {
 		}
 
 		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
-			peer.Address[:16]+"...",
+			nkn.ShortAddress(peer.Address),
 			peer.IPAddress,
 			status,
 			exitNode,
@@ -177,45 +333,56 @@ func listPeers(configPath string) error {
 	}
 	w.Flush()
 
-	exitNodes := nknClient.FindExitNodes()
-	if len(exitNodes) > 0 {
-		fmt.Printf("\nAvailable exit nodes: %d\n", len(exitNodes))
+	if exitNodes > 0 {
+		fmt.Printf("\nAvailable exit nodes: %d\n", exitNodes)
 	}
 
 	return nil
 }
 
 func addPeer(configPath, peerAddr string) error {
-	cfg, err := config.Load(configPath)
+	cfg, store, err := openPeerStore(configPath)
 	if err != nil {
-		return fmt.Errorf("failed to load config: %w", err)
+		return err
+	}
+	if err := nkn.ValidateAddress(cfg.NKN, peerAddr); err != nil {
+		return err
 	}
 
-	nknClient, err := nkn.NewClient(cfg.NKN)
+	running, err := callDaemon(cfg, control.MethodAddPeer, control.AddPeerParams{Address: peerAddr}, nil)
 	if err != nil {
-		return fmt.Errorf("failed to create NKN client: %w", err)
+		return err
+	}
+	if running {
+		fmt.Printf("Added peer to running daemon: %s\n", peerAddr)
+		return nil
 	}
-	defer nknClient.Close()
 
-	nknClient.AddPeer(peerAddr)
-	fmt.Printf("Added peer: %s\n", peerAddr)
+	err = store.Update(func(peers map[string]*nkn.Peer) error {
+		if _, exists := peers[peerAddr]; !exists {
+			peers[peerAddr] = &nkn.Peer{Address: peerAddr}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save peer: %w", err)
+	}
 
+	fmt.Printf("Added peer: %s\n", peerAddr)
 	return nil
 }
 
 func exportPeers(configPath, outputPath string) error {
-	cfg, err := config.Load(configPath)
+	cfg, store, err := openPeerStore(configPath)
 	if err != nil {
-		return fmt.Errorf("failed to load config: %w", err)
+		return err
 	}
 
-	nknClient, err := nkn.NewClient(cfg.NKN)
+	peers, _, err := loadPeers(cfg, store)
 	if err != nil {
-		return fmt.Errorf("failed to create NKN client: %w", err)
+		return err
 	}
-	defer nknClient.Close()
 
-	peers := nknClient.GetPeers()
 	data, err := json.MarshalIndent(peers, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal peers: %w", err)
@@ -227,4 +394,51 @@ func exportPeers(configPath, outputPath string) error {
 
 	fmt.Printf("Exported %d peers to %s\n", len(peers), outputPath)
 	return nil
-}
\ No newline at end of file
+}
+
+// importPeers adds the addresses of the peers in inputPath to the peer store.
+// Everything else about a peer, such as its VPN IP, routes or exit node role,
+// is only learned from the peer itself, so those fields are not imported.
+func importPeers(configPath, inputPath string) error {
+	cfg, store, err := openPeerStore(configPath)
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(inputPath)
+	if err != nil {
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+
+	var imported map[string]*nkn.Peer
+	if err := json.Unmarshal(data, &imported); err != nil {
+		return fmt.Errorf("failed to parse peers: %w", err)
+	}
+
+	var valid []string
+	for addr := range imported {
+		if err := nkn.ValidateAddress(cfg.NKN, addr); err != nil {
+			fmt.Printf("Skipping peer: %v\n", err)
+			continue
+		}
+		valid = append(valid, addr)
+	}
+
+	added := 0
+	err = store.Update(func(peers map[string]*nkn.Peer) error {
+		for _, addr := range valid {
+			if _, exists := peers[addr]; exists {
+				continue
+			}
+			peers[addr] = &nkn.Peer{Address: addr}
+			added++
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save peers: %w", err)
+	}
+
+	fmt.Printf("Imported %d new peers from %s\n", added, inputPath)
+	return nil
+}
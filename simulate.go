@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"nghost/internal/simnet"
+)
+
+// simulate runs the simnet scenarios and prints a report. It fails if any
+// scenario fails.
+func simulate(nodes int, cond simnet.Conditions) error {
+	if nodes < 2 {
+		return fmt.Errorf("need at least 2 nodes, got %d", nodes)
+	}
+
+	fmt.Printf("🧪 Simulating %d nodes (%s)\n", nodes, cond)
+	results := simnet.Run(simnet.Scenarios, nodes, cond)
+
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SCENARIO\tRESULT\tTIME\tDETAILS")
+	fmt.Fprintln(w, "--------\t------\t----\t-------")
+	failed := 0
+	for _, r := range results {
+		result, details := "PASS", r.Summary
+		if r.Err != nil {
+			result, details = "FAIL", r.Err.Error()
+			failed++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%v\t%s\n", r.Scenario, result, r.Duration.Round(100*time.Millisecond), details)
+	}
+	w.Flush()
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d scenarios failed", failed, len(results))
+	}
+	return nil
+}
@@ -15,8 +15,14 @@ func testExitNodeDiscovery(configPath string) error {
 	}
 
 	fmt.Println("🔍 Testing exit node discovery...")
-	
-	nknClient, err := nkn.NewClient(cfg.NKN)
+
+	// A throwaway identity and no peer store, so the test neither clashes
+	// with a running daemon's address nor touches its peers
+	probe := cfg.NKN
+	probe.IdentityFile = ""
+	probe.PeersFile = ""
+
+	nknClient, err := nkn.NewClient(probe)
 	if err != nil {
 		return fmt.Errorf("failed to create NKN client: %w", err)
 	}
@@ -24,26 +30,42 @@ func testExitNodeDiscovery(configPath string) error {
 
 	fmt.Printf("📡 Your NKN Address: %s\n", nknClient.GetAddress())
 	fmt.Println("🔗 Waiting for NKN connection...")
-	
+
+	var topic string
+	if cfg.VPN.NetworkName != "" {
+		topic = nkn.DiscoveryTopic(cfg.VPN.NetworkName, cfg.VPN.NetworkKey)
+		fmt.Printf("🔎 Discovering peers on topic %s\n", topic)
+	} else {
+		fmt.Println("💡 Set vpn.networkName to discover peers through NKN pub/sub")
+	}
+
 	// Wait for connection and discovery
 	for i := 0; i < 30; i++ {
 		time.Sleep(1 * time.Second)
-		
+
+		// Ask topic members to announce themselves once connected, and
+		// again in case the first publish went out too early
+		if topic != "" && i%10 == 2 {
+			if err := nknClient.Discover(topic); err != nil {
+				fmt.Printf("\n⚠️  Topic discovery failed: %v\n", err)
+			}
+		}
+
 		peers := nknClient.GetPeers()
 		exitNodes := nknClient.FindExitNodes()
-		
+
 		fmt.Printf("\r⏱️  %02ds - Peers: %d, Exit nodes: %d", i+1, len(peers), len(exitNodes))
-		
+
 		if len(exitNodes) > 0 {
 			fmt.Printf("\n🎉 Found exit nodes!\n")
 			for _, node := range exitNodes {
-				fmt.Printf("  🚪 %s (IP: %s)\n", node.Address[:16]+"...", node.IPAddress)
+				fmt.Printf("  🚪 %s (IP: %s)\n", nkn.ShortAddress(node.Address), node.IPAddress)
 			}
 			return nil
 		}
 	}
-	
+
 	fmt.Printf("\n❌ No exit nodes discovered after 30 seconds\n")
 	fmt.Println("💡 Make sure an exit node is running with: sudo ./nghost -exit-node")
 	return nil
-}
\ No newline at end of file
+}